@@ -1,37 +1,125 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/Nyagar-Abraham/chat-app/config"
 	"github.com/Nyagar-Abraham/chat-app/db"
 	"github.com/Nyagar-Abraham/chat-app/handlers"
 	"github.com/Nyagar-Abraham/chat-app/middleware"
 	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/tracing"
+	"github.com/Nyagar-Abraham/chat-app/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
 func main() {
-	// load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Printf("No .env file found, relying on environment variables")
+	//	"chat-app migrate create" only writes files, so it runs without any configuration
+	if len(os.Args) > 2 && os.Args[1] == "migrate" && os.Args[2] == "create" {
+		os.Exit(migrateCreateCommand(os.Args[3:]))
+	}
+	//	load and validate the configuration (environment, .env and CONFIG_FILE)
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := utils.InitLogger(cfg.Log.Level, cfg.Log.Format); err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	//	Maintenance commands, e.g. "chat-app migrate up", run instead of the server
+	//	and need neither the JWT keys nor Stream
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1:]))
+	}
+	gin.SetMode(cfg.GinMode)
+	if err := utils.InitKeys(); err != nil {
+		fatal("Failed to load JWT signing keys", err)
+	}
+	streamClient, err := services.NewStreamClient(cfg.Stream.APIKey, cfg.Stream.APISecret)
+	if err != nil {
+		fatal("Failed to create Stream client", err)
+	}
+	services.SetStreamClient(streamClient)
+	services.SetMailer(newMailer(cfg.Mail))
+	services.SetAppBaseURL(cfg.AppBaseURL)
+	services.ConfigureOIDC(cfg.OIDC.RedirectURL, cfg.OIDC.AllowPrivateNetworks)
+	if cfg.DNS.Resolver == "static" {
+		services.SetTXTResolver(services.ParseStaticTXTRecords(cfg.DNS.StaticTXTRecords))
 	}
 	//	connect db
-	db.Connect()
+	db.Connect(cfg.Database.URL)
+	if cfg.Database.MigrateOnStart {
+		applied, err := db.MigrateUp(context.Background())
+		if err != nil {
+			fatal("Failed to migrate the database", err)
+		}
+		slog.Info("Database migrated", "applied", len(applied))
+	}
+	repos := repository.NewGORM(db.DB)
+	handlers.SetRepositories(repos)
+	slog.Info("Configuration loaded", "config", cfg)
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		fatal("Invalid tracing configuration", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+	}()
+	if err := services.SeedDefaultPermissions(); err != nil {
+		fatal("Failed to seed default permissions", err)
+	}
+	if err := services.SeedPlans(); err != nil {
+		fatal("Failed to seed plans", err)
+	}
+	if err := services.BootstrapPlatformAdmins(); err != nil {
+		fatal("Failed to grant platform admins", err)
+	}
+	if cfg.AuditCheckpointInterval > 0 {
+		go services.CreateAuditCheckpointsPeriodically(cfg.AuditCheckpointInterval)
+	}
 
 	//	Configure CORS
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
-	config.AllowHeaders = append(config.AllowHeaders, "Authorization")
+	config.AllowHeaders = append(config.AllowHeaders, "Authorization", middleware.RequestIDHeader, "traceparent", "tracestate")
+	config.ExposeHeaders = append(config.ExposeHeaders, middleware.RequestIDHeader, "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset")
 
-	router := gin.Default()
+	router := gin.New()
+	//	Recovery runs inside RequestID, so that a panic's 500 carries the request ID as well
+	//	and the request span wraps everything so that logs and error bodies carry the trace ID
+	router.Use(tracing.Middleware(), middleware.RequestLogger(), middleware.RequestID(), middleware.Recovery(), middleware.Metrics())
+	//	Only trust X-Forwarded-For from our load balancer, or clients could pick their IP (and rate limit bucket)
+	if len(cfg.TrustedProxies) > 0 {
+		if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+			fatal("Invalid TRUSTED_PROXIES", err)
+		}
+	}
 	router.Use(cors.New(config))
 
+	//	Rate limits per route group, overridable with RATE_LIMIT_<GROUP> (e.g. "10/1m,5" or "off")
+	services.SetRateLimitStore(services.NewRateLimitStore(cfg.RateLimit.Backend))
+	globalLimit := rateLimit(cfg.RateLimit, "global", services.RateLimit{Requests: 600, Period: time.Minute, Burst: 200})
+	authLimit := rateLimit(cfg.RateLimit, "auth", services.RateLimit{Requests: 10, Period: time.Minute, Burst: 10})
+	messageUserLimit := rateLimit(cfg.RateLimit, "messages_user", services.RateLimit{Requests: 60, Period: time.Minute, Burst: 20})
+	messageTenantLimit := rateLimit(cfg.RateLimit, "messages_tenant", services.RateLimit{Requests: 1200, Period: time.Minute, Burst: 300})
+	scimLimit := rateLimit(cfg.RateLimit, "scim", services.RateLimit{Requests: 600, Period: time.Minute, Burst: 100})
+	scimIPLimit := rateLimit(cfg.RateLimit, "scim_ip", services.RateLimit{Requests: 1200, Period: time.Minute, Burst: 200})
+	authRateLimit := middleware.RateLimit("auth", authLimit, middleware.ByIP)
+	router.Use(middleware.RateLimit("global", globalLimit, middleware.ByIP))
+
 	//	swagger docs endpoint
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -41,46 +129,162 @@ func main() {
 		})
 	})
 
+	//	Prometheus metrics, see METRICS_TOKEN
+	router.GET("/metrics", handlers.Metrics(cfg.Metrics.Token))
+
+	//	Public keys for verifying our access tokens
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
+
 	//	Auth endpoints
-	router.POST("/auth/login", handlers.Login)
-	router.POST("/auth/register", handlers.Register)
+	router.POST("/auth/login", authRateLimit, handlers.Login)
+	router.POST("/auth/register", authRateLimit, handlers.Register)
+	router.GET("/auth/invitations/lookup", authRateLimit, handlers.LookupInvitation)
+	router.POST("/auth/invitations/accept", authRateLimit, handlers.AcceptInvitation)
+	router.POST("/auth/refresh", authRateLimit, handlers.Refresh)
+	router.POST("/auth/login/2fa", authRateLimit, handlers.LoginSecondFactor)
+	router.POST("/auth/password/forgot", authRateLimit, handlers.ForgotPassword)
+	router.POST("/auth/password/reset", authRateLimit, handlers.ResetPassword)
+	router.POST("/auth/email/verify", authRateLimit, handlers.VerifyEmail)
+	router.POST("/auth/email/resend", middleware.JWTAuth(), handlers.ResendVerificationEmail)
+	router.POST("/auth/email/verify/resend", authRateLimit, handlers.RequestVerificationEmail)
+	router.POST("/auth/logout", middleware.JWTAuth(), handlers.Logout)
 	router.GET("/me", middleware.JWTAuth(), handlers.GetCurrentUser)
 
+	//	Single sign-on (OpenID Connect)
+	router.GET("/auth/oidc/:tenant_id/login", handlers.OIDCLogin)
+	router.GET("/auth/oidc/callback", handlers.OIDCCallback)
+
+	//	Two-factor authentication (enrollment also accepts the restricted enrollment token)
+	router.POST("/auth/2fa/enroll", middleware.JWTAuth(utils.TokenUseMFAEnrollment), handlers.EnrollTOTP)
+	router.POST("/auth/2fa/confirm", middleware.JWTAuth(utils.TokenUseMFAEnrollment), handlers.ConfirmTOTP)
+	router.POST("/auth/2fa/disable", middleware.JWTAuth(), handlers.DisableTOTP)
+	router.POST("/auth/2fa/recovery-codes", middleware.JWTAuth(), handlers.RegenerateRecoveryCodes)
+	router.PUT("/me/password", middleware.JWTAuth(), handlers.ChangePassword)
+
 	//steam Chat token endpoint
 	router.GET("/stream/token", middleware.JWTAuth(), handlers.StreamToken)
 
 	//Tenant endpoints
-	router.POST("/tenants", middleware.JWTAuth(), middleware.RequireRole(string(models.RoleAdmin)), handlers.CreateTenant)
-	router.GET("/tenants", handlers.ListTenants)
-	router.GET("/tenants/:id", handlers.GetTenant)
+	router.GET("/permissions", middleware.JWTAuth(), handlers.ListPermissions)
+	router.GET("/tenants", middleware.JWTAuth(), handlers.ListTenants)
+	router.GET("/tenants/:id", middleware.JWTAuth(), handlers.GetTenant)
+	router.GET("/tenants/:id/oidc", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.GetTenantOIDC)
+	router.PUT("/tenants/:id/oidc", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.UpdateTenantOIDC)
+	router.DELETE("/tenants/:id/oidc", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.DeleteTenantOIDC)
+	router.PATCH("/tenants/:id/security", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.UpdateTenantSecurity)
+	router.GET("/tenants/:id/permissions", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantPermissionsEdit), handlers.GetTenantPermissions)
+	router.PUT("/tenants/:id/permissions", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantPermissionsEdit), handlers.UpdateTenantPermission)
+	router.DELETE("/tenants/:id/permissions/:role/:permission", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantPermissionsEdit), handlers.DeleteTenantPermission)
+	router.GET("/tenants/:id/roles", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantPermissionsEdit), handlers.ListCustomRoles)
+	router.POST("/tenants/:id/roles", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantPermissionsEdit), handlers.CreateCustomRole)
+	router.PUT("/tenants/:id/roles/:role_id", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantPermissionsEdit), handlers.UpdateCustomRole)
+	router.DELETE("/tenants/:id/roles/:role_id", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantPermissionsEdit), handlers.DeleteCustomRole)
+	router.GET("/plans", middleware.JWTAuth(), handlers.ListPlans)
+	router.GET("/tenants/:id/usage", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.GetTenantUsage)
+	router.GET("/tenants/:id/settings", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.GetTenantSettings)
+	router.PATCH("/tenants/:id/settings", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.UpdateTenantSettings)
+	router.GET("/tenants/:id/domains", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.ListTenantDomains)
+	router.POST("/tenants/:id/domains", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.ClaimTenantDomain)
+	router.POST("/tenants/:id/domains/:domain_id/verify", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.VerifyTenantDomain)
+	router.DELETE("/tenants/:id/domains/:domain_id", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantManage), handlers.DeleteTenantDomain)
+	router.POST("/tenants/:id/invitations", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserInvite), handlers.CreateInvitation)
+	router.GET("/tenants/:id/invitations", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserInvite), handlers.ListInvitations)
+	router.POST("/tenants/:id/invitations/:invitation_id/resend", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserInvite), handlers.ResendInvitation)
+	router.DELETE("/tenants/:id/invitations/:invitation_id", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserInvite), handlers.RevokeInvitation)
+	router.POST("/tenants/:id/scim-tokens", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantSCIMManage), handlers.CreateSCIMToken)
+	router.GET("/tenants/:id/scim-tokens", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantSCIMManage), handlers.ListSCIMTokens)
+	router.DELETE("/tenants/:id/scim-tokens/:token_id", middleware.JWTAuth(), middleware.RequirePermission(models.PermTenantSCIMManage), handlers.DeleteSCIMToken)
+
+	// Audit log
+	router.GET("/audit-logs", middleware.JWTAuth(), middleware.RequirePermission(models.PermAuditRead), handlers.ListAuditLogs)
+	router.GET("/audit-logs/export", middleware.JWTAuth(), middleware.RequirePermission(models.PermAuditRead), handlers.ExportAuditLogs)
+	router.GET("/audit-logs/verify", middleware.JWTAuth(), middleware.RequirePermission(models.PermAuditRead), handlers.VerifyAuditLog)
+	router.GET("/audit-logs/checkpoints", middleware.JWTAuth(), middleware.RequirePermission(models.PermAuditRead), handlers.ExportAuditCheckpoints)
 
-	// User endpoints (Admin/Moderator for create/update, Admin for delete, all roles for list)
-	router.POST("/users", middleware.JWTAuth(), middleware.RequireRole(string(models.RoleAdmin), string(models.RoleModerator)), handlers.CreateUser)
+	// User endpoints (guarded by named permissions, see services.DefaultRolePermissions)
+	router.POST("/users", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserCreate), handlers.CreateUser)
 	router.GET("/users", middleware.JWTAuth(), handlers.ListUsers)
-	router.PUT("/users/:id", middleware.JWTAuth(), middleware.RequireRole(string(models.RoleAdmin), string(models.RoleModerator)), handlers.UpdateUser)
-	router.DELETE("/users/:id", middleware.JWTAuth(), middleware.RequireRole(string(models.RoleAdmin)), handlers.DeleteUser)
+	router.PUT("/users/:id", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserUpdate), handlers.UpdateUser)
+	router.DELETE("/users/:id", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserDelete), handlers.DeleteUser)
+	router.POST("/users/:id/unlock", middleware.JWTAuth(), middleware.RequirePermission(models.PermUserUnlock), handlers.UnlockUser)
 
-	// Channel endpoints (Admin/Moderator for create, all roles for list)
-	router.POST("/channels", middleware.JWTAuth(), middleware.RequireRole(string(models.RoleAdmin), string(models.RoleModerator)), handlers.CreateChannel)
+	// Channel endpoints
+	router.POST("/channels", middleware.JWTAuth(), middleware.RequirePermission(models.PermChannelCreate), handlers.CreateChannel)
 	router.GET("/channels", middleware.JWTAuth(), handlers.ListChannels)
 
 	// Channel membership endpoints
-	router.POST("/channels/:id/members", middleware.JWTAuth(), middleware.RequireRole(string(models.RoleAdmin), string(models.RoleModerator)), handlers.AddUserToChannel)
-	router.DELETE("/channels/:id/members/:user_id", middleware.JWTAuth(), middleware.RequireRole(string(models.RoleAdmin), string(models.RoleModerator)), handlers.RemoveUserFromChannel)
+	router.POST("/channels/:id/members", middleware.JWTAuth(), middleware.RequirePermission(models.PermChannelMembersManage), handlers.AddUserToChannel)
+	router.DELETE("/channels/:id/members/:user_id", middleware.JWTAuth(), middleware.RequirePermission(models.PermChannelMembersManage), handlers.RemoveUserFromChannel)
 	router.GET("/channels/:id/members", middleware.JWTAuth(), handlers.GetChannelMembers)
-	router.POST("/channels/:id/join", middleware.JWTAuth(), handlers.JoinChannel)
-	router.POST("/channels/:id/leave", middleware.JWTAuth(), handlers.LeaveChannel)
+	router.POST("/channels/:id/join", middleware.JWTAuth(), middleware.RequirePermission(models.PermChannelJoin), handlers.JoinChannel)
+	router.POST("/channels/:id/leave", middleware.JWTAuth(), middleware.RequirePermission(models.PermChannelJoin), handlers.LeaveChannel)
 
-	// Messages endpoint (all authenticated users)
-	router.POST("/messages", middleware.JWTAuth(), handlers.SendMessage)
+	// Messages endpoints
+	router.POST("/messages", middleware.JWTAuth(), middleware.RateLimit("messages_user", messageUserLimit, middleware.ByUser), middleware.RateLimit("messages_tenant", messageTenantLimit, middleware.ByTenant), middleware.RequirePermission(models.PermMessageSend), handlers.SendMessage)
 	router.GET("/messages/:stream_id", middleware.JWTAuth(), handlers.GetMessages)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8085"
+	router.DELETE("/messages/:message_id", middleware.JWTAuth(), handlers.DeleteMessage)
+
+	// Platform admin endpoints (cross-tenant, platform operators only)
+	admin := router.Group("/admin", middleware.JWTAuth(), middleware.RequirePlatformAdmin())
+	admin.GET("/tenants", handlers.AdminListTenants)
+	admin.POST("/tenants", handlers.CreateTenant)
+	admin.POST("/tenants/:id/suspend", handlers.SuspendTenant)
+	admin.POST("/tenants/:id/reactivate", handlers.ReactivateTenant)
+	admin.PUT("/tenants/:id/plan", handlers.SetTenantPlan)
+
+	// SCIM 2.0 provisioning (per-tenant SCIM bearer token). The per-IP limit
+	// comes first so that unauthenticated requests cannot hammer the token lookup.
+	scim := router.Group("/scim/v2",
+		middleware.RateLimit("scim_ip", scimIPLimit, middleware.ByIP),
+		middleware.SCIMAuth(repos),
+		middleware.RateLimit("scim", scimLimit, middleware.ByTenant))
+	scim.GET("/ServiceProviderConfig", handlers.SCIMServiceProviderConfig)
+	scim.GET("/Users", handlers.ListSCIMUsers)
+	scim.POST("/Users", handlers.CreateSCIMUser)
+	scim.GET("/Users/:id", handlers.GetSCIMUser)
+	scim.PUT("/Users/:id", handlers.ReplaceSCIMUser)
+	scim.PATCH("/Users/:id", handlers.PatchSCIMUser)
+	scim.DELETE("/Users/:id", handlers.DeleteSCIMUser)
+	scim.GET("/Groups", handlers.ListSCIMGroups)
+	scim.POST("/Groups", handlers.CreateSCIMGroup)
+	scim.GET("/Groups/:id", handlers.GetSCIMGroup)
+	scim.PUT("/Groups/:id", handlers.ReplaceSCIMGroup)
+	scim.PATCH("/Groups/:id", handlers.PatchSCIMGroup)
+	scim.DELETE("/Groups/:id", handlers.DeleteSCIMGroup)
+
+	if err := serve(router, cfg.Server); err != nil {
+		fatal("Server failed", err)
 	}
-	log.Printf("Listening on port: %s", port)
+	if sqlDB, err := db.DB.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// rateLimit returns the limit of a route group, exiting on a malformed override
+func rateLimit(cfg config.RateLimitConfig, name string, def services.RateLimit) services.RateLimit {
+	limit, err := services.RateLimitOverride(name, cfg.Overrides[name], def)
+	if err != nil {
+		fatal("Invalid rate limit", err)
+	}
+	return limit
+}
 
-	if err := router.Run(":8085"); err != nil {
-		log.Fatal("Failed to start server:", err)
+// newMailer returns the mailer selected by MAILER
+func newMailer(cfg config.MailConfig) services.Mailer {
+	if cfg.Mailer == "smtp" {
+		return &services.SMTPMailer{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.From,
+		}
 	}
+	return &services.LogMailer{Path: cfg.LogFile}
 }
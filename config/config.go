@@ -0,0 +1,447 @@
+// Package config loads the settings of the service from the environment, a
+// .env file and an optional CONFIG_FILE, and validates all of them at startup
+// so that a bad deployment fails before serving rather than on first use
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Config is the validated configuration of the service
+type Config struct {
+	// GinMode is debug, release or test
+	GinMode        string
+	Server         ServerConfig
+	Database       DatabaseConfig
+	Stream         StreamConfig
+	Log            LogConfig
+	Mail           MailConfig
+	RateLimit      RateLimitConfig
+	OIDC           OIDCConfig
+	DNS            DNSConfig
+	Metrics        MetricsConfig
+	TrustedProxies []string
+	// AppBaseURL is the frontend that links in mail point to
+	AppBaseURL string
+	// TracesExporter is otlp, stdout or none
+	TracesExporter string
+	// AuditCheckpointInterval is 0 when periodic checkpoints are off
+	AuditCheckpointInterval time.Duration
+}
+
+// ServerConfig is how the HTTP server listens
+type ServerConfig struct {
+	Port              string
+	TLSCertFile       string
+	TLSKeyFile        string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long in-flight requests may finish on SIGTERM
+	ShutdownTimeout time.Duration
+}
+
+// TLS reports whether the server terminates TLS itself
+func (s ServerConfig) TLS() bool {
+	return s.TLSCertFile != ""
+}
+
+// DatabaseConfig is the Postgres connection
+type DatabaseConfig struct {
+	URL string
+	// MigrateOnStart applies pending migrations before serving
+	MigrateOnStart bool
+}
+
+// StreamConfig is the Stream Chat app the service talks to
+type StreamConfig struct {
+	APIKey    string
+	APISecret string
+}
+
+// LogConfig is the level (debug, info, warn or error) and format (json or
+// text) of the logs
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+// MailConfig is how mail is sent
+type MailConfig struct {
+	// Mailer is log or smtp
+	Mailer string
+	From   string
+	// LogFile is where the log mailer writes whole messages; without it only
+	// their recipient and subject are logged
+	LogFile string
+	SMTP    SMTPConfig
+}
+
+// SMTPConfig is the relay the smtp mailer sends through
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// RateLimitConfig is where rate limit buckets are kept and which route groups
+// have their limits overridden
+type RateLimitConfig struct {
+	// Backend is memory or postgres
+	Backend string
+	// Overrides maps a route group, e.g. auth, to its RATE_LIMIT_<GROUP> value
+	// such as "10/1m,5" or "off"; other groups keep their default limit
+	Overrides map[string]string
+}
+
+// OIDCConfig is how the service talks to the tenants' identity providers
+type OIDCConfig struct {
+	// RedirectURL is the callback URL registered with every identity provider
+	RedirectURL string
+	// AllowPrivateNetworks lets issuers resolve to private addresses
+	AllowPrivateNetworks bool
+}
+
+// DNSConfig is how tenant domains are verified
+type DNSConfig struct {
+	// Resolver is empty for the system resolver or static
+	Resolver string
+	// StaticTXTRecords are the "name=value;name=value" answers of the static
+	// resolver
+	StaticTXTRecords string
+}
+
+// MetricsConfig guards /metrics
+type MetricsConfig struct {
+	// Token is the bearer token scrapers must send; /metrics is open without it
+	Token string
+}
+
+// rateLimitGroups are the route groups whose limit RATE_LIMIT_<GROUP> overrides
+var rateLimitGroups = []string{"global", "auth", "messages_user", "messages_tenant", "scim", "scim_ip"}
+
+// Defaults of the optional settings
+const (
+	DefaultPort                    = "8085"
+	DefaultReadHeaderTimeout       = 5 * time.Second
+	DefaultReadTimeout             = 30 * time.Second
+	DefaultWriteTimeout            = 60 * time.Second
+	DefaultIdleTimeout             = 120 * time.Second
+	DefaultShutdownTimeout         = 30 * time.Second
+	DefaultAuditCheckpointInterval = time.Hour
+	DefaultSMTPPort                = "587"
+	DefaultAppBaseURL              = "http://localhost:3000"
+	DefaultOIDCRedirectURL         = "http://localhost:8085/auth/oidc/callback"
+)
+
+// Error reports every invalid setting at once
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load reads .env (when present) and CONFIG_FILE (when set), both in .env
+// format, into the environment without overriding variables already set, then
+// validates the result
+func Load() (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		if err := godotenv.Load(file); err != nil {
+			return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+		}
+	}
+	return FromEnv()
+}
+
+// FromEnv builds the configuration from the environment, returning an *Error
+// listing every problem found
+func FromEnv() (*Config, error) {
+	var e env
+	cfg := &Config{
+		GinMode: e.oneOf("GIN_MODE", "debug", "debug", "release", "test"),
+		Server: ServerConfig{
+			Port:              e.port("PORT", DefaultPort),
+			TLSCertFile:       os.Getenv("TLS_CERT_FILE"),
+			TLSKeyFile:        os.Getenv("TLS_KEY_FILE"),
+			ReadHeaderTimeout: e.duration("HTTP_READ_HEADER_TIMEOUT", DefaultReadHeaderTimeout),
+			ReadTimeout:       e.duration("HTTP_READ_TIMEOUT", DefaultReadTimeout),
+			WriteTimeout:      e.duration("HTTP_WRITE_TIMEOUT", DefaultWriteTimeout),
+			IdleTimeout:       e.duration("HTTP_IDLE_TIMEOUT", DefaultIdleTimeout),
+			ShutdownTimeout:   e.duration("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout),
+		},
+		Database: DatabaseConfig{
+			URL:            e.required("DATABASE_URL"),
+			MigrateOnStart: e.boolean("MIGRATE_DB"),
+		},
+		Stream: StreamConfig{
+			APIKey:    e.required("STREAM_API_KEY"),
+			APISecret: e.required("STREAM_API_SECRET"),
+		},
+		Mail: MailConfig{
+			Mailer:  e.oneOf("MAILER", "log", "log", "smtp"),
+			From:    os.Getenv("MAIL_FROM"),
+			LogFile: os.Getenv("MAIL_LOG_FILE"),
+			SMTP: SMTPConfig{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     e.port("SMTP_PORT", DefaultSMTPPort),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Backend:   e.oneOf("RATE_LIMIT_BACKEND", "memory", "memory", "postgres"),
+			Overrides: e.rateLimitOverrides(),
+		},
+		OIDC: OIDCConfig{
+			RedirectURL:          e.absoluteURL("OIDC_REDIRECT_URL", DefaultOIDCRedirectURL),
+			AllowPrivateNetworks: e.boolean("OIDC_ALLOW_PRIVATE_NETWORKS"),
+		},
+		DNS: DNSConfig{
+			Resolver:         e.oneOf("DNS_RESOLVER", "", "", "static"),
+			StaticTXTRecords: os.Getenv("DNS_STATIC_TXT_RECORDS"),
+		},
+		Metrics:        MetricsConfig{Token: os.Getenv("METRICS_TOKEN")},
+		AppBaseURL:     e.absoluteURL("APP_BASE_URL", DefaultAppBaseURL),
+		TracesExporter: e.oneOf("OTEL_TRACES_EXPORTER", "none", "none", "otlp", "stdout", "console"),
+	}
+
+	defaultFormat := "text"
+	if cfg.GinMode == "release" {
+		defaultFormat = "json"
+	}
+	cfg.Log = LogConfig{
+		Level:  e.oneOf("LOG_LEVEL", "info", "debug", "info", "warn", "error"),
+		Format: e.oneOf("LOG_FORMAT", defaultFormat, "json", "text"),
+	}
+
+	e.checkTLS(cfg.Server)
+	e.checkMailer(cfg.Mail, cfg.GinMode == "release")
+	e.checkMetrics(cfg.Metrics, cfg.GinMode == "release")
+	cfg.TrustedProxies = e.proxies("TRUSTED_PROXIES")
+	if os.Getenv("AUDIT_CHECKPOINT_INTERVAL") == "off" {
+		cfg.AuditCheckpointInterval = 0
+	} else {
+		cfg.AuditCheckpointInterval = e.duration("AUDIT_CHECKPOINT_INTERVAL", DefaultAuditCheckpointInterval)
+	}
+
+	// settings read by the packages using them, checked here so that they fail at startup
+	e.checkJWT()
+	e.checkAuditSigningKey(cfg.GinMode == "release")
+
+	if len(e.problems) > 0 {
+		return nil, &Error{Problems: e.problems}
+	}
+	return cfg, nil
+}
+
+// LogValue logs the settings that are safe to log
+func (c *Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("gin_mode", c.GinMode),
+		slog.String("port", c.Server.Port),
+		slog.Bool("tls", c.Server.TLS()),
+		slog.Bool("migrate_on_start", c.Database.MigrateOnStart),
+		slog.String("traces_exporter", c.TracesExporter),
+		slog.String("log_level", c.Log.Level),
+		slog.String("mailer", c.Mail.Mailer),
+		slog.String("rate_limit_backend", c.RateLimit.Backend),
+	)
+}
+
+// env reads variables, collecting the problems with them
+type env struct {
+	problems []string
+}
+
+func (e *env) problemf(format string, args ...interface{}) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+func (e *env) required(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		e.problemf("%s is required", name)
+	}
+	return value
+}
+
+func (e *env) oneOf(name, def string, allowed ...string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if value == a {
+			return a
+		}
+	}
+	e.problemf("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+	return def
+}
+
+func (e *env) boolean(name string) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.problemf("%s must be true or false, got %q", name, value)
+	}
+	return b
+}
+
+func (e *env) duration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		e.problemf("%s must be a positive duration such as 30s, got %q", name, value)
+		return def
+	}
+	return d
+}
+
+func (e *env) port(name, def string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+		e.problemf("%s must be a port number, got %q", name, value)
+		return def
+	}
+	return value
+}
+
+func (e *env) proxies(name string) []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv(name), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				e.problemf("%s: %q is not an IP or CIDR", name, p)
+				continue
+			}
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies
+}
+
+func (e *env) absoluteURL(name, def string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		e.problemf("%s must be an absolute URL, got %q", name, value)
+		return def
+	}
+	return value
+}
+
+// rateLimitOverrides reads RATE_LIMIT_<GROUP> of every route group. The
+// values are parsed by the rate limiter, which owns their format.
+func (e *env) rateLimitOverrides() map[string]string {
+	overrides := map[string]string{}
+	for _, group := range rateLimitGroups {
+		if value := os.Getenv("RATE_LIMIT_" + strings.ToUpper(group)); value != "" {
+			overrides[group] = value
+		}
+	}
+	return overrides
+}
+
+func (e *env) checkTLS(s ServerConfig) {
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		e.problemf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		return
+	}
+	for name, file := range map[string]string{"TLS_CERT_FILE": s.TLSCertFile, "TLS_KEY_FILE": s.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			e.problemf("%s: %v", name, err)
+		}
+	}
+}
+
+func (e *env) checkJWT() {
+	switch alg := e.oneOf("JWT_SIGNING_ALG", "HS256", "HS256", "RS256", "EdDSA"); alg {
+	case "HS256":
+		e.required("JWT_SECRET")
+	default:
+		if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				e.problemf("JWT_KEYS_DIR %q is not a directory", dir)
+			}
+		}
+	}
+	e.duration("JWT_KEY_ROTATION_INTERVAL", time.Hour)
+	e.duration("JWT_KEY_PUBLISH_LEAD", time.Hour)
+}
+
+// checkAuditSigningKey requires the key in release mode, where a key generated
+// per run would leave earlier checkpoints unverifiable
+func (e *env) checkAuditSigningKey(release bool) {
+	key := os.Getenv("AUDIT_SIGNING_KEY")
+	if key == "" {
+		if release {
+			e.problemf("AUDIT_SIGNING_KEY must be set when GIN_MODE is release")
+		}
+		return
+	}
+	if seed, err := base64.StdEncoding.DecodeString(key); err != nil || len(seed) != 32 {
+		e.problemf("AUDIT_SIGNING_KEY must be a base64 Ed25519 seed of 32 bytes")
+	}
+}
+
+// checkMetrics requires the token in release mode, where an open /metrics
+// would publish every tenant's ID and activity
+func (e *env) checkMetrics(m MetricsConfig, release bool) {
+	if release && m.Token == "" {
+		e.problemf("METRICS_TOKEN must be set when GIN_MODE is release")
+	}
+}
+
+// checkMailer requires SMTP in release mode, where mail has to reach people
+// and the log mailer would drop it
+func (e *env) checkMailer(m MailConfig, release bool) {
+	if m.Mailer != "smtp" {
+		if release {
+			e.problemf("MAILER must be smtp when GIN_MODE is release")
+		}
+		return
+	}
+	if m.SMTP.Host == "" {
+		e.problemf("SMTP_HOST is required")
+	}
+	if m.From == "" {
+		e.problemf("MAIL_FROM is required")
+	}
+}
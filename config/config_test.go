@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setRequired(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/chat")
+	t.Setenv("STREAM_API_KEY", "key")
+	t.Setenv("STREAM_API_SECRET", "secret")
+	t.Setenv("JWT_SECRET", "jwt-secret")
+}
+
+func TestFromEnvDefaults(t *testing.T) {
+	setRequired(t)
+	t.Setenv("GIN_MODE", "release")
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_FROM", "no-reply@example.com")
+	t.Setenv("AUDIT_SIGNING_KEY", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	t.Setenv("METRICS_TOKEN", "scrape")
+
+	cfg, err := FromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPort, cfg.Server.Port)
+	assert.Equal(t, DefaultShutdownTimeout, cfg.Server.ShutdownTimeout)
+	assert.False(t, cfg.Server.TLS())
+	assert.Equal(t, "json", cfg.Log.Format)
+	assert.Equal(t, "none", cfg.TracesExporter)
+	assert.Equal(t, time.Hour, cfg.AuditCheckpointInterval)
+	assert.Equal(t, DefaultSMTPPort, cfg.Mail.SMTP.Port)
+	assert.Equal(t, "memory", cfg.RateLimit.Backend)
+	assert.Empty(t, cfg.RateLimit.Overrides)
+	assert.Equal(t, DefaultOIDCRedirectURL, cfg.OIDC.RedirectURL)
+	assert.Equal(t, DefaultAppBaseURL, cfg.AppBaseURL)
+	assert.Equal(t, "scrape", cfg.Metrics.Token)
+}
+
+func TestFromEnvReadsSettings(t *testing.T) {
+	setRequired(t)
+	t.Setenv("PORT", "9000")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "2m")
+	t.Setenv("MIGRATE_DB", "true")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1, 10.1.0.0/16")
+	t.Setenv("AUDIT_CHECKPOINT_INTERVAL", "off")
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_PORT", "2525")
+	t.Setenv("MAIL_FROM", "no-reply@example.com")
+	t.Setenv("RATE_LIMIT_BACKEND", "postgres")
+	t.Setenv("RATE_LIMIT_AUTH", "5/1m")
+	t.Setenv("RATE_LIMIT_SCIM_IP", "off")
+	t.Setenv("OIDC_REDIRECT_URL", "https://chat.example.com/auth/oidc/callback")
+	t.Setenv("OIDC_ALLOW_PRIVATE_NETWORKS", "true")
+	t.Setenv("DNS_RESOLVER", "static")
+	t.Setenv("DNS_STATIC_TXT_RECORDS", "_chat.example.com=token")
+	t.Setenv("APP_BASE_URL", "https://chat.example.com")
+
+	cfg, err := FromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "9000", cfg.Server.Port)
+	assert.Equal(t, 2*time.Minute, cfg.Server.WriteTimeout)
+	assert.True(t, cfg.Database.MigrateOnStart)
+	assert.Equal(t, []string{"10.0.0.1", "10.1.0.0/16"}, cfg.TrustedProxies)
+	assert.Zero(t, cfg.AuditCheckpointInterval)
+	assert.Equal(t, MailConfig{
+		Mailer: "smtp",
+		From:   "no-reply@example.com",
+		SMTP:   SMTPConfig{Host: "smtp.example.com", Port: "2525"},
+	}, cfg.Mail)
+	assert.Equal(t, RateLimitConfig{
+		Backend:   "postgres",
+		Overrides: map[string]string{"auth": "5/1m", "scim_ip": "off"},
+	}, cfg.RateLimit)
+	assert.Equal(t, OIDCConfig{RedirectURL: "https://chat.example.com/auth/oidc/callback", AllowPrivateNetworks: true}, cfg.OIDC)
+	assert.Equal(t, DNSConfig{Resolver: "static", StaticTXTRecords: "_chat.example.com=token"}, cfg.DNS)
+	assert.Equal(t, "https://chat.example.com", cfg.AppBaseURL)
+}
+
+func TestFromEnvReportsEveryProblem(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("STREAM_API_KEY", "key")
+	t.Setenv("STREAM_API_SECRET", "")
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("PORT", "http")
+	t.Setenv("TLS_CERT_FILE", "cert.pem")
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_FROM", "no-reply@example.com")
+
+	_, err := FromEnv()
+	var cfgErr *Error
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.ElementsMatch(t, []string{
+		"DATABASE_URL is required",
+		"STREAM_API_SECRET is required",
+		"JWT_SECRET is required",
+		`PORT must be a port number, got "http"`,
+		"TLS_CERT_FILE and TLS_KEY_FILE must be set together",
+		"SMTP_HOST is required",
+	}, cfgErr.Problems)
+}
+
+func TestFromEnvRequiresSMTPInRelease(t *testing.T) {
+	setRequired(t)
+	t.Setenv("GIN_MODE", "release")
+	t.Setenv("MAILER", "log")
+	t.Setenv("AUDIT_SIGNING_KEY", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	t.Setenv("METRICS_TOKEN", "scrape")
+
+	_, err := FromEnv()
+	var cfgErr *Error
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, []string{"MAILER must be smtp when GIN_MODE is release"}, cfgErr.Problems)
+
+	t.Setenv("GIN_MODE", "debug")
+	_, err = FromEnv()
+	assert.NoError(t, err)
+}
+
+func TestFromEnvRequiresAuditSigningKeyInRelease(t *testing.T) {
+	setRequired(t)
+	t.Setenv("GIN_MODE", "release")
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_FROM", "no-reply@example.com")
+	t.Setenv("AUDIT_SIGNING_KEY", "")
+	t.Setenv("METRICS_TOKEN", "scrape")
+
+	_, err := FromEnv()
+	var cfgErr *Error
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, []string{"AUDIT_SIGNING_KEY must be set when GIN_MODE is release"}, cfgErr.Problems)
+
+	t.Setenv("AUDIT_SIGNING_KEY", "short")
+	_, err = FromEnv()
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, []string{"AUDIT_SIGNING_KEY must be a base64 Ed25519 seed of 32 bytes"}, cfgErr.Problems)
+}
+
+func TestFromEnvRequiresMetricsTokenInRelease(t *testing.T) {
+	setRequired(t)
+	t.Setenv("GIN_MODE", "release")
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_FROM", "no-reply@example.com")
+	t.Setenv("AUDIT_SIGNING_KEY", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	t.Setenv("METRICS_TOKEN", "")
+
+	_, err := FromEnv()
+	var cfgErr *Error
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, []string{"METRICS_TOKEN must be set when GIN_MODE is release"}, cfgErr.Problems)
+
+	t.Setenv("GIN_MODE", "debug")
+	_, err = FromEnv()
+	assert.NoError(t, err)
+}
@@ -1,11 +1,11 @@
 package db
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
-	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/metrics"
+	"github.com/Nyagar-Abraham/chat-app/tracing"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -17,26 +17,23 @@ func IsRecordNotFoundError(err error) bool {
 	return err == gorm.ErrRecordNotFound
 }
 
-func Connect() {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		log.Fatal("DATABASE_URL environment variable not set")
-	}
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Connect opens the database at dsn. The schema is managed by the migrations
+// in db/migrations, see MigrateUp.
+func Connect(dsn string) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		slog.Error("failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 
-	DB = db
-	// Conditionally run AutoMigrate if MIGRATE_DB=true in env (for development only)
-	if os.Getenv("MIGRATE_DB") == "true" {
-		fmt.Println("[DEV] Running GORM AutoMigration...")
-		err = db.AutoMigrate(&models.Tenant{}, &models.Channel{}, &models.User{}, &models.ChannelMember{})
-		if err != nil {
-			log.Fatalf("Failed to run migration: %v", err)
-		}
-		fmt.Println("Database connected and migrated (AutoMigrate enabled)")
-	} else {
-		fmt.Println("Database connected (no migration performed)")
+	if err := metrics.InstrumentGORM(db); err != nil {
+		slog.Error("failed to instrument database queries", "error", err)
+		os.Exit(1)
 	}
+	if err := tracing.InstrumentGORM(db); err != nil {
+		slog.Error("failed to trace database queries", "error", err)
+		os.Exit(1)
+	}
+	DB = db
+	slog.Info("database connected")
 }
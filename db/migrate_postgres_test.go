@@ -0,0 +1,51 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// firstReleaseSchema is what AutoMigrate created for the first release
+const firstReleaseSchema = `
+CREATE TABLE tenants (id uuid PRIMARY KEY, name text NOT NULL);
+CREATE UNIQUE INDEX idx_tenants_name ON tenants (name);
+CREATE TABLE users (id uuid PRIMARY KEY, email text NOT NULL, name text, password text NOT NULL, role text DEFAULT 'MEMBER', tenant_id text);
+CREATE UNIQUE INDEX idx_users_email ON users (email);
+CREATE TABLE channels (id uuid PRIMARY KEY, stream_id text NOT NULL, name text, description text, tenant_id text, created_by text);
+CREATE UNIQUE INDEX idx_channels_stream_id ON channels (stream_id);
+CREATE TABLE channel_members (id uuid PRIMARY KEY, channel_id text NOT NULL, user_id text NOT NULL, tenant_id text NOT NULL, joined_at bigint);
+CREATE INDEX idx_channel_user ON channel_members (channel_id, user_id);
+CREATE INDEX idx_channel_members_tenant_id ON channel_members (tenant_id);
+INSERT INTO tenants VALUES ('11111111-1111-1111-1111-111111111111', 'Acme');
+INSERT INTO users VALUES ('22222222-2222-2222-2222-222222222222', 'alice@acme.test', 'Alice', 'x', 'ADMIN', '11111111-1111-1111-1111-111111111111');
+INSERT INTO channels VALUES ('33333333-3333-3333-3333-333333333333', 'general', 'General', '', '11111111-1111-1111-1111-111111111111', '22222222-2222-2222-2222-222222222222');
+INSERT INTO channel_members VALUES ('44444444-4444-4444-4444-444444444444', '33333333-3333-3333-3333-333333333333', '22222222-2222-2222-2222-222222222222', '11111111-1111-1111-1111-111111111111', 0);
+`
+
+func TestMigrateUpAdoptsFirstReleaseSchema(t *testing.T) {
+	testutil.SetupEmptyPostgres(t)
+	assert.NoError(t, db.DB.Exec(firstReleaseSchema).Error)
+
+	_, err := db.MigrateUp(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var tenant models.Tenant
+	assert.NoError(t, db.DB.First(&tenant).Error)
+	assert.Equal(t, "free", tenant.PlanID)
+	var user models.User
+	assert.NoError(t, db.DB.First(&user).Error)
+	assert.Equal(t, 0, user.TokenVersion)
+	assert.False(t, user.Disabled)
+	var channel models.Channel
+	assert.NoError(t, db.DB.First(&channel).Error)
+	var member models.ChannelMember
+	assert.NoError(t, db.DB.First(&member).Error)
+	assert.Equal(t, channel.ID, member.ChannelID)
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migrations, err := Migrations()
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, migrations) {
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "initial", migrations[0].Name)
+	}
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version, "versions are consecutive")
+	}
+}
+
+func TestLoadMigrationsRejectsIncompletePairs(t *testing.T) {
+	_, err := LoadMigrations(fstest.MapFS{
+		"0001_a.up.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.Error(t, err)
+
+	_, err = LoadMigrations(fstest.MapFS{
+		"1_Bad Name.up.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.Error(t, err)
+
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"0002_b.up.sql":   {Data: []byte("SELECT 2;")},
+		"0002_b.down.sql": {Data: []byte("SELECT -2;")},
+		"0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+		"0001_a.down.sql": {Data: []byte("SELECT -1;")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "a", Up: "SELECT 1;", Down: "SELECT -1;"},
+		{Version: 2, Name: "b", Up: "SELECT 2;", Down: "SELECT -2;"},
+	}, migrations)
+}
+
+func TestCreateMigrationUsesNextVersion(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "0007_a.up.sql"), []byte("SELECT 1;"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "0007_a.down.sql"), []byte("SELECT 1;"), 0o644))
+
+	up, down, err := CreateMigration(dir, "Add Channel Topic")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0008_add_channel_topic.up.sql"), up)
+	assert.Equal(t, filepath.Join(dir, "0008_add_channel_topic.down.sql"), down)
+	assert.FileExists(t, up)
+	assert.FileExists(t, down)
+}
@@ -0,0 +1,123 @@
+-- The core tables. Databases created by GORM AutoMigrate already have them,
+-- with text references, no constraints and only the columns of the first
+-- release; they are brought up to date below, so this migration both creates
+-- a new database and adopts an existing one.
+
+CREATE TABLE IF NOT EXISTS tenants (
+    id uuid,
+    name text NOT NULL,
+    require_verified_email_for_login boolean NOT NULL DEFAULT false,
+    require_verified_email_for_channels boolean NOT NULL DEFAULT false,
+    require_2fa_for_privileged boolean NOT NULL DEFAULT false,
+    plan_id text NOT NULL DEFAULT 'free',
+    owner_id text,
+    suspended boolean NOT NULL DEFAULT false,
+    suspended_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tenants_name ON tenants (name);
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid,
+    email text NOT NULL,
+    name text,
+    password text NOT NULL,
+    role text DEFAULT 'MEMBER',
+    tenant_id uuid NOT NULL,
+    external_id text,
+    disabled boolean NOT NULL DEFAULT false,
+    email_verified boolean NOT NULL DEFAULT false,
+    totp_secret text,
+    totp_enabled boolean NOT NULL DEFAULT false,
+    totp_last_step bigint NOT NULL DEFAULT 0,
+    token_version bigint NOT NULL DEFAULT 0,
+    platform_admin boolean NOT NULL DEFAULT false,
+    domain_joined boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS channels (
+    id uuid,
+    stream_id text NOT NULL,
+    name text,
+    description text,
+    tenant_id uuid NOT NULL,
+    created_by text,
+    external_id text,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_channels_stream_id ON channels (stream_id);
+
+CREATE TABLE IF NOT EXISTS channel_members (
+    id uuid,
+    channel_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    tenant_id uuid NOT NULL,
+    joined_at bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_channel_members_tenant_id ON channel_members (tenant_id);
+
+-- Columns added since the first release
+ALTER TABLE tenants
+    ADD COLUMN IF NOT EXISTS require_verified_email_for_login boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS require_verified_email_for_channels boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS require_2fa_for_privileged boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS plan_id text NOT NULL DEFAULT 'free',
+    ADD COLUMN IF NOT EXISTS owner_id text,
+    ADD COLUMN IF NOT EXISTS suspended boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS suspended_at timestamptz,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz;
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS external_id text,
+    ADD COLUMN IF NOT EXISTS disabled boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS email_verified boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_secret text,
+    ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS token_version bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS platform_admin boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS domain_joined boolean NOT NULL DEFAULT false;
+ALTER TABLE channels
+    ADD COLUMN IF NOT EXISTS external_id text;
+CREATE INDEX IF NOT EXISTS idx_users_external_id ON users (external_id);
+CREATE INDEX IF NOT EXISTS idx_channels_external_id ON channels (external_id);
+
+-- AutoMigrate stored the references as text
+ALTER TABLE users
+    ALTER COLUMN tenant_id TYPE uuid USING tenant_id::uuid,
+    ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE channels
+    ALTER COLUMN tenant_id TYPE uuid USING tenant_id::uuid,
+    ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE channel_members
+    ALTER COLUMN channel_id TYPE uuid USING channel_id::uuid,
+    ALTER COLUMN user_id TYPE uuid USING user_id::uuid,
+    ALTER COLUMN tenant_id TYPE uuid USING tenant_id::uuid;
+
+-- Without constraints, memberships could outlive their channel or user, or be
+-- recorded twice; keep one of each
+DELETE FROM channel_members m
+WHERE NOT EXISTS (SELECT 1 FROM channels c WHERE c.id = m.channel_id AND c.tenant_id = m.tenant_id)
+   OR NOT EXISTS (SELECT 1 FROM users u WHERE u.id = m.user_id AND u.tenant_id = m.tenant_id);
+DELETE FROM channel_members m
+USING channel_members kept
+WHERE m.channel_id = kept.channel_id AND m.user_id = kept.user_id AND m.id > kept.id;
+DROP INDEX IF EXISTS idx_channel_user;
+
+-- Users and channels belong to a tenant, and a membership joins a channel and
+-- a user of the membership's tenant
+ALTER TABLE users
+    ADD CONSTRAINT fk_users_tenant FOREIGN KEY (tenant_id) REFERENCES tenants (id),
+    ADD CONSTRAINT uq_users_id_tenant UNIQUE (id, tenant_id);
+ALTER TABLE channels
+    ADD CONSTRAINT fk_channels_tenant FOREIGN KEY (tenant_id) REFERENCES tenants (id),
+    ADD CONSTRAINT uq_channels_id_tenant UNIQUE (id, tenant_id);
+ALTER TABLE channel_members
+    ADD CONSTRAINT idx_channel_user UNIQUE (channel_id, user_id),
+    ADD CONSTRAINT fk_channel_members_channel FOREIGN KEY (channel_id, tenant_id)
+        REFERENCES channels (id, tenant_id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_channel_members_user FOREIGN KEY (user_id, tenant_id)
+        REFERENCES users (id, tenant_id) ON DELETE CASCADE;
@@ -0,0 +1,22 @@
+DROP TABLE IF EXISTS
+    audit_checkpoints,
+    audit_chain_heads,
+    audit_logs,
+    login_throttles,
+    rate_limit_buckets,
+    tenant_daily_usages,
+    plans,
+    tenant_settings,
+    tenant_domains,
+    invitations,
+    custom_roles,
+    role_permissions,
+    scim_tokens,
+    user_identities,
+    o_id_c_auth_states,
+    tenant_o_id_c_configs,
+    recovery_codes,
+    email_verification_tokens,
+    password_reset_tokens,
+    revoked_tokens,
+    refresh_tokens;
@@ -0,0 +1,273 @@
+-- The tables of the features built on top of the core tables. Databases
+-- created by GORM AutoMigrate may already have them; those of the first
+-- release do not.
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id uuid,
+    user_id text NOT NULL,
+    tenant_id text NOT NULL,
+    family_id text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    replaced_by text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_tenant_id ON refresh_tokens (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti text,
+    user_id text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (jti)
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id uuid,
+    user_id text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_password_reset_tokens_token_hash ON password_reset_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id uuid,
+    user_id text NOT NULL,
+    email text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_verification_tokens_token_hash ON email_verification_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_id ON email_verification_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id uuid,
+    user_id text NOT NULL,
+    code_hash text NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS tenant_o_id_c_configs (
+    id uuid,
+    tenant_id text NOT NULL,
+    issuer text NOT NULL,
+    client_id text NOT NULL,
+    client_secret text,
+    allowed_domains text,
+    role_claim text,
+    role_mapping text,
+    default_role text DEFAULT 'MEMBER',
+    enabled boolean NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tenant_o_id_c_configs_tenant_id ON tenant_o_id_c_configs (tenant_id);
+
+CREATE TABLE IF NOT EXISTS o_id_c_auth_states (
+    state text,
+    tenant_id text NOT NULL,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (state)
+);
+CREATE INDEX IF NOT EXISTS idx_o_id_c_auth_states_expires_at ON o_id_c_auth_states (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id uuid,
+    user_id text NOT NULL,
+    tenant_id text NOT NULL,
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_identity_issuer_subject ON user_identities (issuer,subject);
+CREATE INDEX IF NOT EXISTS idx_user_identities_tenant_id ON user_identities (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS scim_tokens (
+    id uuid,
+    tenant_id text NOT NULL,
+    name text,
+    token_hash text NOT NULL,
+    created_by text,
+    last_used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_tokens_token_hash ON scim_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_scim_tokens_tenant_id ON scim_tokens (tenant_id);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    id uuid,
+    tenant_id text NOT NULL DEFAULT '',
+    role text NOT NULL,
+    permission text NOT NULL,
+    allowed boolean NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_permission ON role_permissions (tenant_id,role,permission);
+
+CREATE TABLE IF NOT EXISTS custom_roles (
+    id uuid,
+    tenant_id text NOT NULL,
+    key text NOT NULL,
+    name text NOT NULL,
+    description text,
+    stream_role text NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tenant_role_key ON custom_roles (tenant_id,key);
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id uuid,
+    tenant_id text NOT NULL,
+    email text NOT NULL,
+    role text NOT NULL,
+    token_hash text NOT NULL,
+    invited_by text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    accepted_at timestamptz,
+    revoked_at timestamptz,
+    last_sent_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invitations_token_hash ON invitations (token_hash);
+CREATE INDEX IF NOT EXISTS idx_invitations_email ON invitations (email);
+CREATE INDEX IF NOT EXISTS idx_invitations_tenant_id ON invitations (tenant_id);
+
+CREATE TABLE IF NOT EXISTS tenant_domains (
+    id uuid,
+    tenant_id uuid NOT NULL,
+    domain text NOT NULL,
+    verification_token text NOT NULL,
+    verified_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_tenants_domains FOREIGN KEY (tenant_id) REFERENCES tenants(id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_verified_domain ON tenant_domains (domain) WHERE verified_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tenant_domain ON tenant_domains (tenant_id,domain);
+
+CREATE TABLE IF NOT EXISTS tenant_settings (
+    tenant_id uuid,
+    version bigint NOT NULL,
+    default_role text NOT NULL,
+    registration_policy text NOT NULL,
+    message_max_length bigint NOT NULL,
+    retention_days bigint NOT NULL,
+    allowed_attachment_types text,
+    guest_access boolean NOT NULL,
+    updated_by text,
+    updated_at timestamptz,
+    PRIMARY KEY (tenant_id)
+);
+
+CREATE TABLE IF NOT EXISTS plans (
+    id text,
+    name text NOT NULL,
+    max_users bigint NOT NULL,
+    max_channels bigint NOT NULL,
+    max_members_per_channel bigint NOT NULL,
+    max_messages_per_day bigint NOT NULL,
+    max_storage_bytes bigint NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS tenant_daily_usages (
+    tenant_id uuid,
+    day date,
+    messages bigint NOT NULL DEFAULT 0,
+    storage_bytes bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id,day)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key text,
+    tokens decimal NOT NULL,
+    updated_at timestamptz NOT NULL,
+    PRIMARY KEY (key)
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
+
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key text,
+    failures bigint NOT NULL,
+    lockouts bigint NOT NULL,
+    last_failed_at timestamptz NOT NULL,
+    blocked_until timestamptz,
+    PRIMARY KEY (key)
+);
+CREATE INDEX IF NOT EXISTS idx_login_throttles_last_failed_at ON login_throttles (last_failed_at);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id uuid,
+    tenant_id text NOT NULL,
+    seq bigint NOT NULL,
+    prev_hash text,
+    hash text NOT NULL,
+    actor_id text,
+    actor_type text NOT NULL,
+    action text NOT NULL,
+    target_type text,
+    target_id text,
+    changes text,
+    ip text,
+    request_id text,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_request_id ON audit_logs (request_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_target_id ON audit_logs (target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs (action);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_id ON audit_logs (actor_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_tenant_seq ON audit_logs (tenant_id,seq);
+CREATE INDEX IF NOT EXISTS idx_audit_tenant_time ON audit_logs (tenant_id,created_at);
+
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+    tenant_id text,
+    seq bigint NOT NULL,
+    hash text,
+    updated_at timestamptz,
+    PRIMARY KEY (tenant_id)
+);
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id uuid,
+    tenant_id text NOT NULL,
+    seq bigint NOT NULL,
+    hash text NOT NULL,
+    key_id text NOT NULL,
+    public_key text NOT NULL,
+    signature text NOT NULL,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_checkpoint_seq ON audit_checkpoints (tenant_id,seq);
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminTenantResponse is a tenant as seen by platform admins
+type AdminTenantResponse struct {
+	models.Tenant
+	UserCount int64 `json:"user_count"`
+}
+
+// --- PLATFORM ADMIN HANDLERS ---
+// These operate across tenants and require middleware.RequirePlatformAdmin.
+
+// AdminListTenants lists all tenants (Platform admin only)
+// @Summary List all tenants
+// @Description Lists every tenant (organization) with its user count. Filter with ?suspended=true|false.
+// @Tags admin
+// @Produce json
+// @Param suspended query bool false "Only suspended or only active tenants"
+// @Success 200 {array} AdminTenantResponse
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/tenants [get]
+func AdminListTenants(c *gin.Context) {
+	summaries, err := repos.Tenants.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tenants"})
+		return
+	}
+
+	filter := c.Query("suspended")
+	tenants := []AdminTenantResponse{}
+	for _, summary := range summaries {
+		if (filter == "true" || filter == "false") && strconv.FormatBool(summary.Suspended) != filter {
+			continue
+		}
+		tenants = append(tenants, AdminTenantResponse{Tenant: summary.Tenant, UserCount: summary.UserCount})
+	}
+	c.JSON(http.StatusOK, tenants)
+}
+
+// SuspendTenant suspends a tenant (Platform admin only)
+// @Summary Suspend tenant
+// @Description Suspends a tenant: its users are signed out and cannot sign in until it is reactivated
+// @Tags admin
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} models.Tenant
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/tenants/{id}/suspend [post]
+func SuspendTenant(c *gin.Context) {
+	setTenantSuspended(c, true)
+}
+
+// ReactivateTenant lifts a tenant's suspension (Platform admin only)
+// @Summary Reactivate tenant
+// @Description Lifts a tenant's suspension
+// @Tags admin
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} models.Tenant
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/tenants/{id}/reactivate [post]
+func ReactivateTenant(c *gin.Context) {
+	setTenantSuspended(c, false)
+}
+
+func setTenantSuspended(c *gin.Context, suspended bool) {
+	tenant, err := services.SetTenantSuspended(c.Param("id"), suspended)
+	if err != nil {
+		if db.IsRecordNotFoundError(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update tenant"})
+		return
+	}
+	action := services.AuditTenantReactivate
+	if suspended {
+		action = services.AuditTenantSuspend
+	}
+	recordAudit(c, services.AuditEntry{TenantID: tenant.ID, Action: action, TargetType: services.AuditTargetTenant, TargetID: tenant.ID,
+		Before: gin.H{"suspended": !suspended}, After: gin.H{"suspended": suspended}})
+	c.JSON(http.StatusOK, tenant)
+}
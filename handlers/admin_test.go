@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/middleware"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// adminRouter serves the platform admin and tenant management routes as
+// they are mounted by the server, as an ADMIN of tenantID
+func adminRouter(tenantID string, platformAdmin bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", "admin-1")
+		c.Set("user_role", string(models.RoleAdmin))
+		c.Set("tenant_id", tenantID)
+		c.Set("platform_admin", platformAdmin)
+	})
+	admin := router.Group("/admin", middleware.RequirePlatformAdmin())
+	admin.GET("/tenants", AdminListTenants)
+	admin.POST("/tenants", CreateTenant)
+	admin.POST("/tenants/:id/suspend", SuspendTenant)
+	admin.POST("/tenants/:id/reactivate", ReactivateTenant)
+	admin.PUT("/tenants/:id/plan", SetTenantPlan)
+
+	router.GET("/tenants/:id", GetTenant)
+	router.PATCH("/tenants/:id/security", UpdateTenantSecurity)
+	router.GET("/tenants/:id/oidc", GetTenantOIDC)
+	router.PUT("/tenants/:id/oidc", UpdateTenantOIDC)
+	router.DELETE("/tenants/:id/oidc", DeleteTenantOIDC)
+	router.GET("/tenants/:id/permissions", GetTenantPermissions)
+	router.PUT("/tenants/:id/permissions", UpdateTenantPermission)
+	router.DELETE("/tenants/:id/permissions/:role/:permission", DeleteTenantPermission)
+	router.GET("/tenants/:id/roles", ListCustomRoles)
+	router.POST("/tenants/:id/roles", CreateCustomRole)
+	router.PUT("/tenants/:id/roles/:role_id", UpdateCustomRole)
+	router.DELETE("/tenants/:id/roles/:role_id", DeleteCustomRole)
+	router.GET("/tenants/:id/usage", GetTenantUsage)
+	router.GET("/tenants/:id/settings", GetTenantSettings)
+	router.PATCH("/tenants/:id/settings", UpdateTenantSettings)
+	router.GET("/tenants/:id/domains", ListTenantDomains)
+	router.POST("/tenants/:id/domains", ClaimTenantDomain)
+	router.POST("/tenants/:id/domains/:domain_id/verify", VerifyTenantDomain)
+	router.DELETE("/tenants/:id/domains/:domain_id", DeleteTenantDomain)
+	router.POST("/tenants/:id/invitations", CreateInvitation)
+	router.GET("/tenants/:id/invitations", ListInvitations)
+	router.POST("/tenants/:id/invitations/:invitation_id/resend", ResendInvitation)
+	router.DELETE("/tenants/:id/invitations/:invitation_id", RevokeInvitation)
+	router.POST("/tenants/:id/scim-tokens", CreateSCIMToken)
+	router.GET("/tenants/:id/scim-tokens", ListSCIMTokens)
+	router.DELETE("/tenants/:id/scim-tokens/:token_id", DeleteSCIMToken)
+	return router
+}
+
+func serve(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutesNeedPlatformAdmin(t *testing.T) {
+	ctx := context.Background()
+	// nothing may reach the database
+	mock := testutil.SetupMockDB(t)
+	repos := repository.NewMemory()
+	SetRepositories(repos)
+	one, two := models.Tenant{Name: "One"}, models.Tenant{Name: "Two"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &one))
+	assert.NoError(t, repos.Tenants.Create(ctx, &two))
+
+	// a tenant ADMIN is not a platform admin, not even for their own tenant
+	router := adminRouter(one.ID, false)
+	for _, route := range []struct{ method, path, body string }{
+		{http.MethodGet, "/admin/tenants", ""},
+		{http.MethodPost, "/admin/tenants", `{"name":"Three"}`},
+		{http.MethodPost, "/admin/tenants/" + two.ID + "/suspend", ""},
+		{http.MethodPost, "/admin/tenants/" + one.ID + "/suspend", ""},
+		{http.MethodPost, "/admin/tenants/" + two.ID + "/reactivate", ""},
+		{http.MethodPut, "/admin/tenants/" + two.ID + "/plan", `{"plan_id":"enterprise"}`},
+		{http.MethodPut, "/admin/tenants/" + one.ID + "/plan", `{"plan_id":"enterprise"}`},
+	} {
+		w := serve(router, route.method, route.path, route.body)
+		assert.Equal(t, http.StatusForbidden, w.Code, route.method+" "+route.path)
+		assert.Contains(t, w.Body.String(), "Platform admin required", route.method+" "+route.path)
+	}
+	tenants, err := repos.Tenants.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, tenants, 2, "no tenant was created")
+	for _, tenant := range tenants {
+		assert.Equal(t, "free", tenant.PlanID, tenant.Name)
+		assert.False(t, tenant.Suspended, tenant.Name)
+	}
+
+	w := serve(adminRouter(one.ID, true), http.MethodGet, "/admin/tenants", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed []AdminTenantResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed, 2, "platform admins see every tenant")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantRoutesRejectOtherTenants(t *testing.T) {
+	ctx := context.Background()
+	mock := testutil.SetupMockDB(t)
+	repos := repository.NewMemory()
+	SetRepositories(repos)
+	one, two := models.Tenant{Name: "One"}, models.Tenant{Name: "Two"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &one))
+	assert.NoError(t, repos.Tenants.Create(ctx, &two))
+
+	// an ADMIN of one, even a platform admin, manages two only through /admin
+	for _, platformAdmin := range []bool{false, true} {
+		router := adminRouter(one.ID, platformAdmin)
+		for _, route := range []struct{ method, path, body string }{
+			{http.MethodGet, "", ""},
+			{http.MethodPatch, "/security", `{"require_2fa":false}`},
+			{http.MethodGet, "/oidc", ""},
+			{http.MethodPut, "/oidc", `{"issuer":"https://idp.test","client_id":"x","enabled":true}`},
+			{http.MethodDelete, "/oidc", ""},
+			{http.MethodGet, "/permissions", ""},
+			{http.MethodPut, "/permissions", `{"role":"MEMBER","permission":"tenant.manage","allowed":true}`},
+			{http.MethodDelete, "/permissions/MEMBER/tenant.manage", ""},
+			{http.MethodGet, "/roles", ""},
+			{http.MethodPost, "/roles", `{"key":"OWNER","name":"Owner","permissions":["tenant.manage"]}`},
+			{http.MethodPut, "/roles/role-1", `{"name":"Owner","permissions":["tenant.manage"]}`},
+			{http.MethodDelete, "/roles/role-1", ""},
+			{http.MethodGet, "/usage", ""},
+			{http.MethodGet, "/settings", ""},
+			{http.MethodPatch, "/settings", `{"guest_access":true}`},
+			{http.MethodGet, "/domains", ""},
+			{http.MethodPost, "/domains", `{"domain":"two.test"}`},
+			{http.MethodPost, "/domains/domain-1/verify", ""},
+			{http.MethodDelete, "/domains/domain-1", ""},
+			{http.MethodPost, "/invitations", `{"email":"mallory@one.test","role":"ADMIN"}`},
+			{http.MethodGet, "/invitations", ""},
+			{http.MethodPost, "/invitations/invitation-1/resend", ""},
+			{http.MethodDelete, "/invitations/invitation-1", ""},
+			{http.MethodPost, "/scim-tokens", `{"name":"mine now"}`},
+			{http.MethodGet, "/scim-tokens", ""},
+			{http.MethodDelete, "/scim-tokens/token-1", ""},
+		} {
+			path := "/tenants/" + two.ID + route.path
+			w := serve(router, route.method, path, route.body)
+			assert.Equal(t, http.StatusForbidden, w.Code, route.method+" "+path)
+		}
+	}
+
+	w := serve(adminRouter(one.ID, false), http.MethodGet, "/tenants/"+one.ID, "")
+	assert.Equal(t, http.StatusOK, w.Code, "their own tenant is fine")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+var auditCSVHeader = []string{"seq", "id", "created_at", "tenant_id", "actor_type", "actor_id", "action", "target_type", "target_id", "changes", "ip", "request_id", "prev_hash", "hash"}
+
+// ListAuditLogs lists the caller's tenant's audit log
+// @Summary List audit log
+// @Description Lists who changed what in the organization, newest first. Filter by actor, action (exact, or a group such as "user.*"), target, request ID and time range.
+// @Tags audit
+// @Produce json
+// @Param actor_id query string false "Actor ID"
+// @Param action query string false "Action, e.g. user.update or user.*"
+// @Param target_type query string false "Target type"
+// @Param target_id query string false "Target ID"
+// @Param request_id query string false "Request ID"
+// @Param from query string false "From (RFC 3339, inclusive)"
+// @Param to query string false "To (RFC 3339, exclusive)"
+// @Param page query int false "1-based page"
+// @Param page_size query int false "Entries per page (max 200)"
+// @Success 200 {object} services.AuditLogPage
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /audit-logs [get]
+func ListAuditLogs(c *gin.Context) {
+	filter, ok := auditFilterFromQuery(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(services.AuditDefaultPageSize)))
+
+	result, err := services.ListAuditLogs(c.GetString("tenant_id"), filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ExportAuditLogs downloads the caller's tenant's audit log
+// @Summary Export audit log
+// @Description Downloads the matching audit log entries, oldest first, as CSV or as a JSON array. Takes the same filters as GET /audit-logs.
+// @Tags audit
+// @Produce json,text/csv
+// @Param format query string false "csv (default) or json"
+// @Success 200 {array} models.AuditLog
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /audit-logs/export [get]
+func ExportAuditLogs(c *gin.Context) {
+	filter, ok := auditFilterFromQuery(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	tenantID := c.GetString("tenant_id")
+	filename := fmt.Sprintf("audit-log-%s-%s.%s", tenantID, time.Now().UTC().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	var err error
+	if format == "json" {
+		err = exportAuditJSON(c, tenantID, filter)
+	} else {
+		err = exportAuditCSV(c, tenantID, filter)
+	}
+	// the status is gone once streaming started, so a failure can only be logged
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to export audit log", "error", err)
+	}
+}
+
+func exportAuditCSV(c *gin.Context, tenantID string, filter services.AuditFilter) error {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write(auditCSVHeader); err != nil {
+		return err
+	}
+	err := services.ExportAuditLogs(tenantID, filter, func(entry models.AuditLog) error {
+		changes := ""
+		if len(entry.Changes) > 0 {
+			raw, err := json.Marshal(entry.Changes)
+			if err != nil {
+				return err
+			}
+			changes = string(raw)
+		}
+		return w.Write([]string{
+			strconv.FormatInt(entry.Seq, 10), entry.ID, entry.CreatedAt.UTC().Format(time.RFC3339Nano), entry.TenantID, entry.ActorType, entry.ActorID,
+			entry.Action, entry.TargetType, entry.TargetID, changes, entry.IP, entry.RequestID, entry.PrevHash, entry.Hash,
+		})
+	})
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+func exportAuditJSON(c *gin.Context, tenantID string, filter services.AuditFilter) error {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := c.Writer.WriteString("["); err != nil {
+		return err
+	}
+	first := true
+	err := services.ExportAuditLogs(tenantID, filter, func(entry models.AuditLog) error {
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if !first {
+			raw = append([]byte(","), raw...)
+		}
+		first = false
+		_, err = c.Writer.Write(raw)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.Writer.WriteString("]")
+	return err
+}
+
+// VerifyAuditLog checks the caller's tenant's audit chain
+// @Summary Verify audit log integrity
+// @Description Walks the hash chain of the audit log from the first entry and reports the first broken link: a missing, altered or relinked entry, or one that differs from a signed checkpoint.
+// @Tags audit
+// @Produce json
+// @Success 200 {object} services.AuditVerification
+// @Security ApiKeyAuth
+// @Router /audit-logs/verify [get]
+func VerifyAuditLog(c *gin.Context) {
+	result, err := services.VerifyAuditChain(c.GetString("tenant_id"), nil, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ExportAuditCheckpoints downloads the caller's tenant's signed audit checkpoints
+// @Summary Export audit checkpoints
+// @Description Downloads the signed checkpoints of the audit chain with the public key signing them. Keep the export outside the service; it proves later that no entry up to a checkpoint was changed.
+// @Tags audit
+// @Produce json
+// @Success 200 {object} services.AuditCheckpointExport
+// @Security ApiKeyAuth
+// @Router /audit-logs/checkpoints [get]
+func ExportAuditCheckpoints(c *gin.Context) {
+	tenantID := c.GetString("tenant_id")
+	export, err := services.ListAuditCheckpoints(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch audit checkpoints"})
+		return
+	}
+	filename := fmt.Sprintf("audit-checkpoints-%s-%s.json", tenantID, time.Now().UTC().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.JSON(http.StatusOK, export)
+}
+
+// auditFilterFromQuery reads the audit log filters; it answers 400 and
+// returns false when a time is malformed
+func auditFilterFromQuery(c *gin.Context) (services.AuditFilter, bool) {
+	filter := services.AuditFilter{
+		ActorID:    c.Query("actor_id"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		RequestID:  c.Query("request_id"),
+	}
+	for param, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 time"})
+			return filter, false
+		}
+		*dst = &t
+	}
+	return filter, true
+}
+
+// recordAudit appends entry to the audit log, filling in the tenant, actor,
+// client IP and request ID from the request where entry leaves them empty.
+// A failure is logged; the change itself already happened.
+func recordAudit(c *gin.Context, entry services.AuditEntry) {
+	if entry.TenantID == "" {
+		entry.TenantID = c.GetString("tenant_id")
+	}
+	if entry.ActorID == "" {
+		switch {
+		case c.GetString("scim_token_id") != "":
+			entry.ActorID, entry.ActorType = c.GetString("scim_token_id"), services.AuditActorSCIM
+		case c.GetString("user_id") != "":
+			entry.ActorID, entry.ActorType = c.GetString("user_id"), services.AuditActorUser
+		}
+	}
+	if entry.ActorType == "" {
+		if entry.ActorID != "" {
+			entry.ActorType = services.AuditActorUser
+		} else {
+			entry.ActorType = services.AuditActorAnonymous
+		}
+	}
+	entry.IP = c.ClientIP()
+	entry.RequestID = c.GetString("request_id")
+	if entry.TenantID == "" {
+		slog.WarnContext(c.Request.Context(), "audit entry without tenant skipped", "action", entry.Action, "target_type", entry.TargetType, "target_id", entry.TargetID)
+		return
+	}
+	if err := services.RecordAudit(entry); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to record audit entry", "action", entry.Action, "target_type", entry.TargetType, "target_id", entry.TargetID, "error", err)
+	}
+}
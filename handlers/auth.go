@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
 
-	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/metrics"
 	"github.com/Nyagar-Abraham/chat-app/models"
 	"github.com/Nyagar-Abraham/chat-app/services"
 	"github.com/Nyagar-Abraham/chat-app/utils"
@@ -18,45 +20,89 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// LoginResponse either carries the tokens or, when a second factor is due,
+// a short-lived challenge token for POST /auth/login/2fa (mfa_required) or
+// for enrolling in 2FA (mfa_enrollment_required).
 type LoginResponse struct {
-	Token   string `json:"token"`
-	Message string `json:"message"`
+	Token                 string `json:"token,omitempty"`
+	RefreshToken          string `json:"refresh_token,omitempty"`
+	ExpiresIn             int    `json:"expires_in,omitempty"`
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	ChallengeToken        string `json:"challenge_token,omitempty"`
+	Message               string `json:"message"`
+}
+
+type VerificationEmailRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type RefreshResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	AllDevices   bool   `json:"all_devices"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
 }
 
 type RegisterRequest struct {
 	Name     string `json:"name" binding:"required"`
-	Email    string `json:"email" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
-	Role     string `json:"role" binding:"required,oneof=ADMIN MODERATOR MEMBER GUEST"`
-	OrgName  string `json:"org_name" binding:"required"`
+	// OrgName is ignored when the email domain has been verified by a tenant
+	OrgName string `json:"org_name"`
 }
 
+// RegisterResponse carries no tokens when VerificationRequired is set; the
+// user can log in once the emailed verification link has been used
 type RegisterResponse struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Role     string `json:"role"`
-	Token    string `json:"token"`
-	TenantId string `json:"tenant_id"`
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	Email                string `json:"email"`
+	Role                 string `json:"role"`
+	Token                string `json:"token,omitempty"`
+	RefreshToken         string `json:"refresh_token,omitempty"`
+	TenantId             string `json:"tenant_id"`
+	VerificationRequired bool   `json:"verification_required,omitempty"`
 }
 
 type UserResponse struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Role     string `json:"role"`
-	TenantId string `json:"tenant_id"`
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          string `json:"role"`
+	TenantId      string `json:"tenant_id"`
+	PlatformAdmin bool   `json:"platform_admin,omitempty"`
 }
 
 // Login authenticates a user and returns a JWT token
 // @Summary Login
-// @Description Authenticates a user and returns a JWT token
+// @Description Authenticates a user and returns a JWT token. Failed attempts per account and per client IP delay further attempts, and lock them temporarily after repeated failures (429 with Retry-After).
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param login body LoginRequest true "Login Credentials"
 // @Success 200 {object} LoginResponse
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /auth/login [post]
 func Login(c *gin.Context) {
@@ -65,40 +111,149 @@ func Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	//	validate the user (fetch from db, check password)
-	var user models.User
-	if err := db.DB.Where("email = ?", request.Email).First(&user).Error; err != nil {
+	if err := services.CheckLoginAllowed(request.Email, c.ClientIP()); err != nil {
+		respondLoginThrottled(c, err)
+		return
+	}
+	//	validate the user (fetch from db, check password); unknown emails and
+	//	wrong passwords must look the same, including how long they take
+	user, err := repos.Users.GetByEmail(c.Request.Context(), request.Email)
+	if err != nil {
+		services.CompareDummyPassword(request.Password)
+		recordLoginFailure(c, request.Email, nil)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 	if err := services.CheckPassword(request.Password, user.Password); err != nil {
+		recordLoginFailure(c, request.Email, &user)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+	if err := services.CheckTenantActive(user.TenantID); err != nil {
+		if errors.Is(err, services.ErrTenantSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Organization is suspended"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load organization"})
+		return
+	}
+	if !checkLoginPolicy(c, user) {
+		return
+	}
+
+	//	second factor still due: hand out a challenge token instead
+	if user.TOTPEnabled {
+		respondWithMFAToken(c, user, utils.TokenUseMFAChallenge)
+		return
+	}
+	// with 2FA on, failures are only forgotten once the second factor passed
+	recordLoginSuccess(user)
+	if requireTwoFactorEnrollment(c, user) {
+		return
+	}
 
 	//	Issue JWT token with all required claims
-	token, err := utils.GenerateToken(user)
+	token, refreshToken, err := issueTokens(user)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Could not create token"})
 		return
 	}
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditAuthLogin, TargetType: services.AuditTargetUser, TargetID: user.ID})
 
 	c.JSON(http.StatusOK, LoginResponse{
-		Token:   token,
-		Message: user.Name,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+		Message:      user.Name,
 	})
 
 }
 
+// checkLoginPolicy applies the tenant's policies on who may sign in, whatever
+// the way the user authenticated
+func checkLoginPolicy(c *gin.Context, user models.User) bool {
+	if err := services.CheckGuestAccess(user.TenantID, user.Role); err != nil {
+		if errors.Is(err, services.ErrGuestAccessDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Guest access is disabled"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load organization"})
+		return false
+	}
+	if err := services.CheckEmailVerifiedForLogin(user); err != nil {
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check email verification"})
+		return false
+	}
+	return true
+}
+
+// requireTwoFactorEnrollment answers with an enrollment token when the tenant
+// requires 2FA of the user but they have not enrolled
+func requireTwoFactorEnrollment(c *gin.Context, user models.User) bool {
+	required, err := services.TwoFactorRequired(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check two-factor policy"})
+		return true
+	}
+	if required {
+		respondWithMFAToken(c, user, utils.TokenUseMFAEnrollment)
+		return true
+	}
+	return false
+}
+
+// respondLoginThrottled answers 429 with Retry-After while logins are
+// delayed or locked
+func respondLoginThrottled(c *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check login attempts"})
+		return
+	}
+	metrics.Login(metrics.LoginThrottled)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+}
+
+func recordLoginSuccess(user models.User) {
+	metrics.Login(metrics.LoginSuccess)
+	if err := services.RecordLoginSuccess(user.Email); err != nil {
+		slog.Error("failed to reset failed logins", "login_user_id", user.ID, "error", err)
+	}
+}
+
+func recordLoginFailure(c *gin.Context, email string, user *models.User) {
+	metrics.Login(metrics.LoginFailure)
+	if err := services.RecordLoginFailure(email, c.ClientIP(), user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to record failed login", "error", err)
+	}
+	// attempts on unknown emails belong to no tenant
+	if user != nil {
+		recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorType: services.AuditActorAnonymous, Action: services.AuditAuthLoginFailed,
+			TargetType: services.AuditTargetUser, TargetID: user.ID})
+	}
+}
+
 // Register handles user registration (sign up)
-// @Summary Register a new user
-// @Description Register a new user (admin, moderator, or user)
+// @Summary Register a new organization
+// @Description Creates a new organization owned by the registrant, who becomes its ADMIN. When the email domain has been verified by an organization, the user joins it with its domain join role instead and must verify their email before logging in. Joining any other existing organization requires an invitation, see POST /auth/invitations/accept.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param register body RegisterRequest true "Registration info"
 // @Success 201 {object} RegisterResponse
 // @Failure 400 {object} map[string]string
+// @Failure 402 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /auth/register [post]
 func Register(c *gin.Context) {
 
@@ -108,110 +263,368 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// validate role
-	role := strings.ToUpper(request.Role)
-	if role != "ADMIN" && role != "MODERATOR" && role != "GUEST" && role != "MEMBER" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role: must be ADMIN, MODERATOR, MEMBER, or GUEST"})
-		return
-	}
-
-	// create or find tenant
-	var tenant models.Tenant
-	if request.OrgName != "" {
-		var err error
-		tenant, err = findOrCreateTenant(request.OrgName)
-		if err != nil {
-			log.Printf("Error with tenant: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not handle tenant"})
-			return
-		}
-
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization name is required"})
-		return
-	}
-
 	hash, err := services.HashPassword(request.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not hash password"})
 		return
 	}
-
 	user := models.User{
 		Name:     request.Name,
 		Email:    request.Email,
 		Password: string(hash),
-		Role:     models.Role(role),
-		TenantID: tenant.ID,
 	}
 
-	if err := db.DB.Create(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create user"})
+	tenant, domainJoin, err := services.DomainJoinTenant(request.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not handle tenant"})
 		return
 	}
-
-	token, err := utils.GenerateToken(user)
+	if domainJoin {
+		user, err = services.JoinTenantByDomain(c.Request.Context(), repos, tenant, user)
+	} else {
+		tenant, user, err = services.RegisterTenant(request.OrgName, user)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		if respondQuotaError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrTenantNameTaken), errors.Is(err, services.ErrEmailTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOrgNameRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Organization name is required"})
+		case errors.Is(err, services.ErrTenantSuspended):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Organization is suspended"})
+		case errors.Is(err, services.ErrRegistrationClosed):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Organization only accepts invited members"})
+		default:
+			slog.ErrorContext(c.Request.Context(), "registration failed", "email", request.Email, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create user"})
+		}
 		return
 	}
 
-	if err := services.CreateStreamUser(user); err != nil {
-		log.Printf("Failed to create stream user for %s: %v", user.Email, err)
+	if err := services.CreateStreamUser(c.Request.Context(), user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to create stream user", "new_user_id", user.ID, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create stream user: %v", err)})
 		return
 	}
 
-	c.JSON(http.StatusCreated, RegisterResponse{
+	if err := services.SendEmailVerification(user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to send verification email", "new_user_id", user.ID, "error", err)
+	}
+	if !domainJoin {
+		recordAudit(c, services.AuditEntry{TenantID: tenant.ID, ActorID: user.ID, Action: services.AuditTenantCreate, TargetType: services.AuditTargetTenant, TargetID: tenant.ID, After: tenant})
+	}
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditUserCreate, TargetType: services.AuditTargetUser, TargetID: user.ID, After: user})
+
+	response := RegisterResponse{
 		ID:       user.ID,
 		Email:    user.Email,
 		Name:     user.Name,
 		Role:     string(user.Role),
-		Token:    token,
 		TenantId: user.TenantID,
+	}
+	// the address is what grants access to the tenant, so it must be
+	// verified before the user gets any tokens
+	if user.DomainJoined {
+		response.VerificationRequired = true
+		c.JSON(http.StatusCreated, response)
+		return
+	}
+
+	response.Token, response.RefreshToken, err = issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// Refresh exchanges a refresh token for a new access token
+// @Summary Refresh tokens
+// @Description Rotates a refresh token and returns a new access and refresh token. Reusing a rotated refresh token revokes the whole token family.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param refresh body RefreshRequest true "Refresh token"
+// @Success 200 {object} RefreshResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /auth/refresh [post]
+func Refresh(c *gin.Context) {
+	var request RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	user, refreshToken, err := services.RotateRefreshToken(request.RefreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrTenantSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Organization is suspended"})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to rotate refresh token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not refresh token"})
+		return
+	}
+
+	token, err := utils.GenerateToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditAuthRefresh, TargetType: services.AuditTargetUser, TargetID: user.ID})
+
+	c.JSON(http.StatusOK, RefreshResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
 	})
 }
 
-func GetCurrentUser(context *gin.Context) {
-	user := models.User{}
-	userId, _ := context.Get("user_id")
+// Logout revokes the current access token
+// @Summary Logout
+// @Description Revokes the current access token and, if given, the refresh token family. With all_devices every token of the user is revoked.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param logout body LogoutRequest false "Refresh token to revoke"
+// @Success 200 {object} map[string]bool
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /auth/logout [post]
+func Logout(c *gin.Context) {
+	var request LogoutRequest
+	// the body is optional
+	_ = c.ShouldBindJSON(&request)
 
-	if err := db.DB.Where("id = ?", userId).First(&user).Error; err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find user"})
+	claims := c.MustGet("claims").(*utils.Claims)
+
+	if request.AllDevices {
+		if err := services.RevokeAllUserTokens(claims.UserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke tokens"})
+			return
+		}
+		recordAudit(c, services.AuditEntry{Action: services.AuditAuthLogout, TargetType: services.AuditTargetUser, TargetID: claims.UserID, After: gin.H{"all_devices": true}})
+		c.JSON(http.StatusOK, gin.H{"logged_out": true})
 		return
 	}
 
-	context.JSON(http.StatusOK, UserResponse{
-		ID:       user.ID,
-		Name:     user.Name,
-		Email:    user.Email,
-		Role:     string(user.Role),
-		TenantId: user.TenantID,
+	if err := services.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke token"})
+		return
+	}
+	if request.RefreshToken != "" {
+		if err := services.RevokeRefreshToken(request.RefreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke refresh token"})
+			return
+		}
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditAuthLogout, TargetType: services.AuditTargetUser, TargetID: claims.UserID})
+	c.JSON(http.StatusOK, gin.H{"logged_out": true})
+}
+
+// ChangePassword changes the password of the authenticated user
+// @Summary Change password
+// @Description Changes the current user's password, revokes all existing tokens and returns a fresh token pair
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param password body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} RefreshResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /me/password [put]
+func ChangePassword(c *gin.Context) {
+	var request ChangePasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	user, err := repos.Users.Get(c.Request.Context(), c.GetString("tenant_id"), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := services.CheckPassword(request.CurrentPassword, user.Password); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	hash, err := services.HashPassword(request.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not hash password"})
+		return
+	}
+	user.Password = string(hash)
+	if err := repos.Users.Update(c.Request.Context(), user.TenantID, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update password"})
+		return
+	}
+	if err := services.RevokeAllUserTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke tokens"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUserPasswordChange, TargetType: services.AuditTargetUser, TargetID: user.ID})
+
+	// reload to pick up the bumped token version
+	if user, err = repos.Users.Get(c.Request.Context(), user.TenantID, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find user"})
+		return
+	}
+	token, refreshToken, err := issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+	c.JSON(http.StatusOK, RefreshResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
 	})
 }
 
-// findOrCreateTenant finds an existing tenant by name or creates a new one
-func findOrCreateTenant(orgName string) (models.Tenant, error) {
-	var tenant models.Tenant
+// VerifyEmail confirms an email address using the emailed token
+// @Summary Verify email
+// @Description Marks the user's email address as verified using the token from the verification email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body VerifyEmailRequest true "Verification token"
+// @Success 200 {object} map[string]bool
+// @Failure 400 {object} map[string]string
+// @Router /auth/email/verify [post]
+func VerifyEmail(c *gin.Context) {
+	var request VerifyEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	user, err := services.VerifyEmail(request.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify email"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditAuthEmailVerify, TargetType: services.AuditTargetUser, TargetID: user.ID,
+		Before: gin.H{"email_verified": false}, After: gin.H{"email_verified": true}})
+	c.JSON(http.StatusOK, gin.H{"email_verified": true})
+}
+
+// ResendVerificationEmail sends a new verification link to the current user
+// @Summary Resend verification email
+// @Description Sends a new email verification link to the authenticated user
+// @Tags auth
+// @Produce json
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /auth/email/resend [post]
+func ResendVerificationEmail(c *gin.Context) {
+	user, err := repos.Users.Get(c.Request.Context(), c.GetString("tenant_id"), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email address already verified"})
+		return
+	}
+	if err := services.SendEmailVerification(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send verification email"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditAuthEmailResend, TargetType: services.AuditTargetUser, TargetID: user.ID})
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// RequestVerificationEmail sends a new verification link to an email address
+// @Summary Request verification email
+// @Description Sends a new email verification link if the email belongs to an unverified account, for users who cannot sign in before verifying. The response is the same either way.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body VerificationEmailRequest true "Account email"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /auth/email/verify/resend [post]
+func RequestVerificationEmail(c *gin.Context) {
+	var request VerificationEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
 
-	// Validate input
-	if strings.TrimSpace(orgName) == "" {
-		return tenant, fmt.Errorf("organization name cannot be empty")
+	user, err := services.RequestEmailVerification(request.Email)
+	if err != nil {
+		// do not reveal failures tied to a specific account
+		slog.ErrorContext(c.Request.Context(), "failed to request verification email", "error", err)
 	}
+	if user != nil {
+		recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorType: services.AuditActorAnonymous, Action: services.AuditAuthEmailResend,
+			TargetType: services.AuditTargetUser, TargetID: user.ID})
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is not verified, a verification link has been sent"})
+}
 
-	// Use GORM's FirstOrCreate - atomic operation that finds or creates
-	result := db.DB.Where(models.Tenant{Name: orgName}).FirstOrCreate(&tenant)
-	if result.Error != nil {
-		log.Printf("Error in FirstOrCreate for tenant '%s': %v", orgName, result.Error)
-		return tenant, result.Error
+func GetCurrentUser(context *gin.Context) {
+	user, err := repos.Users.Get(context.Request.Context(), context.GetString("tenant_id"), context.GetString("user_id"))
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find user"})
+		return
 	}
 
-	// Check if this was a new creation
-	if result.RowsAffected > 0 {
-		log.Printf("Created new tenant: '%s' (ID: %s)", tenant.Name, tenant.ID)
-	} else {
-		log.Printf("Found existing tenant: '%s' (ID: %s)", tenant.Name, tenant.ID)
+	context.JSON(http.StatusOK, UserResponse{
+		ID:            user.ID,
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          string(user.Role),
+		TenantId:      user.TenantID,
+		PlatformAdmin: user.PlatformAdmin,
+	})
+}
+
+// respondWithMFAToken answers a correct password with a restricted token
+// instead of a session while the second factor is outstanding.
+func respondWithMFAToken(c *gin.Context, user models.User, use string) {
+	challenge, err := utils.GenerateMFAToken(user, use)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
 	}
+	c.JSON(http.StatusOK, LoginResponse{
+		MFARequired:           use == utils.TokenUseMFAChallenge,
+		MFAEnrollmentRequired: use == utils.TokenUseMFAEnrollment,
+		ChallengeToken:        challenge,
+		ExpiresIn:             int(utils.MFATokenTTL.Seconds()),
+		Message:               user.Name,
+	})
+}
 
-	return tenant, nil
+// issueTokens creates an access token and starts a new refresh token family
+func issueTokens(user models.User) (string, string, error) {
+	token, err := utils.GenerateToken(user)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := services.IssueRefreshToken(user, "")
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
 }
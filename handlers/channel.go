@@ -3,15 +3,13 @@ package handlers
 import (
 	"net/http"
 
-	"log"
-
-	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/metrics"
 	"github.com/Nyagar-Abraham/chat-app/models"
 	"github.com/Nyagar-Abraham/chat-app/services"
 	"github.com/gin-gonic/gin"
 )
 
-// CreateChannel creates a new channel (Admin/Moderator only)
+// CreateChannel creates a new channel (requires channel.create)
 // @Summary Create a channel
 // @Description Creates a new chat channel for the tenant and Stream
 // @Tags channels
@@ -20,6 +18,7 @@ import (
 // @Param channel body models.Channel true "Channel info"
 // @Success 201 {object} models.Channel
 // @Failure 400 {object} map[string]string
+// @Failure 402 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Security ApiKeyAuth
 // @Router /channels [post]
@@ -35,47 +34,23 @@ func CreateChannel(c *gin.Context) {
 		return
 	}
 
-	userRole, _ := c.Get("user_role")
-	if userRole != string(models.RoleAdmin) && userRole != string(models.RoleModerator) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions."})
-		return
-	}
 	tenantID, _ := c.Get("tenant_id")
 	userId, _ := c.Get("user_id")
 
-	//	create channel
-	streamChannelID, err := services.CreateStreamChannel(models.Channel{
+	channel, err := services.CreateGroupChannel(c.Request.Context(), repos, models.Channel{
 		Name:        req.Name,
 		Description: req.Description,
 		TenantID:    tenantID.(string),
-		CreatedBy:   userId.(string),
-	}, userId.(string))
-
+	}, userId.(string), []string{userId.(string)})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create Stream Channel"})
+		if !respondQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create Stream Channel"})
+		}
 		return
 	}
 
-	channel := models.Channel{
-		StreamId:    streamChannelID,
-		Name:        req.Name,
-		Description: req.Description,
-		TenantID:    tenantID.(string),
-		CreatedBy:   userId.(string),
-	}
-
-	if err := db.DB.Create(&channel).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create Stream Channel"})
-		return
-	}
-
-	member := models.ChannelMember{
-		ChannelID: channel.ID,
-		UserID:    userId.(string),
-		TenantID:  tenantID.(string),
-	}
-	db.DB.Create(&member)
-
+	metrics.ChannelCreated(channel.TenantID)
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelCreate, TargetType: services.AuditTargetChannel, TargetID: channel.ID, After: channel})
 	c.JSON(http.StatusCreated, channel)
 }
 
@@ -95,8 +70,8 @@ func ListChannels(c *gin.Context) {
 		return
 	}
 
-	var channels []models.Channel
-	if err := db.DB.Where("tenant_id = ?", tenantID).Find(&channels).Error; err != nil {
+	channels, err := repos.Channels.List(c.Request.Context(), tenantID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch channels"})
 		return
 	}
@@ -104,7 +79,7 @@ func ListChannels(c *gin.Context) {
 	c.JSON(http.StatusOK, channels)
 }
 
-// AddUserToChannel adds a user to a channel (Admin/Moderator only)
+// AddUserToChannel adds a user to a channel (requires channel.members.manage)
 // @Summary Add user to channel
 // @Description Adds a user to a channel within the same tenant
 // @Tags channels
@@ -118,7 +93,6 @@ func ListChannels(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Router /channels/{id}/members [post]
 func AddUserToChannel(c *gin.Context) {
-	log.Println("AddUserToChannel RUN")
 	channelID := c.Param("id")
 	tenantID, _ := c.Get("tenant_id")
 
@@ -127,21 +101,23 @@ func AddUserToChannel(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Println("AddUserToChannel BINDERR=", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	if err := services.AddUserToChannel(channelID, req.UserID, tenantID.(string)); err != nil {
-		log.Println("AddUserToChannel BINDERR=", err.Error())
+	if err := services.AddUserToChannel(c.Request.Context(), repos, channelID, req.UserID, tenantID.(string)); err != nil {
+		if respondQuotaError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelMemberAdd, TargetType: services.AuditTargetChannel, TargetID: channelID, After: gin.H{"user_id": req.UserID}})
 	c.JSON(http.StatusOK, gin.H{"message": "User added to channel"})
 }
 
-// RemoveUserFromChannel removes a user from a channel (Admin/Moderator only)
+// RemoveUserFromChannel removes a user from a channel (requires channel.members.manage)
 // @Summary Remove user from channel
 // @Description Removes a user from a channel
 // @Tags channels
@@ -156,11 +132,12 @@ func RemoveUserFromChannel(c *gin.Context) {
 	userID := c.Param("user_id")
 	tenantID, _ := c.Get("tenant_id")
 
-	if err := services.RemoveUserFromChannel(channelID, userID, tenantID.(string)); err != nil {
+	if err := services.RemoveUserFromChannel(c.Request.Context(), repos, channelID, userID, tenantID.(string)); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelMemberRemove, TargetType: services.AuditTargetChannel, TargetID: channelID, Before: gin.H{"user_id": userID}})
 	c.JSON(http.StatusOK, gin.H{"message": "User removed from channel"})
 }
 
@@ -178,11 +155,15 @@ func JoinChannel(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	tenantID, _ := c.Get("tenant_id")
 
-	if err := services.AddUserToChannel(channelID, userID.(string), tenantID.(string)); err != nil {
+	if err := services.AddUserToChannel(c.Request.Context(), repos, channelID, userID.(string), tenantID.(string)); err != nil {
+		if respondQuotaError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelJoin, TargetType: services.AuditTargetChannel, TargetID: channelID, After: gin.H{"user_id": userID}})
 	c.JSON(http.StatusOK, gin.H{"message": "Joined channel successfully"})
 }
 
@@ -200,11 +181,12 @@ func LeaveChannel(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	tenantID, _ := c.Get("tenant_id")
 
-	if err := services.RemoveUserFromChannel(channelID, userID.(string), tenantID.(string)); err != nil {
+	if err := services.RemoveUserFromChannel(c.Request.Context(), repos, channelID, userID.(string), tenantID.(string)); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelLeave, TargetType: services.AuditTargetChannel, TargetID: channelID, Before: gin.H{"user_id": userID}})
 	c.JSON(http.StatusOK, gin.H{"message": "Left channel successfully"})
 }
 
@@ -222,7 +204,7 @@ func GetChannelMembers(c *gin.Context) {
 	channelID := c.Param("id")
 	tenantID, _ := c.Get("tenant_id")
 
-	users, err := services.GetChannelMembers(channelID, tenantID.(string))
+	users, err := services.GetChannelMembers(c.Request.Context(), repos, channelID, tenantID.(string))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch members"})
 		return
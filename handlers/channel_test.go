@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelMembersStayInTenant(t *testing.T) {
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	SetRepositories(repos)
+	one, two := models.Tenant{Name: "One"}, models.Tenant{Name: "Two"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &one))
+	assert.NoError(t, repos.Tenants.Create(ctx, &two))
+	alice := models.User{Email: "alice@one.test"}
+	assert.NoError(t, repos.Users.Create(ctx, one.ID, &alice))
+	channel := models.Channel{StreamId: "general", Name: "General"}
+	assert.NoError(t, repos.Channels.Create(ctx, one.ID, &channel, alice.ID))
+
+	members := func(tenantID string) []models.User {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("tenant_id", tenantID)
+		})
+		router.GET("/channels/:id/members", GetChannelMembers)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/channels/"+channel.ID+"/members", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var users []models.User
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+		return users
+	}
+
+	if users := members(one.ID); assert.Len(t, users, 1) {
+		assert.Equal(t, alice.ID, users[0].ID)
+	}
+	assert.Empty(t, members(two.ID))
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// DomainClaimRequest claims an email domain for the tenant
+type DomainClaimRequest struct {
+	Domain string `json:"domain" binding:"required"`
+}
+
+// DomainResponse is a domain claim with the TXT record that proves it
+type DomainResponse struct {
+	models.TenantDomain
+	Verified    bool   `json:"verified"`
+	RecordName  string `json:"record_name"`
+	RecordValue string `json:"record_value"`
+}
+
+// ListTenantDomains lists the tenant's domain claims
+// @Summary List domains
+// @Description Lists the email domains claimed by the tenant and the TXT records that verify them
+// @Tags domains
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {array} DomainResponse
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/domains [get]
+func ListTenantDomains(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	domains, err := services.ListTenantDomains(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch domains"})
+		return
+	}
+	response := make([]DomainResponse, 0, len(domains))
+	for _, d := range domains {
+		response = append(response, domainResponse(d))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ClaimTenantDomain claims an email domain
+// @Summary Claim domain
+// @Description Claims an email domain. Publish the returned TXT record, then call verify. Once verified, people registering with an address at the domain join the tenant.
+// @Tags domains
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param domain body DomainClaimRequest true "Domain"
+// @Success 201 {object} DomainResponse
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/domains [post]
+func ClaimTenantDomain(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	var req DomainClaimRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	claim, err := services.ClaimDomain(tenantID, req.Domain)
+	if err != nil {
+		respondDomainError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditDomainClaim, TargetType: services.AuditTargetDomain, TargetID: claim.ID, After: claim})
+	c.JSON(http.StatusCreated, domainResponse(claim))
+}
+
+// VerifyTenantDomain checks the TXT record of a domain claim
+// @Summary Verify domain
+// @Description Looks up the claim's TXT record and marks the domain verified when it is present
+// @Tags domains
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param domain_id path string true "Domain ID"
+// @Success 200 {object} DomainResponse
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/domains/{domain_id}/verify [post]
+func VerifyTenantDomain(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	claim, err := services.VerifyDomain(tenantID, c.Param("domain_id"))
+	if err != nil {
+		respondDomainError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditDomainVerify, TargetType: services.AuditTargetDomain, TargetID: claim.ID,
+		After: gin.H{"domain": claim.Domain, "verified_at": claim.VerifiedAt}})
+	c.JSON(http.StatusOK, domainResponse(claim))
+}
+
+// DeleteTenantDomain removes a domain claim
+// @Summary Delete domain
+// @Description Removes a domain claim; people at the domain no longer join the tenant automatically
+// @Tags domains
+// @Param id path string true "Tenant ID"
+// @Param domain_id path string true "Domain ID"
+// @Success 200 {object} map[string]bool
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/domains/{domain_id} [delete]
+func DeleteTenantDomain(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	if err := services.DeleteTenantDomain(tenantID, c.Param("domain_id")); err != nil {
+		respondDomainError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditDomainDelete, TargetType: services.AuditTargetDomain, TargetID: c.Param("domain_id")})
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+func domainResponse(claim models.TenantDomain) DomainResponse {
+	name, value := services.DomainChallenge(claim)
+	return DomainResponse{TenantDomain: claim, Verified: claim.VerifiedAt != nil, RecordName: name, RecordValue: value}
+}
+
+func respondDomainError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrDomainNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDomainVerifiedElsewhere):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDomainVerificationFailed):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidDomain), errors.Is(err, services.ErrPublicEmailDomain):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request.Context(), "domain claim operation failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not process domain"})
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// InvitationRequest invites an email address to the tenant
+type InvitationRequest struct {
+	Email string      `json:"email" binding:"required,email"`
+	Role  models.Role `json:"role"`
+}
+
+// AcceptInvitationRequest creates the invited account
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// InvitationResponse is an invitation with its derived status
+type InvitationResponse struct {
+	models.Invitation
+	Status string `json:"status"`
+}
+
+// InvitationLookupResponse tells the invitee what they are about to join
+type InvitationLookupResponse struct {
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	TenantID   string    `json:"tenant_id"`
+	TenantName string    `json:"tenant_name"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// CreateInvitation invites someone to join the tenant
+// @Summary Invite a user
+// @Description Emails an invitation link that lets the recipient join the tenant with the given role (the tenant's default role when omitted). Pending invitations for the same address are revoked.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param invitation body InvitationRequest true "Invitation"
+// @Success 201 {object} InvitationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/invitations [post]
+func CreateInvitation(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	var req InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	defaultRole := services.DefaultRoleFor(tenantID)
+	if req.Role == "" {
+		req.Role = defaultRole
+	}
+	if !services.IsValidRole(tenantID, req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role: " + string(req.Role)})
+		return
+	}
+	// inviting with anything but the default role is a role assignment
+	if req.Role != defaultRole {
+		if !requireRoleAssignment(c, tenantID, req.Role) {
+			return
+		}
+	}
+
+	inv, err := services.CreateInvitation(tenantID, req.Email, req.Role, c.GetString("user_id"))
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditInvitationCreate, TargetType: services.AuditTargetInvitation, TargetID: inv.ID, After: inv})
+	c.JSON(http.StatusCreated, invitationResponse(inv))
+}
+
+// ListInvitations lists the tenant's invitations
+// @Summary List invitations
+// @Description Lists the tenant's invitations, newest first
+// @Tags invitations
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param status query string false "pending, accepted, revoked or expired"
+// @Success 200 {array} InvitationResponse
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/invitations [get]
+func ListInvitations(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	invitations, err := services.ListInvitations(tenantID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch invitations"})
+		return
+	}
+	response := make([]InvitationResponse, 0, len(invitations))
+	for _, inv := range invitations {
+		response = append(response, invitationResponse(inv))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ResendInvitation emails a fresh invitation link
+// @Summary Resend invitation
+// @Description Emails a new link and extends the expiry; the previous link stops working
+// @Tags invitations
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param invitation_id path string true "Invitation ID"
+// @Success 200 {object} InvitationResponse
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/invitations/{invitation_id}/resend [post]
+func ResendInvitation(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	inv, err := services.ResendInvitation(tenantID, c.Param("invitation_id"))
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditInvitationResend, TargetType: services.AuditTargetInvitation, TargetID: inv.ID,
+		After: gin.H{"email": inv.Email, "expires_at": inv.ExpiresAt}})
+	c.JSON(http.StatusOK, invitationResponse(inv))
+}
+
+// RevokeInvitation revokes a pending invitation
+// @Summary Revoke invitation
+// @Description Makes a pending invitation unusable
+// @Tags invitations
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param invitation_id path string true "Invitation ID"
+// @Success 200 {object} InvitationResponse
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/invitations/{invitation_id} [delete]
+func RevokeInvitation(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	inv, err := services.RevokeInvitation(tenantID, c.Param("invitation_id"))
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditInvitationRevoke, TargetType: services.AuditTargetInvitation, TargetID: inv.ID,
+		After: gin.H{"email": inv.Email, "revoked_at": inv.RevokedAt}})
+	c.JSON(http.StatusOK, invitationResponse(inv))
+}
+
+// LookupInvitation describes the invitation behind a token
+// @Summary Look up invitation
+// @Description Returns the organization, email and role of a pending invitation so the sign-up form can be prefilled
+// @Tags auth
+// @Produce json
+// @Param token query string true "Invitation token"
+// @Success 200 {object} InvitationLookupResponse
+// @Failure 400 {object} map[string]string
+// @Router /auth/invitations/lookup [get]
+func LookupInvitation(c *gin.Context) {
+	inv, err := services.LookupInvitation(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenant, err := repos.Tenants.Get(c.Request.Context(), inv.TenantID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidInvitation.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, InvitationLookupResponse{
+		Email:      inv.Email,
+		Role:       string(inv.Role),
+		TenantID:   tenant.ID,
+		TenantName: tenant.Name,
+		ExpiresAt:  inv.ExpiresAt,
+	})
+}
+
+// AcceptInvitation creates the invited account
+// @Summary Accept invitation
+// @Description Creates an account in the inviting organization with the invited email and role, and signs it in
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body AcceptInvitationRequest true "Invitation token and account details"
+// @Success 201 {object} RegisterResponse
+// @Success 200 {object} LoginResponse "2FA enrollment required before a session is issued"
+// @Failure 400 {object} map[string]string
+// @Failure 402 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /auth/invitations/accept [post]
+func AcceptInvitation(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	user, err := services.AcceptInvitation(c.Request.Context(), repos, req.Token, req.Name, req.Password)
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditInvitationAccept,
+		TargetType: services.AuditTargetUser, TargetID: user.ID, After: user})
+
+	if err := services.CreateStreamUser(c.Request.Context(), user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to create stream user", "new_user_id", user.ID, "error", err)
+	}
+	// a privileged role may have to enroll in 2FA before getting a session
+	if requireTwoFactorEnrollment(c, user) {
+		return
+	}
+
+	token, refreshToken, err := issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, RegisterResponse{
+		ID:           user.ID,
+		Email:        user.Email,
+		Name:         user.Name,
+		Role:         string(user.Role),
+		Token:        token,
+		RefreshToken: refreshToken,
+		TenantId:     user.TenantID,
+	})
+}
+
+func invitationResponse(inv models.Invitation) InvitationResponse {
+	return InvitationResponse{Invitation: inv, Status: services.InvitationStatus(inv)}
+}
+
+func respondInvitationError(c *gin.Context, err error) {
+	if respondQuotaError(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvitationNotActive), errors.Is(err, services.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidInvitation), errors.Is(err, services.ErrInvalidEmail):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTenantSuspended):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Organization is suspended"})
+	case errors.Is(err, services.ErrRegistrationClosed), errors.Is(err, services.ErrGuestAccessDisabled):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request.Context(), "invitation operation failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not process invitation"})
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys that verify our access tokens
+// @Summary JSON Web Key Set
+// @Description Public keys (RS256/EdDSA) for verifying access tokens, selected by the token's kid header. Empty when tokens are signed with HS256.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} utils.JWKSet
+// @Router /.well-known/jwks.json [get]
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(utils.JWKSCacheMaxAge.Seconds())))
+	c.JSON(http.StatusOK, utils.PublicJWKS())
+}
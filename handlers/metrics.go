@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsHandler = promhttp.Handler()
+
+// Metrics serves the Prometheus metrics. When token is set, scrapers must send
+// it as a bearer token, since the metrics name tenants; the config requires it
+// in release mode.
+func Metrics(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" &&
+			subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Token"})
+			return
+		}
+		metricsHandler.ServeHTTP(c.Writer, c.Request)
+	}
+}
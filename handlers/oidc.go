@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCConfigRequest configures a tenant's OpenID Connect provider. An empty
+// client_secret keeps the stored secret.
+type OIDCConfigRequest struct {
+	Issuer         string                 `json:"issuer" binding:"required,url"`
+	ClientID       string                 `json:"client_id" binding:"required"`
+	ClientSecret   string                 `json:"client_secret"`
+	AllowedDomains []string               `json:"allowed_domains"`
+	RoleClaim      string                 `json:"role_claim"`
+	RoleMapping    map[string]models.Role `json:"role_mapping"`
+	DefaultRole    models.Role            `json:"default_role"`
+	Enabled        bool                   `json:"enabled"`
+}
+
+// GetTenantOIDC returns the tenant's SSO configuration (Admin only)
+// @Summary Get tenant SSO configuration
+// @Description Returns the tenant's OpenID Connect configuration. The client secret is never returned.
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} models.TenantOIDCConfig
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/oidc [get]
+func GetTenantOIDC(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+
+	config, err := repos.OIDCConfigs.Get(c.Request.Context(), tenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSO is not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
+
+// UpdateTenantOIDC creates or replaces the tenant's SSO configuration (Admin only)
+// @Summary Configure tenant SSO
+// @Description Creates or replaces the tenant's OpenID Connect configuration (issuer, client, allowed domains, role mapping)
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param config body OIDCConfigRequest true "OIDC configuration"
+// @Success 200 {object} models.TenantOIDCConfig
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/oidc [put]
+func UpdateTenantOIDC(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+
+	var req OIDCConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	if err := services.ValidateOIDCIssuer(req.Issuer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, role := range req.RoleMapping {
+		if !services.IsValidRole(tenantID, role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role in role_mapping: " + string(role)})
+			return
+		}
+	}
+	if req.DefaultRole == "" {
+		req.DefaultRole = models.RoleMember
+	}
+	if !services.IsValidRole(tenantID, req.DefaultRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid default_role"})
+		return
+	}
+
+	config, err := repos.OIDCConfigs.Get(c.Request.Context(), tenantID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load SSO configuration"})
+		return
+	}
+	before := config
+	config.TenantID = tenantID
+	config.Issuer = req.Issuer
+	config.ClientID = req.ClientID
+	if req.ClientSecret != "" {
+		config.ClientSecret = req.ClientSecret
+	}
+	config.AllowedDomains = req.AllowedDomains
+	config.RoleClaim = req.RoleClaim
+	config.RoleMapping = req.RoleMapping
+	config.DefaultRole = req.DefaultRole
+	config.Enabled = req.Enabled
+
+	if err := repos.OIDCConfigs.Save(c.Request.Context(), tenantID, &config); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save SSO configuration"})
+		return
+	}
+	entry := services.AuditEntry{Action: services.AuditTenantOIDCUpdate, TargetType: services.AuditTargetTenant, TargetID: tenantID, Before: before, After: config}
+	if req.ClientSecret != "" {
+		// the secret is never serialized, so the diff cannot show that it changed
+		entry.Changes = map[string]models.AuditChange{"client_secret": {After: services.AuditRedacted}}
+	}
+	recordAudit(c, entry)
+	c.JSON(http.StatusOK, config)
+}
+
+// DeleteTenantOIDC removes the tenant's SSO configuration (Admin only)
+// @Summary Remove tenant SSO configuration
+// @Description Removes the tenant's OpenID Connect configuration
+// @Tags tenants
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} map[string]bool
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/oidc [delete]
+func DeleteTenantOIDC(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	if err := repos.OIDCConfigs.Delete(c.Request.Context(), tenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete SSO configuration"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditTenantOIDCDelete, TargetType: services.AuditTargetTenant, TargetID: tenantID})
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+// OIDCLogin starts single sign-on for a tenant
+// @Summary Start SSO login
+// @Description Redirects the browser to the tenant's identity provider (authorization code flow with PKCE)
+// @Tags auth
+// @Param tenant_id path string true "Tenant ID"
+// @Success 302
+// @Failure 404 {object} map[string]string
+// @Router /auth/oidc/{tenant_id}/login [get]
+func OIDCLogin(c *gin.Context) {
+	authURL, err := services.StartOIDCLogin(c.Request.Context(), c.Param("tenant_id"))
+	if err != nil {
+		if errors.Is(err, services.ErrOIDCNotConfigured) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrTenantSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to start OIDC login", "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not reach identity provider"})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes single sign-on
+// @Summary SSO callback
+// @Description Redirect target of the identity provider. Verifies the ID token, provisions the user on first login and returns our access and refresh tokens, or a challenge or enrollment token when the tenant requires 2FA, as POST /auth/login does.
+// @Tags auth
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 402 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /auth/oidc/callback [get]
+func OIDCCallback(c *gin.Context) {
+	if idpErr := c.Query("error"); idpErr != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Identity provider error: " + idpErr})
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	user, err := services.CompleteOIDCLogin(c.Request.Context(), repos, state, code)
+	if err != nil {
+		if respondQuotaError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrOIDCInvalidState), errors.Is(err, services.ErrOIDCNotConfigured):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOIDCDomainDenied), errors.Is(err, services.ErrOIDCEmailInUse), errors.Is(err, services.ErrOIDCEmailUnverified), errors.Is(err, services.ErrAccountDisabled), errors.Is(err, services.ErrTenantSuspended):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			slog.ErrorContext(c.Request.Context(), "failed to complete OIDC login", "error", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		}
+		return
+	}
+
+	// the tenant's policies apply as to password logins, including its own
+	// second factor: whatever the identity provider checked is not known here
+	if !checkLoginPolicy(c, user) {
+		return
+	}
+	if user.TOTPEnabled {
+		respondWithMFAToken(c, user, utils.TokenUseMFAChallenge)
+		return
+	}
+	if requireTwoFactorEnrollment(c, user) {
+		return
+	}
+
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditAuthOIDCLogin, TargetType: services.AuditTargetUser, TargetID: user.ID})
+	token, refreshToken, err := issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+	c.JSON(http.StatusOK, LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+		Message:      user.Name,
+	})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// ForgotPassword emails a password reset link
+// @Summary Request password reset
+// @Description Sends a single-use password reset link if the email belongs to an account. The response is the same either way.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Account email"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /auth/password/forgot [post]
+func ForgotPassword(c *gin.Context) {
+	var request ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	user, err := services.RequestPasswordReset(request.Email)
+	if err != nil {
+		// do not reveal failures tied to a specific account
+		slog.ErrorContext(c.Request.Context(), "failed to request password reset", "error", err)
+	}
+	if user != nil {
+		recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorType: services.AuditActorAnonymous, Action: services.AuditAuthPasswordResetRequest,
+			TargetType: services.AuditTargetUser, TargetID: user.ID})
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using a reset token
+// @Summary Reset password
+// @Description Sets a new password using the token from the reset email and revokes all existing sessions
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/password/reset [post]
+func ResetPassword(c *gin.Context) {
+	var request ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	user, err := services.ResetPassword(request.Token, request.NewPassword)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to reset password", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditAuthPasswordReset, TargetType: services.AuditTargetUser, TargetID: user.ID})
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
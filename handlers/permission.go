@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TenantPermissionRequest grants (allowed=true) or denies a permission to a role
+type TenantPermissionRequest struct {
+	Role       string            `json:"role" binding:"required"`
+	Permission models.Permission `json:"permission" binding:"required"`
+	Allowed    bool              `json:"allowed"`
+}
+
+// TenantPermissionsResponse is the tenant's effective policy and its overrides
+type TenantPermissionsResponse struct {
+	Policy    services.Policy         `json:"policy"`
+	Overrides []models.RolePermission `json:"overrides"`
+}
+
+// ListPermissions returns the permission catalog
+// @Summary List permissions
+// @Description Lists every permission that can be bound to roles
+// @Tags permissions
+// @Produce json
+// @Success 200 {array} services.PermissionInfo
+// @Security ApiKeyAuth
+// @Router /permissions [get]
+func ListPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, services.PermissionCatalog)
+}
+
+// GetTenantPermissions returns the tenant's role permissions
+// @Summary Get tenant role permissions
+// @Description Returns which roles hold which permissions in the tenant, and the tenant's overrides of the platform defaults
+// @Tags permissions
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} TenantPermissionsResponse
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/permissions [get]
+func GetTenantPermissions(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	policy, err := services.TenantPolicy(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load permissions"})
+		return
+	}
+	overrides, err := services.TenantPermissionOverrides(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load permissions"})
+		return
+	}
+	c.JSON(http.StatusOK, TenantPermissionsResponse{Policy: policy, Overrides: overrides})
+}
+
+// UpdateTenantPermission overrides a role permission for the tenant
+// @Summary Override a role permission
+// @Description Grants or denies a permission to a role in the tenant, overriding the platform default
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param request body TenantPermissionRequest true "Role, permission and whether it is allowed"
+// @Success 200 {object} models.RolePermission
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/permissions [put]
+func UpdateTenantPermission(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	var req TenantPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	if !services.IsValidRole(tenantID, models.Role(req.Role)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role: " + req.Role})
+		return
+	}
+
+	wasAllowed, _ := services.HasPermission(tenantID, req.Role, req.Permission)
+	row, err := services.SetTenantPermission(c.Request.Context(), tenantID, req.Role, req.Permission, req.Allowed)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownPermission) || errors.Is(err, services.ErrPermissionLockout) || errors.Is(err, services.ErrPermissionNotDelegable) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update permission"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditPermissionUpdate, TargetType: services.AuditTargetRole, TargetID: req.Role,
+		Before: gin.H{string(req.Permission): wasAllowed}, After: gin.H{string(req.Permission): req.Allowed}})
+	c.JSON(http.StatusOK, row)
+}
+
+// DeleteTenantPermission removes a tenant override
+// @Summary Reset a role permission
+// @Description Removes the tenant's override so the platform default applies again
+// @Tags permissions
+// @Param id path string true "Tenant ID"
+// @Param role path string true "Role"
+// @Param permission path string true "Permission"
+// @Success 200 {object} map[string]bool
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/permissions/{role}/{permission} [delete]
+func DeleteTenantPermission(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	role, permission := c.Param("role"), models.Permission(c.Param("permission"))
+	wasAllowed, _ := services.HasPermission(tenantID, role, permission)
+	if err := services.ClearTenantPermission(c.Request.Context(), tenantID, role, permission); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset permission"})
+		return
+	}
+	isAllowed, _ := services.HasPermission(tenantID, role, permission)
+	recordAudit(c, services.AuditEntry{Action: services.AuditPermissionDelete, TargetType: services.AuditTargetRole, TargetID: role,
+		Before: gin.H{string(permission): wasAllowed}, After: gin.H{string(permission): isAllowed}})
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TenantPlanRequest moves a tenant to another plan
+type TenantPlanRequest struct {
+	PlanID string `json:"plan_id" binding:"required"`
+}
+
+// ListPlans lists the available plans
+// @Summary List plans
+// @Description Lists the plans and their limits; a limit of 0 means unlimited
+// @Tags plans
+// @Produce json
+// @Success 200 {array} models.Plan
+// @Security ApiKeyAuth
+// @Router /plans [get]
+func ListPlans(c *gin.Context) {
+	plans, err := services.ListPlans()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch plans"})
+		return
+	}
+	c.JSON(http.StatusOK, plans)
+}
+
+// GetTenantUsage reports the tenant's usage against its plan
+// @Summary Get tenant usage
+// @Description Shows users, channels, the largest channel, today's messages and storage against the limits of the tenant's plan
+// @Tags plans
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} services.UsageReport
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/usage [get]
+func GetTenantUsage(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	report, err := services.TenantUsage(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load usage"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// SetTenantPlan moves a tenant to another plan (Platform admin only)
+// @Summary Change tenant plan
+// @Description Moves a tenant to another plan. Usage above the new limits is kept, but nothing more can be added.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param plan body TenantPlanRequest true "Plan"
+// @Success 200 {object} models.Tenant
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/tenants/{id}/plan [put]
+func SetTenantPlan(c *gin.Context) {
+	var req TenantPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	plan, err := services.GetPlan(req.PlanID)
+	if err != nil {
+		if errors.Is(err, services.ErrPlanNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change plan"})
+		return
+	}
+	tenant, err := repos.Tenants.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+	previous := tenant.PlanID
+	if previous == "" {
+		previous = services.DefaultPlanID
+	}
+	// usage above the new limits is kept, but nothing more can be added
+	// until it is back under them
+	tenant.PlanID = plan.ID
+	if err := repos.Tenants.Update(c.Request.Context(), &tenant); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change plan"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{TenantID: tenant.ID, Action: services.AuditTenantPlan, TargetType: services.AuditTargetTenant, TargetID: tenant.ID,
+		Before: gin.H{"plan_id": previous}, After: gin.H{"plan_id": tenant.PlanID}})
+	c.JSON(http.StatusOK, tenant)
+}
+
+// respondQuotaError answers with 402 when a plan limit is reached, or 429
+// with Retry-After when a limit that resets is. It reports whether err was
+// a quota error.
+func respondQuotaError(c *gin.Context, err error) bool {
+	var quotaErr *services.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	status := quotaStatus(quotaErr)
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(quotaErr.RetryAfter.Seconds()))))
+	}
+	c.JSON(status, gin.H{
+		"error": quotaErr.Error(),
+		"quota": quotaErr.Quota,
+		"limit": quotaErr.Limit,
+		"used":  quotaErr.Used,
+	})
+	return true
+}
+
+func quotaStatus(err *services.QuotaError) int {
+	if err.RetryAfter > 0 {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusPaymentRequired
+}
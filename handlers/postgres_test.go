@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestJoinsOnMigratedSchema runs the queries joining uuid columns against
+// the schema built by the migrations
+func TestJoinsOnMigratedSchema(t *testing.T) {
+	testutil.SetupPostgres(t)
+	SetRepositories(repository.NewGORM(db.DB))
+	tenant := models.Tenant{Name: "Acme"}
+	assert.NoError(t, db.DB.Create(&tenant).Error)
+	user := models.User{Email: "alice@acme.test", Password: "x", TenantID: tenant.ID}
+	assert.NoError(t, db.DB.Create(&user).Error)
+	channel := models.Channel{StreamId: "general", Name: "General", TenantID: tenant.ID}
+	assert.NoError(t, db.DB.Create(&channel).Error)
+	assert.NoError(t, db.DB.Create(&models.ChannelMember{ChannelID: channel.ID, UserID: user.ID, TenantID: tenant.ID}).Error)
+
+	members, err := services.GetChannelMembers(context.Background(), repos, channel.ID, tenant.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, user.ID, members[0].ID)
+	}
+
+	groups, err := repos.Channels.ListByMember(context.Background(), tenant.ID, user.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, channel.ID, groups[0].ID)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/admin/tenants", AdminListTenants)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/tenants", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tenants []AdminTenantResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tenants))
+	if assert.Len(t, tenants, 1) {
+		assert.Equal(t, int64(1), tenants[0].UserCount)
+	}
+}
+
+// TestUserQuotaHoldsUnderConcurrency creates users of a tenant with one place
+// left from many requests at once; exactly one of them may succeed
+func TestUserQuotaHoldsUnderConcurrency(t *testing.T) {
+	testutil.SetupPostgres(t)
+	ctx := context.Background()
+	repos := repository.NewGORM(db.DB)
+	assert.NoError(t, db.DB.Create(&models.Plan{ID: "tiny", Name: "Tiny", MaxUsers: 2}).Error)
+	tenant := models.Tenant{Name: "Acme", PlanID: "tiny"}
+	assert.NoError(t, db.DB.Create(&tenant).Error)
+	assert.NoError(t, db.DB.Create(&models.User{Email: "first@acme.test", Password: "x", TenantID: tenant.ID}).Error)
+
+	const requests = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repos.Tenants.Lock(ctx, tenant.ID, func(tx repository.Repositories) error {
+				if err := services.CheckUserQuota(ctx, tx, tenant.ID); err != nil {
+					return err
+				}
+				return tx.Users.Create(ctx, tenant.ID, &models.User{Email: fmt.Sprintf("user%d@acme.test", i), Password: "x"})
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		var quotaErr *services.QuotaError
+		if err == nil {
+			created++
+		} else {
+			assert.ErrorAs(t, err, &quotaErr)
+		}
+	}
+	assert.Equal(t, 1, created)
+	var users int64
+	assert.NoError(t, db.DB.Model(&models.User{}).Where("tenant_id = ?", tenant.ID).Count(&users).Error)
+	assert.Equal(t, int64(2), users)
+}
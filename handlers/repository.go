@@ -0,0 +1,13 @@
+package handlers
+
+import "github.com/Nyagar-Abraham/chat-app/repository"
+
+// repos is where handlers read and write tenants, users, channels and
+// memberships
+var repos repository.Repositories
+
+// SetRepositories sets the repositories used by the handlers: the GORM ones
+// when serving, in-memory ones in tests
+func SetRepositories(r repository.Repositories) {
+	repos = r
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// CustomRoleRequest creates or updates a custom role
+type CustomRoleRequest struct {
+	Name        string              `json:"name" binding:"required"`
+	Description string              `json:"description"`
+	Permissions []models.Permission `json:"permissions"`
+}
+
+// ListCustomRoles lists the tenant's custom roles
+// @Summary List custom roles
+// @Description Lists the tenant's custom roles and their permissions
+// @Tags roles
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {array} models.CustomRole
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/roles [get]
+func ListCustomRoles(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	roles, err := services.ListCustomRoles(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// CreateCustomRole creates a custom role
+// @Summary Create custom role
+// @Description Creates a role composed of permissions. Users are assigned it by setting their role to the returned key. The role is also created on Stream.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param role body CustomRoleRequest true "Role"
+// @Success 201 {object} models.CustomRole
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/roles [post]
+func CreateCustomRole(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	var req CustomRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	role, err := services.CreateCustomRole(c.Request.Context(), tenantID, req.Name, req.Description, req.Permissions)
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditRoleCreate, TargetType: services.AuditTargetRole, TargetID: role.ID, After: role})
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateCustomRole renames a custom role and replaces its permissions
+// @Summary Update custom role
+// @Description Renames a custom role and replaces its permissions; its key is unchanged
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param role_id path string true "Role ID"
+// @Param role body CustomRoleRequest true "Role"
+// @Success 200 {object} models.CustomRole
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/roles/{role_id} [put]
+func UpdateCustomRole(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	var req CustomRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	role, err := services.GetCustomRole(tenantID, c.Param("role_id"))
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	before := role
+	role, err = services.UpdateCustomRole(c.Request.Context(), role, req.Name, req.Description, req.Permissions)
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditRoleUpdate, TargetType: services.AuditTargetRole, TargetID: role.ID, Before: before, After: role})
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteCustomRole deletes a custom role that is not assigned to any user
+// @Summary Delete custom role
+// @Description Deletes a custom role. Fails while users still hold it.
+// @Tags roles
+// @Param id path string true "Tenant ID"
+// @Param role_id path string true "Role ID"
+// @Success 200 {object} map[string]bool
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/roles/{role_id} [delete]
+func DeleteCustomRole(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	role, err := services.GetCustomRole(tenantID, c.Param("role_id"))
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	if err := services.DeleteCustomRole(c.Request.Context(), role); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditRoleDelete, TargetType: services.AuditTargetRole, TargetID: role.ID, Before: role})
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+func respondRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleExists), errors.Is(err, services.ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRoleName), errors.Is(err, services.ErrUnknownPermission), errors.Is(err, services.ErrPermissionNotDelegable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request.Context(), "custom role operation failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save role"})
+	}
+}
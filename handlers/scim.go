@@ -0,0 +1,1176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Nyagar-Abraham/chat-app/metrics"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scimUserSchema   = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema  = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema  = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	scimDefaultCount = 100
+	scimMaxCount     = 200
+)
+
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+// SCIMUser is the SCIM representation of models.User. userName is the email.
+type SCIMUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *SCIMName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []SCIMMultiValue `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Roles       []SCIMMultiValue `json:"roles,omitempty"`
+	Groups      []SCIMMultiValue `json:"groups,omitempty"`
+	Password    string           `json:"password,omitempty"`
+	Meta        *SCIMMeta        `json:"meta,omitempty"`
+}
+
+// SCIMGroup is the SCIM representation of a channel and its members
+type SCIMGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []SCIMMultiValue `json:"members"`
+	Meta        *SCIMMeta        `json:"meta,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations" binding:"required"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type CreateSCIMTokenResponse struct {
+	models.SCIMToken
+	Token string `json:"token"`
+}
+
+var scimMemberPathPattern = regexp.MustCompile(`(?i)^members\[value eq "([^"]+)"\]$`)
+
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	c.Header("Content-Type", "application/scim+json")
+	c.AbortWithStatusJSON(status, body)
+}
+
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(status, body)
+}
+
+// scimFilter reads the filter, startIndex and count query parameters; it
+// responds with a SCIM error when the filter is invalid
+func scimFilter(c *gin.Context, attributes map[string]services.SCIMAttribute) (repository.Filter, bool) {
+	start, count := scimPage(c)
+	filter := repository.Filter{Offset: start - 1, Limit: count}
+	if query := c.Query("filter"); query != "" {
+		var err error
+		if filter.Where, filter.Args, err = services.ParseSCIMFilter(query, attributes); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+			return filter, false
+		}
+	}
+	return filter, true
+}
+
+// scimPage reads the 1-based startIndex and count query parameters
+func scimPage(c *gin.Context) (int, int) {
+	start, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(scimDefaultCount)))
+	if err != nil || count < 0 {
+		count = scimDefaultCount
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+	return start, count
+}
+
+func scimLocation(c *gin.Context, resource, id string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%s", scheme, c.Request.Host, resource, id)
+}
+
+// --- SCIM TOKEN MANAGEMENT ---
+
+// CreateSCIMToken issues a SCIM provisioning token (Admin only)
+// @Summary Create SCIM token
+// @Description Issues a bearer token for the tenant's SCIM provisioning client. The token is only returned once.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param request body CreateSCIMTokenRequest true "Token name"
+// @Success 201 {object} CreateSCIMTokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/scim-tokens [post]
+func CreateSCIMToken(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	var req CreateSCIMTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	token, raw, err := services.CreateSCIMToken(c.Request.Context(), repos, tenantID, req.Name, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create SCIM token"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditSCIMTokenCreate, TargetType: services.AuditTargetSCIMToken, TargetID: token.ID, After: token})
+	c.JSON(http.StatusCreated, CreateSCIMTokenResponse{SCIMToken: token, Token: raw})
+}
+
+// ListSCIMTokens lists the tenant's SCIM tokens (Admin only)
+// @Summary List SCIM tokens
+// @Description Lists the tenant's SCIM provisioning tokens (without the secret)
+// @Tags scim
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {array} models.SCIMToken
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/scim-tokens [get]
+func ListSCIMTokens(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	tokens, err := repos.SCIMTokens.List(c.Request.Context(), tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch SCIM tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// DeleteSCIMToken revokes a SCIM token (Admin only)
+// @Summary Revoke SCIM token
+// @Description Revokes a SCIM provisioning token
+// @Tags scim
+// @Param id path string true "Tenant ID"
+// @Param token_id path string true "Token ID"
+// @Success 200 {object} map[string]bool
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/scim-tokens/{token_id} [delete]
+func DeleteSCIMToken(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	if err := repos.SCIMTokens.Delete(c.Request.Context(), tenantID, c.Param("token_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke SCIM token"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditSCIMTokenDelete, TargetType: services.AuditTargetSCIMToken, TargetID: c.Param("token_id")})
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+// SCIMServiceProviderConfig describes the supported SCIM features
+// @Summary SCIM service provider configuration
+// @Tags scim
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /scim/v2/ServiceProviderConfig [get]
+func SCIMServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{scimConfigSchema},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Per-tenant SCIM token issued via POST /tenants/{id}/scim-tokens",
+		}},
+	})
+}
+
+// --- SCIM USERS ---
+
+func toSCIMUser(c *gin.Context, user models.User, groups []models.Channel) SCIMUser {
+	active := !user.Disabled
+	resource := SCIMUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          user.ID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		Name:        &SCIMName{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []SCIMMultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Roles:       []SCIMMultiValue{{Value: string(user.Role), Primary: true}},
+		Meta:        &SCIMMeta{ResourceType: "User", Location: scimLocation(c, "Users", user.ID)},
+	}
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, SCIMMultiValue{Value: group.ID, Display: group.Name})
+	}
+	return resource
+}
+
+func findSCIMUser(c *gin.Context) (models.User, bool) {
+	user, err := repos.Users.Get(c.Request.Context(), c.GetString("tenant_id"), c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "User not found")
+		return user, false
+	}
+	return user, true
+}
+
+// ListSCIMUsers lists users with optional filtering
+// @Summary SCIM list users
+// @Tags scim
+// @Produce json
+// @Param filter query string false "SCIM filter, e.g. userName eq \"a@b.com\""
+// @Param startIndex query int false "1-based start index"
+// @Param count query int false "Page size"
+// @Success 200 {object} SCIMListResponse
+// @Router /scim/v2/Users [get]
+func ListSCIMUsers(c *gin.Context) {
+	filter, ok := scimFilter(c, services.SCIMUserAttributes)
+	if !ok {
+		return
+	}
+	users, total, err := repos.Users.Search(c.Request.Context(), c.GetString("tenant_id"), filter)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not fetch users")
+		return
+	}
+
+	resources := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, toSCIMUser(c, user, nil))
+	}
+	scimJSON(c, http.StatusOK, SCIMListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   filter.Offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// GetSCIMUser returns a single user
+// @Summary SCIM get user
+// @Tags scim
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} SCIMUser
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Users/{id} [get]
+func GetSCIMUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+	groups, err := repos.Channels.ListByMember(c.Request.Context(), user.TenantID, user.ID)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not fetch groups")
+		return
+	}
+	scimJSON(c, http.StatusOK, toSCIMUser(c, user, groups))
+}
+
+// CreateSCIMUser provisions a user
+// @Summary SCIM create user
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param user body SCIMUser true "SCIM user"
+// @Success 201 {object} SCIMUser
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /scim/v2/Users [post]
+func CreateSCIMUser(c *gin.Context) {
+	var req SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", InvalidRequestMessage)
+		return
+	}
+
+	user := models.User{TenantID: c.GetString("tenant_id"), Role: services.DefaultRoleFor(c.GetString("tenant_id"))}
+	if err := applySCIMUser(&user, req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	if _, err := repos.Users.GetByEmail(c.Request.Context(), user.Email); err == nil {
+		scimError(c, http.StatusConflict, "uniqueness", "userName is already taken")
+		return
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		scimError(c, http.StatusInternalServerError, "", "Could not create user")
+		return
+	}
+
+	password := req.Password
+	if password == "" {
+		// provisioned users sign in through SSO or a password reset
+		random, err := utils.NewOpaqueToken()
+		if err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Could not create user")
+			return
+		}
+		password = random
+	}
+	hash, err := services.HashPassword(password)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not create user")
+		return
+	}
+	user.Password = string(hash)
+	// the identity provider vouches for the address
+	user.EmailVerified = true
+
+	ctx := c.Request.Context()
+	err = repos.Tenants.Lock(ctx, user.TenantID, func(tx repository.Repositories) error {
+		if err := services.CheckUserQuota(ctx, tx, user.TenantID); err != nil {
+			return err
+		}
+		return tx.Users.Create(ctx, user.TenantID, &user)
+	})
+	if err != nil {
+		var quotaErr *services.QuotaError
+		switch {
+		case errors.As(err, &quotaErr):
+			respondSCIMQuotaError(c, err)
+		case errors.Is(err, repository.ErrDuplicate):
+			scimError(c, http.StatusConflict, "uniqueness", "userName is already taken")
+		default:
+			scimError(c, http.StatusInternalServerError, "", "Could not create user")
+		}
+		return
+	}
+	if err := services.CreateStreamUser(c.Request.Context(), user); err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not create stream user")
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUserCreate, TargetType: services.AuditTargetUser, TargetID: user.ID, After: user})
+	scimJSON(c, http.StatusCreated, toSCIMUser(c, user, nil))
+}
+
+// ReplaceSCIMUser replaces a user's attributes
+// @Summary SCIM replace user
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param user body SCIMUser true "SCIM user"
+// @Success 200 {object} SCIMUser
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Users/{id} [put]
+func ReplaceSCIMUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+	var req SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", InvalidRequestMessage)
+		return
+	}
+
+	before := user
+	// attributes omitted from a PUT are cleared
+	user.ExternalID = ""
+	if req.Active == nil {
+		active := true
+		req.Active = &active
+	}
+	if err := applySCIMUser(&user, req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	if !saveSCIMUser(c, before, user) {
+		return
+	}
+	GetSCIMUser(c)
+}
+
+// PatchSCIMUser applies SCIM PATCH operations to a user
+// @Summary SCIM patch user
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param patch body SCIMPatchRequest true "Patch operations"
+// @Success 200 {object} SCIMUser
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Users/{id} [patch]
+func PatchSCIMUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+	var req SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", InvalidRequestMessage)
+		return
+	}
+
+	before := user
+	for _, op := range req.Operations {
+		if err := patchSCIMUser(&user, op); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+	if !saveSCIMUser(c, before, user) {
+		return
+	}
+	GetSCIMUser(c)
+}
+
+// DeleteSCIMUser deprovisions a user
+// @Summary SCIM delete user
+// @Tags scim
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Users/{id} [delete]
+func DeleteSCIMUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+	if err := services.RevokeAllUserTokens(user.ID); err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not revoke user tokens")
+		return
+	}
+	if err := repos.Users.Delete(c.Request.Context(), user.TenantID, user.ID); err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not delete user")
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUserDelete, TargetType: services.AuditTargetUser, TargetID: user.ID, Before: user})
+	c.Status(http.StatusNoContent)
+}
+
+// applySCIMUser copies the attributes of a full SCIM user resource
+func applySCIMUser(user *models.User, req SCIMUser) error {
+	email := req.UserName
+	if email == "" {
+		email = primaryValue(req.Emails)
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := services.ValidateEmail(email); err != nil {
+		return errors.New("userName must be a valid email address")
+	}
+	user.Email = email
+
+	switch {
+	case req.DisplayName != "":
+		user.Name = req.DisplayName
+	case req.Name != nil && req.Name.Formatted != "":
+		user.Name = req.Name.Formatted
+	case req.Name != nil:
+		user.Name = strings.TrimSpace(req.Name.GivenName + " " + req.Name.FamilyName)
+	}
+	if user.Name == "" {
+		user.Name = email
+	}
+
+	if req.ExternalID != "" {
+		user.ExternalID = req.ExternalID
+	}
+	if req.Active != nil {
+		user.Disabled = !*req.Active
+	}
+	if role := primaryValue(req.Roles); role != "" {
+		if !services.IsValidRole(user.TenantID, models.Role(strings.ToUpper(role))) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		user.Role = models.Role(strings.ToUpper(role))
+	}
+	return nil
+}
+
+// patchSCIMUser applies a single PATCH operation
+func patchSCIMUser(user *models.User, op SCIMPatchOperation) error {
+	path := strings.ToLower(op.Path)
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		if path == "" {
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &attrs); err != nil {
+				return errors.New("value must be an object when no path is given")
+			}
+			for key, value := range attrs {
+				if err := setSCIMUserAttribute(user, strings.ToLower(key), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return setSCIMUserAttribute(user, path, op.Value)
+	case "remove":
+		switch path {
+		case "externalid":
+			user.ExternalID = ""
+		case "roles":
+			// back to the role of users provisioned without one
+			user.Role = services.DefaultRoleFor(user.TenantID)
+		default:
+			return fmt.Errorf("attribute %q cannot be removed", op.Path)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported patch op %q", op.Op)
+}
+
+func setSCIMUserAttribute(user *models.User, path string, raw json.RawMessage) error {
+	// emails[type eq "work"].value and similar filtered paths address the single email
+	if strings.HasPrefix(path, "emails[") {
+		path = "emails.value"
+	}
+	if strings.HasPrefix(path, "roles[") {
+		path = "roles.value"
+	}
+
+	switch path {
+	case "active":
+		active, err := scimBool(raw)
+		if err != nil {
+			return err
+		}
+		user.Disabled = !active
+	case "username", "emails.value":
+		var email string
+		if err := json.Unmarshal(raw, &email); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		email = strings.ToLower(strings.TrimSpace(email))
+		if err := services.ValidateEmail(email); err != nil {
+			return err
+		}
+		user.Email = email
+	case "emails":
+		var emails []SCIMMultiValue
+		if err := json.Unmarshal(raw, &emails); err != nil {
+			return errors.New("emails must be a list")
+		}
+		email := strings.ToLower(strings.TrimSpace(primaryValue(emails)))
+		if err := services.ValidateEmail(email); err != nil {
+			return err
+		}
+		user.Email = email
+	case "displayname", "name.formatted":
+		if err := json.Unmarshal(raw, &user.Name); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+	case "name":
+		var name SCIMName
+		if err := json.Unmarshal(raw, &name); err != nil {
+			return errors.New("name must be an object")
+		}
+		if name.Formatted != "" {
+			user.Name = name.Formatted
+		} else if full := strings.TrimSpace(name.GivenName + " " + name.FamilyName); full != "" {
+			user.Name = full
+		}
+	case "name.givenname", "name.familyname":
+		var part string
+		if err := json.Unmarshal(raw, &part); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		given, family, _ := strings.Cut(user.Name, " ")
+		if path == "name.givenname" {
+			given = part
+		} else {
+			family = part
+		}
+		user.Name = strings.TrimSpace(given + " " + family)
+	case "externalid":
+		if err := json.Unmarshal(raw, &user.ExternalID); err != nil {
+			return errors.New("externalId must be a string")
+		}
+	case "roles", "roles.value":
+		var role string
+		if path == "roles" {
+			var roles []SCIMMultiValue
+			if err := json.Unmarshal(raw, &roles); err != nil {
+				return errors.New("roles must be a list")
+			}
+			role = primaryValue(roles)
+		} else if err := json.Unmarshal(raw, &role); err != nil {
+			return errors.New("role must be a string")
+		}
+		role = strings.ToUpper(role)
+		if !services.IsValidRole(user.TenantID, models.Role(role)) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		user.Role = models.Role(role)
+	default:
+		return fmt.Errorf("unsupported attribute %q", path)
+	}
+	return nil
+}
+
+// saveSCIMUser persists a modified user and applies the side effects of
+// deactivation, role and profile changes.
+func saveSCIMUser(c *gin.Context, before, user models.User) bool {
+	ctx := c.Request.Context()
+	if user.Email != before.Email {
+		if existing, err := repos.Users.GetByEmail(ctx, user.Email); err == nil && existing.ID != user.ID {
+			scimError(c, http.StatusConflict, "uniqueness", "userName is already taken")
+			return false
+		} else if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			scimError(c, http.StatusInternalServerError, "", "Could not update user")
+			return false
+		}
+	}
+
+	var err error
+	if before.Disabled && !user.Disabled {
+		// enabling takes a place of the plan just like creating
+		err = repos.Tenants.Lock(ctx, user.TenantID, func(tx repository.Repositories) error {
+			if err := services.CheckUserQuota(ctx, tx, user.TenantID); err != nil {
+				return err
+			}
+			return tx.Users.Update(ctx, user.TenantID, &user)
+		})
+	} else {
+		err = repos.Users.Update(ctx, user.TenantID, &user)
+	}
+	if err != nil {
+		var quotaErr *services.QuotaError
+		switch {
+		case errors.As(err, &quotaErr):
+			respondSCIMQuotaError(c, err)
+		case errors.Is(err, repository.ErrDuplicate):
+			scimError(c, http.StatusConflict, "uniqueness", "userName is already taken")
+		default:
+			scimError(c, http.StatusInternalServerError, "", "Could not update user")
+		}
+		return false
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUserUpdate, TargetType: services.AuditTargetUser, TargetID: user.ID, Before: before, After: user})
+	if (user.Disabled && !before.Disabled) || user.Role != before.Role {
+		if err := services.RevokeAllUserTokens(user.ID); err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Could not revoke user tokens")
+			return false
+		}
+	}
+	if user.Name != before.Name || user.Role != before.Role || user.Email != before.Email {
+		if err := services.CreateStreamUser(c.Request.Context(), user); err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to update stream user", "provisioned_user_id", user.ID, "error", err)
+		}
+	}
+	return true
+}
+
+func primaryValue(values []SCIMMultiValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+// scimBool accepts JSON booleans as well as the "True"/"False" strings some
+// identity providers send
+func scimBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strconv.ParseBool(strings.ToLower(s))
+	}
+	return false, errors.New("active must be a boolean")
+}
+
+// --- SCIM GROUPS ---
+
+func toSCIMGroup(c *gin.Context, channel models.Channel, members []models.User) SCIMGroup {
+	group := SCIMGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          channel.ID,
+		ExternalID:  channel.ExternalID,
+		DisplayName: channel.Name,
+		Members:     []SCIMMultiValue{},
+		Meta:        &SCIMMeta{ResourceType: "Group", Location: scimLocation(c, "Groups", channel.ID)},
+	}
+	for _, member := range members {
+		group.Members = append(group.Members, SCIMMultiValue{Value: member.ID, Display: member.Name})
+	}
+	return group
+}
+
+func findSCIMGroup(c *gin.Context) (models.Channel, bool) {
+	channel, err := repos.Channels.Get(c.Request.Context(), c.GetString("tenant_id"), c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "Group not found")
+		return channel, false
+	}
+	return channel, true
+}
+
+// ListSCIMGroups lists groups (channels) with optional filtering
+// @Summary SCIM list groups
+// @Tags scim
+// @Produce json
+// @Param filter query string false "SCIM filter, e.g. displayName eq \"Engineering\""
+// @Param startIndex query int false "1-based start index"
+// @Param count query int false "Page size"
+// @Success 200 {object} SCIMListResponse
+// @Router /scim/v2/Groups [get]
+func ListSCIMGroups(c *gin.Context) {
+	tenantID := c.GetString("tenant_id")
+	filter, ok := scimFilter(c, services.SCIMGroupAttributes)
+	if !ok {
+		return
+	}
+	channels, total, err := repos.Channels.Search(c.Request.Context(), tenantID, filter)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not fetch groups")
+		return
+	}
+
+	excludeMembers := strings.Contains(c.Query("excludedAttributes"), "members")
+	resources := make([]interface{}, 0, len(channels))
+	for _, channel := range channels {
+		var members []models.User
+		if !excludeMembers {
+			var err error
+			if members, err = services.GetChannelMembers(c.Request.Context(), repos, channel.ID, tenantID); err != nil {
+				scimError(c, http.StatusInternalServerError, "", "Could not fetch members")
+				return
+			}
+		}
+		resources = append(resources, toSCIMGroup(c, channel, members))
+	}
+	scimJSON(c, http.StatusOK, SCIMListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   filter.Offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// GetSCIMGroup returns a single group with its members
+// @Summary SCIM get group
+// @Tags scim
+// @Produce json
+// @Param id path string true "Group (channel) ID"
+// @Success 200 {object} SCIMGroup
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Groups/{id} [get]
+func GetSCIMGroup(c *gin.Context) {
+	channel, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+	members, err := services.GetChannelMembers(c.Request.Context(), repos, channel.ID, channel.TenantID)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not fetch members")
+		return
+	}
+	scimJSON(c, http.StatusOK, toSCIMGroup(c, channel, members))
+}
+
+// CreateSCIMGroup provisions a group as a channel
+// @Summary SCIM create group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param group body SCIMGroup true "SCIM group"
+// @Success 201 {object} SCIMGroup
+// @Failure 400 {object} map[string]string
+// @Router /scim/v2/Groups [post]
+func CreateSCIMGroup(c *gin.Context) {
+	var req SCIMGroup
+	if err := c.ShouldBindJSON(&req); err != nil || req.DisplayName == "" {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", "displayName is required")
+		return
+	}
+	tenantID := c.GetString("tenant_id")
+
+	creatorID, err := tenantAdminID(c.Request.Context(), tenantID)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "", "The organization has no ADMIN to own provisioned channels")
+		return
+	}
+
+	memberIDs := make([]string, 0, len(req.Members))
+	for _, member := range req.Members {
+		memberIDs = append(memberIDs, member.Value)
+	}
+	channel, err := services.CreateGroupChannel(c.Request.Context(), repos, models.Channel{
+		Name:       req.DisplayName,
+		TenantID:   tenantID,
+		ExternalID: req.ExternalID,
+	}, creatorID, memberIDs)
+	if err != nil {
+		var quotaErr *services.QuotaError
+		if errors.As(err, &quotaErr) {
+			respondSCIMQuotaError(c, err)
+			return
+		}
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	members, err := services.GetChannelMembers(c.Request.Context(), repos, channel.ID, tenantID)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Could not fetch members")
+		return
+	}
+	metrics.ChannelCreated(channel.TenantID)
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelCreate, TargetType: services.AuditTargetChannel, TargetID: channel.ID, After: channel,
+		Changes: map[string]models.AuditChange{"members": {After: memberIDs}}})
+	scimJSON(c, http.StatusCreated, toSCIMGroup(c, channel, members))
+}
+
+// ReplaceSCIMGroup replaces a group's name and members
+// @Summary SCIM replace group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "Group (channel) ID"
+// @Param group body SCIMGroup true "SCIM group"
+// @Success 200 {object} SCIMGroup
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Groups/{id} [put]
+func ReplaceSCIMGroup(c *gin.Context) {
+	channel, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+	var req SCIMGroup
+	if err := c.ShouldBindJSON(&req); err != nil || req.DisplayName == "" {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", "displayName is required")
+		return
+	}
+
+	before := channel
+	if req.DisplayName != channel.Name {
+		if err := services.RenameChannel(c.Request.Context(), repos, channel, req.DisplayName); err != nil {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	}
+	if req.ExternalID != channel.ExternalID {
+		updated := channel
+		updated.Name, updated.ExternalID = req.DisplayName, req.ExternalID
+		if err := repos.Channels.Update(c.Request.Context(), channel.TenantID, &updated); err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Could not update group")
+			return
+		}
+	}
+	memberIDs := make([]string, 0, len(req.Members))
+	for _, member := range req.Members {
+		memberIDs = append(memberIDs, member.Value)
+	}
+	if err := setGroupMembers(c.Request.Context(), channel, memberIDs); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	channel.Name, channel.ExternalID = req.DisplayName, req.ExternalID
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelUpdate, TargetType: services.AuditTargetChannel, TargetID: channel.ID, Before: before, After: channel,
+		Changes: map[string]models.AuditChange{"members": {After: memberIDs}}})
+	GetSCIMGroup(c)
+}
+
+// PatchSCIMGroup applies SCIM PATCH operations to a group
+// @Summary SCIM patch group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "Group (channel) ID"
+// @Param patch body SCIMPatchRequest true "Patch operations"
+// @Success 200 {object} SCIMGroup
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Groups/{id} [patch]
+func PatchSCIMGroup(c *gin.Context) {
+	channel, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+	var req SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", InvalidRequestMessage)
+		return
+	}
+
+	before := channel
+	for _, op := range req.Operations {
+		if err := patchSCIMGroup(c.Request.Context(), &channel, op); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelUpdate, TargetType: services.AuditTargetChannel, TargetID: channel.ID, Before: before, After: channel,
+		Changes: map[string]models.AuditChange{"operations": {After: req.Operations}}})
+	GetSCIMGroup(c)
+}
+
+// DeleteSCIMGroup deletes a group and its channel
+// @Summary SCIM delete group
+// @Tags scim
+// @Param id path string true "Group (channel) ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Router /scim/v2/Groups/{id} [delete]
+func DeleteSCIMGroup(c *gin.Context) {
+	channel, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+	if err := services.DeleteChannel(c.Request.Context(), repos, channel.ID, channel.TenantID); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditChannelDelete, TargetType: services.AuditTargetChannel, TargetID: channel.ID, Before: channel})
+	c.Status(http.StatusNoContent)
+}
+
+func patchSCIMGroup(ctx context.Context, channel *models.Channel, op SCIMPatchOperation) error {
+	path := op.Path
+	switch strings.ToLower(op.Op) {
+	case "add":
+		if !strings.EqualFold(path, "members") {
+			return replaceSCIMGroupAttributes(ctx, channel, path, op.Value)
+		}
+		members, err := scimMemberIDs(op.Value)
+		if err != nil {
+			return err
+		}
+		for _, userID := range members {
+			if err := services.AddUserToChannel(ctx, repos, channel.ID, userID, channel.TenantID); err != nil && !errors.Is(err, services.ErrAlreadyMember) {
+				return err
+			}
+		}
+		return nil
+	case "remove":
+		var members []string
+		if m := scimMemberPathPattern.FindStringSubmatch(path); m != nil {
+			members = []string{m[1]}
+		} else if strings.EqualFold(path, "members") {
+			if len(op.Value) > 0 {
+				var err error
+				if members, err = scimMemberIDs(op.Value); err != nil {
+					return err
+				}
+			} else {
+				return setGroupMembers(ctx, *channel, nil)
+			}
+		} else {
+			return fmt.Errorf("attribute %q cannot be removed", path)
+		}
+		for _, userID := range members {
+			if err := services.RemoveUserFromChannel(ctx, repos, channel.ID, userID, channel.TenantID); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "replace":
+		if strings.EqualFold(path, "members") {
+			members, err := scimMemberIDs(op.Value)
+			if err != nil {
+				return err
+			}
+			return setGroupMembers(ctx, *channel, members)
+		}
+		return replaceSCIMGroupAttributes(ctx, channel, path, op.Value)
+	}
+	return fmt.Errorf("unsupported patch op %q", op.Op)
+}
+
+func replaceSCIMGroupAttributes(ctx context.Context, channel *models.Channel, path string, raw json.RawMessage) error {
+	attrs := map[string]json.RawMessage{}
+	if path == "" {
+		if err := json.Unmarshal(raw, &attrs); err != nil {
+			return errors.New("value must be an object when no path is given")
+		}
+	} else {
+		attrs[path] = raw
+	}
+
+	for key, value := range attrs {
+		switch strings.ToLower(key) {
+		case "displayname":
+			var name string
+			if err := json.Unmarshal(value, &name); err != nil || name == "" {
+				return errors.New("displayName must be a non-empty string")
+			}
+			if err := services.RenameChannel(ctx, repos, *channel, name); err != nil {
+				return err
+			}
+			channel.Name = name
+		case "externalid":
+			var externalID string
+			if err := json.Unmarshal(value, &externalID); err != nil {
+				return errors.New("externalId must be a string")
+			}
+			updated := *channel
+			updated.ExternalID = externalID
+			if err := repos.Channels.Update(ctx, channel.TenantID, &updated); err != nil {
+				return err
+			}
+			channel.ExternalID = externalID
+		case "members":
+			members, err := scimMemberIDs(value)
+			if err != nil {
+				return err
+			}
+			if err := setGroupMembers(ctx, *channel, members); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported attribute %q", key)
+		}
+	}
+	return nil
+}
+
+// setGroupMembers makes the channel's members exactly userIDs
+func setGroupMembers(ctx context.Context, channel models.Channel, userIDs []string) error {
+	current, err := services.GetChannelMembers(ctx, repos, channel.ID, channel.TenantID)
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
+	for _, member := range current {
+		if wanted[member.ID] {
+			delete(wanted, member.ID)
+			continue
+		}
+		if err := services.RemoveUserFromChannel(ctx, repos, channel.ID, member.ID, channel.TenantID); err != nil {
+			return err
+		}
+	}
+	for id := range wanted {
+		if err := services.AddUserToChannel(ctx, repos, channel.ID, id, channel.TenantID); err != nil && !errors.Is(err, services.ErrAlreadyMember) {
+			return err
+		}
+	}
+	return nil
+}
+
+func scimMemberIDs(raw json.RawMessage) ([]string, error) {
+	var members []SCIMMultiValue
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return nil, errors.New("members must be a list of {\"value\": \"<user id>\"}")
+	}
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.Value)
+	}
+	return ids, nil
+}
+
+// tenantAdminID returns an ADMIN of the tenant to act as creator of
+// provisioned channels
+func tenantAdminID(ctx context.Context, tenantID string) (string, error) {
+	admins, err := repos.Users.ListByRole(ctx, tenantID, models.RoleAdmin)
+	if err != nil {
+		return "", err
+	}
+	for _, admin := range admins {
+		if !admin.Disabled {
+			return admin.ID, nil
+		}
+	}
+	return "", repository.ErrNotFound
+}
+
+// respondSCIMQuotaError reports plan limits (see respondQuotaError) as SCIM errors
+func respondSCIMQuotaError(c *gin.Context, err error) {
+	var quotaErr *services.QuotaError
+	if !errors.As(err, &quotaErr) {
+		scimError(c, http.StatusInternalServerError, "", "Could not check plan limits")
+		return
+	}
+	scimError(c, quotaStatus(quotaErr), "", quotaErr.Error())
+}
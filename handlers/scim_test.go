@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	stream "github.com/GetStream/stream-chat-go/v5"
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/middleware"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// scimTest is the SCIM API as cmd/main.go mounts it, on a mock database and a
+// fake Stream app
+type scimTest struct {
+	mock   sqlmock.Sqlmock
+	router *gin.Engine
+	// tenantID is the tenant of the token "token-1"
+	tenantID string
+
+	mu sync.Mutex
+	// stream holds the method and path of every Stream call
+	stream []string
+}
+
+func newSCIMTest(t *testing.T) *scimTest {
+	s := &scimTest{mock: testutil.SetupMockDB(t), tenantID: uuid.New().String()}
+	SetRepositories(repository.NewGORM(db.DB))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.stream = append(s.stream, r.Method+" "+r.URL.Path)
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	client, err := stream.NewClient("key", "secret")
+	assert.NoError(t, err)
+	client.BaseURL = server.URL
+	services.SetStreamClient(client)
+
+	gin.SetMode(gin.TestMode)
+	s.router = gin.New()
+	scim := s.router.Group("/scim/v2", middleware.SCIMAuth(repos))
+	scim.POST("/Users", CreateSCIMUser)
+	scim.GET("/Users/:id", GetSCIMUser)
+	scim.PATCH("/Users/:id", PatchSCIMUser)
+	scim.DELETE("/Users/:id", DeleteSCIMUser)
+	scim.POST("/Groups", CreateSCIMGroup)
+	scim.GET("/Groups/:id", GetSCIMGroup)
+	scim.PUT("/Groups/:id", ReplaceSCIMGroup)
+	scim.PATCH("/Groups/:id", PatchSCIMGroup)
+	scim.DELETE("/Groups/:id", DeleteSCIMGroup)
+	return s
+}
+
+// expectToken expects SCIMAuth to find the SCIM token raw as a token of
+// tenantID; it comes before the expectations of the handler
+func (s *scimTest) expectToken(raw, tenantID string) {
+	s.mock.ExpectQuery(`SELECT \* FROM "scim_tokens" WHERE token_hash = \$1`).
+		WithArgs(utils.HashToken(raw), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id"}).AddRow("scim-"+raw, tenantID))
+	s.mock.ExpectQuery(`SELECT "id","suspended" FROM "tenants"`).
+		WithArgs(tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "suspended"}).AddRow(tenantID, false))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "scim_tokens" SET "last_used_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+}
+
+// do sends a request with the SCIM token raw
+func (s *scimTest) do(raw, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+raw)
+	req.Header.Set("Content-Type", "application/scim+json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// expectAudit expects one entry to be appended to the tenant's audit log
+func (s *scimTest) expectAudit() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`INSERT INTO "audit_chain_heads"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectQuery(`SELECT \* FROM "audit_chain_heads" WHERE tenant_id = \$1 .* FOR UPDATE`).
+		WithArgs(s.tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "seq", "hash"}).AddRow(s.tenantID, 0, ""))
+	s.mock.ExpectExec(`INSERT INTO "audit_logs"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec(`UPDATE "audit_chain_heads"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+}
+
+// expectLockedCount expects the tenant to be locked and its users or
+// channels to be counted against a plan allowing 10 of each
+func (s *scimTest) expectLockedCount(table string, used int) {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(`SELECT "id" FROM "tenants" WHERE id = \$1 .* FOR NO KEY UPDATE`).
+		WithArgs(s.tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(s.tenantID))
+	s.expectPlan()
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "` + table + `" WHERE tenant_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(used))
+}
+
+func (s *scimTest) expectPlan() {
+	s.mock.ExpectQuery(`SELECT "id","plan_id" FROM "tenants"`).
+		WithArgs(s.tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "plan_id"}).AddRow(s.tenantID, "free"))
+	s.mock.ExpectQuery(`SELECT \* FROM "plans"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "max_users", "max_channels", "max_members_per_channel"}).AddRow("free", 10, 10, 10))
+}
+
+// userRows is alice, a user of the tenant
+func (s *scimTest) userRows(disabled bool) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "email", "name", "role", "tenant_id", "disabled", "email_verified"}).
+		AddRow(testutil.UserOne, "alice@acme.test", "Alice", "MEMBER", s.tenantID, disabled, true)
+}
+
+func (s *scimTest) streamCalls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.stream...)
+}
+
+func TestSCIMUserLifecycle(t *testing.T) {
+	s := newSCIMTest(t)
+	const findUser = `SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`
+
+	// create
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(`SELECT \* FROM "tenant_settings"`).WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}))
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE email = \$1`).
+		WithArgs("alice@acme.test", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.expectLockedCount("users", 3)
+	s.mock.ExpectExec(`INSERT INTO "users"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit()
+	w := s.do("token-1", http.MethodPost, "/scim/v2/Users",
+		`{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"Alice@Acme.test","displayName":"Alice"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"userName":"alice@acme.test"`)
+	assert.Contains(t, w.Body.String(), `"active":true`)
+	assert.Equal(t, []string{http.MethodPost + " /users"}, s.streamCalls())
+
+	// deactivating signs the user out
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(findUser).
+		WithArgs(s.tenantID, testutil.UserOne, 1).
+		WillReturnRows(s.userRows(false))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "users" SET .*"disabled"=\$\d+`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "users" SET "token_version"=token_version \+ 1`).WithArgs(testutil.UserOne).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	// GetSCIMUser reads the user back
+	s.mock.ExpectQuery(findUser).
+		WithArgs(s.tenantID, testutil.UserOne, 1).
+		WillReturnRows(s.userRows(true))
+	s.mock.ExpectQuery(`SELECT "channels"."id".* FROM "channels" JOIN channel_members`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	w = s.do("token-1", http.MethodPatch, "/scim/v2/Users/"+testutil.UserOne,
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"active","value":false}]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"active":false`)
+
+	// delete
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(findUser).
+		WithArgs(s.tenantID, testutil.UserOne, 1).
+		WillReturnRows(s.userRows(true))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "users" SET "token_version"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`DELETE FROM "channel_members" WHERE user_id = \$1 AND tenant_id = \$2`).
+		WithArgs(testutil.UserOne, s.tenantID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectExec(`DELETE FROM "users" WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testutil.UserOne, s.tenantID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit()
+	w = s.do("token-1", http.MethodDelete, "/scim/v2/Users/"+testutil.UserOne, "")
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestSCIMRemovingRolesGivesTheDefaultRole(t *testing.T) {
+	s := newSCIMTest(t)
+	const findUser = `SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`
+
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(findUser).WithArgs(s.tenantID, testutil.UserOne, 1).WillReturnRows(s.userRows(false))
+	s.mock.ExpectQuery(`SELECT \* FROM "tenant_settings"`).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "default_role"}).AddRow(s.tenantID, "GUEST"))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "users" SET .*"role"=\$\d+`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "users" SET "token_version"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(findUser).WithArgs(s.tenantID, testutil.UserOne, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role", "tenant_id"}).AddRow(testutil.UserOne, "alice@acme.test", "GUEST", s.tenantID))
+	s.mock.ExpectQuery(`SELECT "channels"."id".* FROM "channels" JOIN channel_members`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	w := s.do("token-1", http.MethodPatch, "/scim/v2/Users/"+testutil.UserOne, `{"Operations":[{"op":"remove","path":"roles"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"roles":[{"value":"GUEST","primary":true}]`)
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestSCIMReportsDatabaseErrors(t *testing.T) {
+	s := newSCIMTest(t)
+
+	// a failed uniqueness check does not let the user through
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(`SELECT \* FROM "tenant_settings"`).WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}))
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE email = \$1`).WillReturnError(errors.New("connection reset"))
+	w := s.do("token-1", http.MethodPost, "/scim/v2/Users", `{"userName":"alice@acme.test"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`).
+		WithArgs(s.tenantID, testutil.UserOne, 1).
+		WillReturnRows(s.userRows(false))
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE email = \$1`).WillReturnError(errors.New("connection reset"))
+	w = s.do("token-1", http.MethodPatch, "/scim/v2/Users/"+testutil.UserOne,
+		`{"Operations":[{"op":"replace","path":"userName","value":"bob@acme.test"}]}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+	// nor does a failed update of the group's externalId report success
+	s.expectToken("token-1", s.tenantID)
+	s.expectChannel()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "channels" SET .*"external_id"=\$\d+`).WillReturnError(errors.New("connection reset"))
+	s.mock.ExpectRollback()
+	w = s.do("token-1", http.MethodPut, "/scim/v2/Groups/"+testutil.ChannelOne, `{"displayName":"Engineering","externalId":"eng"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	assert.Empty(t, s.streamCalls())
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestSCIMReactivationCountsAgainstThePlan(t *testing.T) {
+	s := newSCIMTest(t)
+	const findUser = `SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`
+	const activate = `{"Operations":[{"op":"replace","path":"active","value":true}]}`
+
+	// users created inactive cannot be switched on past the plan
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(findUser).WithArgs(s.tenantID, testutil.UserOne, 1).WillReturnRows(s.userRows(true))
+	s.expectLockedCount("users", 10)
+	s.mock.ExpectRollback()
+	w := s.do("token-1", http.MethodPatch, "/scim/v2/Users/"+testutil.UserOne, activate)
+	assert.Equal(t, http.StatusPaymentRequired, w.Code, w.Body.String())
+
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(findUser).WithArgs(s.tenantID, testutil.UserOne, 1).WillReturnRows(s.userRows(true))
+	s.expectLockedCount("users", 9)
+	s.mock.ExpectExec(`UPDATE "users" SET .*"disabled"=\$\d+.* WHERE tenant_id = \$\d+ AND "id" = \$\d+`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit()
+	s.mock.ExpectQuery(findUser).WithArgs(s.tenantID, testutil.UserOne, 1).WillReturnRows(s.userRows(false))
+	s.mock.ExpectQuery(`SELECT "channels"."id".* FROM "channels" JOIN channel_members`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	w = s.do("token-1", http.MethodPatch, "/scim/v2/Users/"+testutil.UserOne, activate)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+// expectChannel expects the group to be looked up in the tenant
+func (s *scimTest) expectChannel() {
+	s.mock.ExpectQuery(`SELECT \* FROM "channels" WHERE tenant_id = \$1 AND id = \$2`).
+		WithArgs(s.tenantID, testutil.ChannelOne, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "stream_id", "name", "tenant_id"}).AddRow(testutil.ChannelOne, "stream-123", "Engineering", s.tenantID))
+}
+
+func TestSCIMGroupLifecycle(t *testing.T) {
+	s := newSCIMTest(t)
+	members := func(ids ...string) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"id", "tenant_id"})
+		for _, id := range ids {
+			rows.AddRow(id, s.tenantID)
+		}
+		return rows
+	}
+
+	// create, owned by an admin of the tenant
+	s.expectToken("token-1", s.tenantID)
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND role = \$2 ORDER BY email`).
+		WithArgs(s.tenantID, "ADMIN").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "disabled"}).AddRow("admin-0", true).AddRow("admin-1", false))
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`).
+		WithArgs(s.tenantID, testutil.UserOne, 1).
+		WillReturnRows(s.userRows(false))
+	s.expectLockedCount("channels", 3)
+	s.expectPlan()
+	s.mock.ExpectExec(`SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(`INSERT INTO "channels"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec(`INSERT INTO "channel_members"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(`SELECT .* FROM "users" JOIN channel_members`).WillReturnRows(members(testutil.UserOne))
+	s.expectAudit()
+	w := s.do("token-1", http.MethodPost, "/scim/v2/Groups",
+		`{"schemas":["urn:ietf:params:scim:schemas:core:2.0:Group"],"displayName":"Engineering","members":[{"value":"`+testutil.UserOne+`"}]}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"members":[{"value":"`+testutil.UserOne+`"}]`)
+	if calls := s.streamCalls(); assert.Len(t, calls, 1) {
+		assert.Regexp(t, `^POST /channels/messaging/.+/query$`, calls[0])
+	}
+
+	// add a member
+	s.expectToken("token-1", s.tenantID)
+	s.expectChannel()
+	s.expectChannel()
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`).
+		WithArgs(s.tenantID, "user-2", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email_verified"}).AddRow("user-2", s.tenantID, true))
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "channel_members" WHERE tenant_id = \$1 AND \(channel_id = \$2 AND user_id = \$3\)`).
+		WithArgs(s.tenantID, testutil.ChannelOne, "user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.expectLockedCount("channel_members", 1)
+	s.mock.ExpectExec(`INSERT INTO "channel_members"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit()
+	s.expectChannel()
+	s.mock.ExpectQuery(`SELECT .* FROM "users" JOIN channel_members`).WillReturnRows(members(testutil.UserOne, "user-2"))
+	w = s.do("token-1", http.MethodPatch, "/scim/v2/Groups/"+testutil.ChannelOne,
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"add","path":"members","value":[{"value":"user-2"}]}]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `{"value":"user-2"}`)
+	assert.Equal(t, http.MethodPost+" /channels/messaging/stream-123", s.streamCalls()[1])
+
+	// delete
+	s.expectToken("token-1", s.tenantID)
+	s.expectChannel()
+	s.expectChannel()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`DELETE FROM "channel_members" WHERE channel_id = \$1 AND tenant_id = \$2`).
+		WithArgs(testutil.ChannelOne, s.tenantID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectExec(`DELETE FROM "channels" WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testutil.ChannelOne, s.tenantID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit()
+	w = s.do("token-1", http.MethodDelete, "/scim/v2/Groups/"+testutil.ChannelOne, "")
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Equal(t, http.MethodDelete+" /channels/messaging/stream-123", s.streamCalls()[2])
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestSCIMTokensStayInTenant(t *testing.T) {
+	s := newSCIMTest(t)
+	// alice and the group belong to s.tenantID; token-2 is another tenant's
+	other := uuid.New().String()
+	notFound := func(query string, args ...driver.Value) {
+		s.expectToken("token-2", other)
+		s.mock.ExpectQuery(query).WithArgs(args...).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	const findUser = `SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`
+	const findGroup = `SELECT \* FROM "channels" WHERE tenant_id = \$1 AND id = \$2`
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		notFound(findUser, other, testutil.UserOne, 1)
+		w := s.do("token-2", method, "/scim/v2/Users/"+testutil.UserOne,
+			`{"Operations":[{"op":"replace","path":"active","value":false}]}`)
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+
+		notFound(findGroup, other, testutil.ChannelOne, 1)
+		w = s.do("token-2", method, "/scim/v2/Groups/"+testutil.ChannelOne,
+			`{"Operations":[{"op":"add","path":"members","value":[{"value":"user-2"}]}]}`)
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+
+	// a group of the other tenant cannot take alice in either
+	s.expectToken("token-2", other)
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND role = \$2 ORDER BY email`).
+		WithArgs(other, "ADMIN").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "disabled"}).AddRow("admin-2", false))
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`).
+		WithArgs(other, testutil.UserOne, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	w := s.do("token-2", http.MethodPost, "/scim/v2/Groups",
+		`{"displayName":"Poached","members":[{"value":"`+testutil.UserOne+`"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Empty(t, s.streamCalls())
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TenantSettingsRequest changes the given settings. When version is set and
+// the settings were changed since that version, the request fails with 409.
+type TenantSettingsRequest struct {
+	services.TenantSettingsPatch
+	Version *int `json:"version"`
+}
+
+// GetTenantSettings returns the tenant's settings
+// @Summary Get tenant settings
+// @Description Returns the tenant's settings and their version
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} models.TenantSettings
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/settings [get]
+func GetTenantSettings(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	settings, err := services.GetTenantSettings(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load settings"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateTenantSettings changes the tenant's settings
+// @Summary Update tenant settings
+// @Description Changes the given settings: default role for new users, registration policy, message length limit, message retention, allowed attachment types and guest access. Pass the version last read to detect concurrent changes.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param settings body TenantSettingsRequest true "Settings to change"
+// @Success 200 {object} models.TenantSettings
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tenants/{id}/settings [patch]
+func UpdateTenantSettings(c *gin.Context) {
+	tenantID := c.Param("id")
+	if !requireOwnTenant(c, tenantID) {
+		return
+	}
+	var req TenantSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	// choosing the role every new user gets is a role assignment
+	if req.DefaultRole != nil {
+		if !requireRoleAssignment(c, tenantID, *req.DefaultRole) {
+			return
+		}
+	}
+
+	settings, before, err := services.UpdateTenantSettings(tenantID, req.TenantSettingsPatch, req.Version, c.GetString("user_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidSettings):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrSettingsConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			slog.ErrorContext(c.Request.Context(), "failed to update tenant settings", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update settings"})
+		}
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditTenantSettings, TargetType: services.AuditTargetTenant, TargetID: tenantID, Before: before, After: settings})
+	c.JSON(http.StatusOK, settings)
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
-	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"net/http"
 
 	stream_chat "github.com/GetStream/stream-chat-go/v5"
-	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/metrics"
 	"github.com/Nyagar-Abraham/chat-app/models"
 	"github.com/Nyagar-Abraham/chat-app/services"
 	"github.com/gin-gonic/gin"
@@ -35,19 +39,22 @@ func StreamToken(c *gin.Context) {
 
 // SendMessageRequest is the payload for sending a message
 // @Summary Send a message to a Stream channel
-// @Description Sends a message to a Stream channel as the authenticated user
+// @Description Sends a message to a Stream channel as the authenticated user, within the tenant's message length limit and allowed attachment types
 // @Tags stream
 // @Accept json
 // @Produce json
 // @Param message body SendMessageRequest true "Message info"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 402 {object} map[string]string
+// @Failure 429 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Security ApiKeyAuth
 // @Router /messages [post]
 type SendMessageRequest struct {
-	StreamID string `json:"stream_id" binding:"required"` // stream channel id
-	Text     string `json:"text" binding:"required"`
+	StreamID    string                    `json:"stream_id" binding:"required"` // stream channel id
+	Text        string                    `json:"text" binding:"required"`
+	Attachments []*stream_chat.Attachment `json:"attachments"`
 }
 
 func SendMessage(c *gin.Context) {
@@ -60,34 +67,76 @@ func SendMessage(c *gin.Context) {
 	userID := c.GetString("user_id")
 	tenantID := c.GetString("tenant_id")
 
-	var channel models.Channel
-	if err := db.DB.Where("stream_id = ? AND tenant_id = ?", req.StreamID, tenantID).First(&channel).Error; err != nil {
+	settings, err := services.GetTenantSettings(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load tenant settings"})
+		return
+	}
+	if utf8.RuneCountInString(req.Text) > settings.MessageMaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Message is longer than %d characters", settings.MessageMaxLength)})
+		return
+	}
+	for _, attachment := range req.Attachments {
+		if attachment == nil || !services.AttachmentAllowed(settings, attachment.Type) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Attachment type not allowed", "allowed_attachment_types": settings.AllowedAttachmentTypes})
+			return
+		}
+	}
+	if err := services.CheckAttachmentSizes(req.Attachments); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel, err := repos.Channels.GetByStreamID(c.Request.Context(), tenantID, req.StreamID)
+	if err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Channel not found or access denied"})
 		return
 	}
 
-	if !services.IsUserChannelMember(channel.ID, userID, tenantID) {
+	if member, err := repos.Memberships.IsMember(c.Request.Context(), tenantID, channel.ID, userID); err != nil || !member {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You must be a member of this channel to send messages"})
 		return
 	}
 
+	size := services.MessageSize(req.Text, req.Attachments)
+	if err := services.ReserveMessage(tenantID, size); err != nil {
+		if !respondQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check plan limits"})
+		}
+		return
+	}
+
 	client := services.GetStreamClient()
 	streamChannel := client.Channel("messaging", req.StreamID)
 	msg := &stream_chat.Message{
-		Text: req.Text,
-		User: &stream_chat.User{ID: userID},
+		Text:        req.Text,
+		Attachments: req.Attachments,
+		User:        &stream_chat.User{ID: userID},
 	}
-	_, err := streamChannel.SendMessage(context.Background(), msg, userID)
+	start := time.Now()
+	sent, err := streamChannel.SendMessage(c.Request.Context(), msg, userID)
+	metrics.ObserveStreamCall(metrics.StreamSendMessage, start, err)
 	if err != nil {
+		if err := services.ReleaseMessage(tenantID, size); err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to release message quota", "error", err)
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message: " + err.Error()})
 		return
 	}
+	metrics.MessageSent(tenantID)
+	// the text stays in Stream; the audit log only records that a message was sent
+	messageID := ""
+	if sent.Message != nil {
+		messageID = sent.Message.ID
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditMessageSend, TargetType: services.AuditTargetMessage, TargetID: messageID,
+		After: gin.H{"channel_id": channel.ID, "attachments": len(req.Attachments)}})
 	c.JSON(http.StatusOK, gin.H{"status": "Message sent"})
 }
 
 // GetMessages fetches messages from a Stream channel
 // @Summary Get messages from a Stream channel
-// @Description Retrieves the most recent messages from a Stream channel
+// @Description Retrieves the most recent messages from a Stream channel; messages older than the tenant's retention period are left out
 // @Tags stream
 // @Produce json
 // @Param stream_id path string true "Stream channel ID"
@@ -101,25 +150,95 @@ func GetMessages(c *gin.Context) {
 	userID := c.GetString("user_id")
 	tenantID := c.GetString("tenant_id")
 
-	var channel models.Channel
-	if err := db.DB.Where("stream_id = ? AND tenant_id = ?", streamID, tenantID).First(&channel).Error; err != nil {
+	channel, err := repos.Channels.GetByStreamID(c.Request.Context(), tenantID, streamID)
+	if err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Channel not found or access denied"})
 		return
 	}
 
-	if !services.IsUserChannelMember(channel.ID, userID, tenantID) {
+	if member, err := repos.Memberships.IsMember(c.Request.Context(), tenantID, channel.ID, userID); err != nil || !member {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You must be a member of this channel to view messages"})
 		return
 	}
 
 	client := services.GetStreamClient()
 	streamChannel := client.Channel("messaging", streamID)
-	resp, err := streamChannel.Query(context.Background(), nil)
+	start := time.Now()
+	resp, err := streamChannel.Query(c.Request.Context(), nil)
+	metrics.ObserveStreamCall(metrics.StreamQueryChannel, start, err)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages: " + err.Error()})
 		return
 	}
+	settings, err := services.GetTenantSettings(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load tenant settings"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"messages": resp.Messages,
+		"messages": services.WithinRetention(resp.Messages, settings.RetentionDays, time.Now()),
 	})
 }
+
+// DeleteMessage deletes a message. Users may delete their own messages;
+// deleting anyone else's requires the message.delete.any permission.
+// @Summary Delete a message
+// @Description Deletes a message from a Stream channel of the tenant
+// @Tags stream
+// @Param message_id path string true "Stream message ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /messages/{message_id} [delete]
+func DeleteMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	tenantID := c.GetString("tenant_id")
+
+	client := services.GetStreamClient()
+	start := time.Now()
+	resp, err := client.GetMessage(c.Request.Context(), c.Param("message_id"))
+	metrics.ObserveStreamCall(metrics.StreamGetMessage, start, err)
+	if err != nil || resp.Message == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	// messages of other tenants' channels are reported as not found
+	streamID := strings.TrimPrefix(resp.Message.CID, "messaging:")
+	channel, err := repos.Channels.GetByStreamID(c.Request.Context(), tenantID, streamID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	if resp.Message.User == nil || resp.Message.User.ID != userID {
+		allowed, err := services.HasPermission(tenantID, c.GetString("user_role"), models.PermMessageDeleteAny)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check permissions"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "permission": models.PermMessageDeleteAny})
+			return
+		}
+	}
+
+	start = time.Now()
+	_, err = client.DeleteMessage(c.Request.Context(), resp.Message.ID)
+	metrics.ObserveStreamCall(metrics.StreamDeleteMessage, start, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message: " + err.Error()})
+		return
+	}
+	if err := services.ReleaseStorage(tenantID, services.MessageSize(resp.Message.Text, resp.Message.Attachments)); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to release storage", "error", err)
+	}
+	authorID := ""
+	if resp.Message.User != nil {
+		authorID = resp.Message.User.ID
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditMessageDelete, TargetType: services.AuditTargetMessage, TargetID: resp.Message.ID,
+		Before: gin.H{"channel_id": channel.ID, "author_id": authorID}})
+	c.JSON(http.StatusOK, gin.H{"status": "Message deleted"})
+}
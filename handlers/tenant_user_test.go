@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// tenantRouter serves the handlers as a member of tenantID
+func tenantRouter(tenantID string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("tenant_id", tenantID)
+	})
+	router.GET("/users", ListUsers)
+	router.GET("/tenants", ListTenants)
+	router.GET("/tenants/:id", GetTenant)
+	return router
+}
+
+func TestTenantHandlersStayInTenant(t *testing.T) {
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	SetRepositories(repos)
+	one, two := models.Tenant{Name: "One"}, models.Tenant{Name: "Two"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &one))
+	assert.NoError(t, repos.Tenants.Create(ctx, &two))
+	assert.NoError(t, repos.Users.Create(ctx, one.ID, &models.User{Email: "alice@one.test"}))
+	assert.NoError(t, repos.Users.Create(ctx, two.ID, &models.User{Email: "bob@two.test"}))
+	router := tenantRouter(one.ID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var users []models.User
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "alice@one.test", users[0].Email)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tenants", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"id":"`+one.ID+`","name":"One"}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tenants/"+two.ID, nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// defaultPolicyRows are the platform default permissions as stored
+func defaultPolicyRows() *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"tenant_id", "role", "permission", "allowed"})
+	for role, permissions := range services.DefaultRolePermissions {
+		for _, p := range permissions {
+			rows.AddRow("", string(role), string(p), true)
+		}
+	}
+	return rows
+}
+
+func TestUpdateUserEmailNeedsTheUsersPermissions(t *testing.T) {
+	ctx := context.Background()
+	mock := testutil.SetupMockDB(t)
+	repos := repository.NewMemory()
+	SetRepositories(repos)
+	tenant := models.Tenant{Name: "One"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &tenant))
+	admin := models.User{Email: "admin@one.test", Role: models.RoleAdmin}
+	member := models.User{Email: "member@one.test", Role: models.RoleMember}
+	assert.NoError(t, repos.Users.Create(ctx, tenant.ID, &admin))
+	assert.NoError(t, repos.Users.Create(ctx, tenant.ID, &member))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("tenant_id", tenant.ID)
+		c.Set("user_id", "moderator")
+		c.Set("user_role", string(models.RoleModerator))
+	})
+	router.PUT("/users/:id", UpdateUser)
+	update := func(userID, email string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users/"+userID, strings.NewReader(`{"email":"`+email+`"}`)))
+		return w
+	}
+
+	// pointing an ADMIN's address at your own mailbox would take the account over
+	mock.ExpectQuery(`SELECT \* FROM "role_permissions"`).WillReturnRows(defaultPolicyRows())
+	assert.Equal(t, http.StatusForbidden, update(admin.ID, "moderator@one.test").Code)
+	stored, err := repos.Users.Get(ctx, tenant.ID, admin.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@one.test", stored.Email)
+
+	assert.Equal(t, http.StatusConflict, update(member.ID, "admin@one.test").Code)
+
+	// the new address is unverified and the old sessions end
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "email_verification_tokens"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "email_verification_tokens"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET "token_version"=token_version \+ 1`).WithArgs(member.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.Equal(t, http.StatusOK, update(member.ID, "new@one.test").Code)
+	stored, err = repos.Users.Get(ctx, tenant.ID, member.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "new@one.test", stored.Email)
+	assert.False(t, stored.EmailVerified)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCustomRolesCannotAssignMoreThanTheyHold(t *testing.T) {
+	ctx := context.Background()
+	mock := testutil.SetupMockDB(t)
+	repos := repository.NewMemory()
+	SetRepositories(repos)
+	tenant := models.Tenant{Name: "One"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &tenant))
+	guest := models.User{Email: "guest@one.test", Role: models.RoleGuest}
+	assert.NoError(t, repos.Users.Create(ctx, tenant.ID, &guest))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("tenant_id", tenant.ID)
+		c.Set("user_id", "lead")
+		c.Set("user_role", "SUPPORT_LEAD")
+	})
+	router.PUT("/users/:id", UpdateUser)
+	assign := func(role models.Role) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users/"+guest.ID, strings.NewReader(`{"role":"`+string(role)+`"}`)))
+		return w.Code
+	}
+
+	rows := defaultPolicyRows()
+	for _, p := range []models.Permission{models.PermUserUpdate, models.PermUserUpdateRole, models.PermChannelJoin, models.PermMessageSend} {
+		rows.AddRow(tenant.ID, "SUPPORT_LEAD", string(p), true)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "role_permissions"`).WillReturnRows(rows)
+	assert.Equal(t, http.StatusForbidden, assign(models.RoleAdmin))
+	assert.Equal(t, http.StatusForbidden, assign(models.RoleModerator))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET "token_version"`).WithArgs(guest.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	assert.Equal(t, http.StatusOK, assign(models.RoleMember))
+	stored, err := repos.Users.Get(ctx, tenant.ID, guest.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleMember, stored.Role)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
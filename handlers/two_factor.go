@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type LoginSecondFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginSecondFactor completes a login that requires 2FA
+// @Summary Complete 2FA login
+// @Description Exchanges the challenge token from POST /auth/login and a TOTP or recovery code for an access and refresh token. Wrong codes count as failed logins.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body LoginSecondFactorRequest true "Challenge token and code"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/login/2fa [post]
+func LoginSecondFactor(c *gin.Context) {
+	var request LoginSecondFactorRequest
+	if err := c.ShouldBindJSON(&request); err != nil || (request.Code == "" && request.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+
+	claims, err := utils.ParseToken(request.ChallengeToken)
+	if err != nil || claims.TokenUse != utils.TokenUseMFAChallenge || services.IsTokenRevoked(claims) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+
+	user, err := repos.Users.Get(c.Request.Context(), claims.TenantID, claims.UserID)
+	if err != nil || user.Disabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+	if err := services.CheckTenantActive(user.TenantID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Organization is suspended"})
+		return
+	}
+
+	if err := services.CheckLoginAllowed(user.Email, c.ClientIP()); err != nil {
+		respondLoginThrottled(c, err)
+		return
+	}
+	if err := services.VerifySecondFactor(user, request.Code, request.RecoveryCode); err != nil {
+		if errors.Is(err, services.ErrInvalidTOTPCode) || errors.Is(err, services.ErrTOTPNotEnrolled) {
+			recordLoginFailure(c, user.Email, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidTOTPCode.Error()})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to verify second factor", "login_user_id", user.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify code"})
+		return
+	}
+
+	// challenge tokens are single use
+	if err := services.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+
+	recordLoginSuccess(user)
+
+	token, refreshToken, err := issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{TenantID: user.TenantID, ActorID: user.ID, Action: services.AuditAuthLogin, TargetType: services.AuditTargetUser, TargetID: user.ID})
+	c.JSON(http.StatusOK, LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+		Message:      user.Name,
+	})
+}
+
+// EnrollTOTP starts TOTP enrollment for the current user
+// @Summary Start 2FA enrollment
+// @Description Generates a TOTP secret and otpauth:// provisioning URI (to render as QR code). 2FA is enabled once confirmed.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} TOTPEnrollResponse
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /auth/2fa/enroll [post]
+func EnrollTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := services.StartTOTPEnrollment(user)
+	if err != nil {
+		if errors.Is(err, services.ErrTOTPAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrollment"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUser2FAEnroll, TargetType: services.AuditTargetUser, TargetID: user.ID})
+	c.JSON(http.StatusOK, TOTPEnrollResponse{Secret: secret, ProvisioningURI: uri})
+}
+
+// ConfirmTOTP confirms TOTP enrollment with a first code
+// @Summary Confirm 2FA enrollment
+// @Description Enables 2FA after verifying a code from the authenticator app and returns one-time recovery codes
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body TOTPCodeRequest true "TOTP code"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /auth/2fa/confirm [post]
+func ConfirmTOTP(c *gin.Context) {
+	var request TOTPCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := services.ConfirmTOTPEnrollment(user, request.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTOTPCode) || errors.Is(err, services.ErrTOTPNotEnrolled) || errors.Is(err, services.ErrTOTPAlreadyEnabled) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not confirm enrollment"})
+		return
+	}
+
+	// an enrollment token has served its purpose; the user logs in again with 2FA
+	claims := c.MustGet("claims").(*utils.Claims)
+	if claims.TokenUse == utils.TokenUseMFAEnrollment {
+		if err := services.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to revoke enrollment token", "error", err)
+		}
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUser2FAEnable, TargetType: services.AuditTargetUser, TargetID: user.ID})
+
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns off 2FA for the current user
+// @Summary Disable 2FA
+// @Description Disables 2FA after verifying a current code. Not allowed when the tenant requires 2FA for the user's role.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body TOTPCodeRequest true "TOTP code"
+// @Success 200 {object} map[string]bool
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /auth/2fa/disable [post]
+func DisableTOTP(c *gin.Context) {
+	var request TOTPCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := services.DisableTOTP(user, request.Code); err != nil {
+		switch {
+		case errors.Is(err, services.ErrTwoFactorRequiredByOrg):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidTOTPCode), errors.Is(err, services.ErrTOTPNotEnrolled):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication"})
+		}
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUser2FADisable, TargetType: services.AuditTargetUser, TargetID: user.ID})
+	c.JSON(http.StatusOK, gin.H{"totp_enabled": false})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+// @Summary Regenerate 2FA recovery codes
+// @Description Invalidates all existing recovery codes and returns a new set, after verifying a current TOTP code
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body TOTPCodeRequest true "TOTP code"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /auth/2fa/recovery-codes [post]
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var request TOTPCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": InvalidRequestMessage})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := services.VerifySecondFactor(user, request.Code, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidTOTPCode.Error()})
+		return
+	}
+	codes, err := services.RegenerateRecoveryCodes(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate recovery codes"})
+		return
+	}
+	recordAudit(c, services.AuditEntry{Action: services.AuditUserRecoveryCodes, TargetType: services.AuditTargetUser, TargetID: user.ID})
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// currentUser loads the authenticated user, writing an error response on failure
+func currentUser(c *gin.Context) (models.User, bool) {
+	user, err := repos.Users.Get(c.Request.Context(), c.GetString("tenant_id"), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
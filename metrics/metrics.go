@@ -0,0 +1,186 @@
+// Package metrics defines the Prometheus metrics of the service, served on
+// /metrics
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+// Stream operations
+const (
+	StreamCreateUser     = "CreateStreamUser"
+	StreamCreateChannel  = "CreateStreamChannel"
+	StreamAddMembers     = "AddMembers"
+	StreamRemoveMembers  = "RemoveMembers"
+	StreamUpdateChannel  = "UpdateChannel"
+	StreamDeleteChannel  = "DeleteChannel"
+	StreamSendMessage    = "SendMessage"
+	StreamQueryChannel   = "QueryChannel"
+	StreamGetMessage     = "GetMessage"
+	StreamDeleteMessage  = "DeleteMessage"
+	StreamSyncRole       = "SyncRole"
+	StreamDeleteRole     = "DeleteRole"
+	outcomeSuccess       = "success"
+	outcomeError         = "error"
+	unmatchedRoute       = "unmatched"
+	gormStartTimeSetting = "metrics:start"
+)
+
+// Login outcomes
+const (
+	LoginSuccess   = "success"
+	LoginFailure   = "failure"
+	LoginThrottled = "throttled"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests being served.",
+	})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Database query latency by operation and table.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+	dbErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Failed database queries by operation and table; record not found is not a failure.",
+	}, []string{"operation", "table"})
+
+	streamCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_api_calls_total",
+		Help: "Calls to the Stream API by operation and outcome.",
+	}, []string{"operation", "outcome"})
+	streamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stream_api_call_duration_seconds",
+		Help:    "Stream API call latency by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	messagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_messages_sent_total",
+		Help: "Messages sent by tenant.",
+	}, []string{"tenant_id"})
+	channelsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_channels_created_total",
+		Help: "Channels created by tenant.",
+	}, []string{"tenant_id"})
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_logins_total",
+		Help: "Login attempts by outcome.",
+	}, []string{"outcome"})
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limited_total",
+		Help: "Requests rejected by a rate limit, by limit.",
+	}, []string{"limit"})
+)
+
+// ObserveHTTPRequest records a served request. route is the matched route
+// pattern, never the raw path, to keep the number of series bounded.
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = unmatchedRoute
+	}
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// HTTPRequestStarted counts a request in flight until the returned func is called
+func HTTPRequestStarted() func() {
+	httpInFlight.Inc()
+	return httpInFlight.Dec
+}
+
+// ObserveStreamCall records a Stream API call that started at start
+func ObserveStreamCall(operation string, start time.Time, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+	}
+	streamCalls.WithLabelValues(operation, outcome).Inc()
+	streamDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// MessageSent counts a message sent in the tenant
+func MessageSent(tenantID string) {
+	messagesSent.WithLabelValues(tenantID).Inc()
+}
+
+// ChannelCreated counts a channel created in the tenant
+func ChannelCreated(tenantID string) {
+	channelsCreated.WithLabelValues(tenantID).Inc()
+}
+
+// Login counts a login attempt with the given outcome
+func Login(outcome string) {
+	logins.WithLabelValues(outcome).Inc()
+}
+
+// RateLimited counts a request rejected by the named limit
+func RateLimited(limit string) {
+	rateLimited.WithLabelValues(limit).Inc()
+}
+
+// InstrumentGORM times every query made through db
+func InstrumentGORM(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		operation     string
+		before, after func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+	for _, hook := range hooks {
+		if err := hook.before("metrics:before_"+hook.operation, startQuery); err != nil {
+			return err
+		}
+		if err := hook.after("metrics:after_"+hook.operation, finishQuery(hook.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startQuery(db *gorm.DB) {
+	db.InstanceSet(gormStartTimeSetting, time.Now())
+}
+
+func finishQuery(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(gormStartTimeSetting)
+		if !ok {
+			return
+		}
+		start, _ := value.(time.Time)
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		dbDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			dbErrors.WithLabelValues(operation, table).Inc()
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveHTTPRequestLabelsUnmatchedRoutes(t *testing.T) {
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("GET", unmatchedRoute, "404"))
+	ObserveHTTPRequest("GET", "", 404, time.Millisecond)
+	assert.Equal(t, before+1, testutil.ToFloat64(httpRequests.WithLabelValues("GET", unmatchedRoute, "404")))
+}
+
+func TestObserveStreamCallCountsOutcome(t *testing.T) {
+	ObserveStreamCall(StreamSendMessage, time.Now(), nil)
+	ObserveStreamCall(StreamSendMessage, time.Now(), errors.New("boom"))
+	ObserveStreamCall(StreamSendMessage, time.Now(), errors.New("boom"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(streamCalls.WithLabelValues(StreamSendMessage, outcomeSuccess)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(streamCalls.WithLabelValues(StreamSendMessage, outcomeError)))
+}
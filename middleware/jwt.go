@@ -1,14 +1,18 @@
 package middleware
 
 import (
+	"log/slog"
 	"net/http"
 
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
 	"github.com/Nyagar-Abraham/chat-app/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 )
 
-func JWTAuth() gin.HandlerFunc {
+// JWTAuth authenticates the request with an access token. Restricted tokens
+// (see utils.TokenUseMFAEnrollment) are only accepted when listed in allowedUses.
+func JWTAuth(allowedUses ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
 		if tokenString == "" {
@@ -23,25 +27,46 @@ func JWTAuth() gin.HandlerFunc {
 			tokenString = tokenString[7:]
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return utils.JwtSecret, nil
-		})
-		if err != nil || !token.Valid {
+		claims, err := utils.ParseToken(tokenString)
+		if err != nil || !tokenUseAllowed(claims.TokenUse, allowedUses) {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"error": "Invalid Token",
 			})
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		c.Set("user_id", claims["user_id"])
-		if role, ok := claims["role"]; ok {
-			c.Set("user_role", role)
+		if services.IsTokenRevoked(claims) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Token has been revoked",
+			})
+			return
 		}
 
-		if tenantID, ok := claims["tenant_id"]; ok {
-			c.Set("tenant_id", tenantID)
+		if err := services.CheckGuestAccess(claims.TenantID, models.Role(claims.Role)); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Guest access is disabled",
+			})
+			return
 		}
+
+		c.Set("claims", claims)
+		c.Set("user_id", claims.UserID)
+		c.Set("user_role", claims.Role)
+		c.Set("tenant_id", claims.TenantID)
+		c.Set("platform_admin", claims.PlatformAdmin)
+		logAuthenticated(c, slog.String("user_id", claims.UserID), slog.String("tenant_id", claims.TenantID))
 		c.Next()
 	}
 }
+
+func tokenUseAllowed(use string, allowedUses []string) bool {
+	if use == "" {
+		return true
+	}
+	for _, allowed := range allowedUses {
+		if use == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTAuthRejectsTokensAfterVersionBump(t *testing.T) {
+	t.Setenv("JWT_SIGNING_ALG", utils.AlgHS256)
+	t.Setenv("JWT_SECRET", "test-secret")
+	ks, err := utils.NewKeySetFromEnv()
+	require.NoError(t, err)
+	utils.SetKeySet(ks)
+	mock := testutil.SetupMockDB(t)
+
+	token, err := utils.GenerateToken(models.User{ID: testutil.UserOne, TenantID: testutil.TenantOne, Role: models.RoleMember})
+	require.NoError(t, err)
+	router := testutil.SetupTestRouter()
+	router.GET("/me", JWTAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	expectUser := func(tokenVersion int) {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "revoked_tokens"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`SELECT "id","token_version","disabled" FROM "users"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "token_version", "disabled"}).AddRow(testutil.UserOne, tokenVersion, false))
+	}
+
+	expectUser(0)
+	assert.Equal(t, http.StatusOK, get())
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET "token_version"=token_version \+ 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, services.RevokeAllUserTokens(testutil.UserOne))
+
+	expectUser(1)
+	assert.Equal(t, http.StatusUnauthorized, get())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics records the status and latency of every request per route
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done := metrics.HTTPRequestStarted()
+		start := time.Now()
+		c.Next()
+		done()
+		metrics.ObserveHTTPRequest(c.Request.Method, c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
@@ -3,27 +3,41 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/services"
 	"github.com/gin-gonic/gin"
 )
 
-// enforce rbac on endpoinr
-func RequireRole(roles ...string) gin.HandlerFunc {
+// RequirePlatformAdmin restricts the cross-tenant admin API to platform
+// operators; a tenant ADMIN is not enough.
+func RequirePlatformAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("user_role")
-		if !exists {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Missing user role",
-			})
+		if !c.GetBool("platform_admin") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Platform admin required"})
 			return
 		}
+		c.Next()
+	}
+}
 
-		for _, role := range roles {
-			if userRole == role {
-				c.Next()
-				return
-			}
+// RequirePermission allows the request when the caller's role holds the
+// permission in their tenant, per the role permission policy
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("user_role")
+		if role == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing user role"})
+			return
 		}
-
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Insufficient permissions"})
+		allowed, err := services.HasPermission(c.GetString("tenant_id"), role, permission)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Could not check permissions"})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "permission": permission})
+			return
+		}
+		c.Next()
 	}
 }
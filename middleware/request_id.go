@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Nyagar-Abraham/chat-app/tracing"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = utils.RequestIDHeader
+
+// clients may pass their own request ID, but not arbitrary text that ends up in logs
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID tags every request with an ID, taken from the X-Request-ID
+// header when the client sent a usable one, and echoes it in the response.
+// The ID is logged with everything logged under the request's context and
+// added to JSON error bodies as "request_id", along with "trace_id" when the
+// request is traced.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(utils.WithRequestID(c.Request.Context(), id))
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", id))
+
+		writer := &errorBodyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		writer.flush(id, tracing.TraceID(c.Request.Context()))
+	}
+}
+
+// errorBodyWriter holds back JSON error bodies so that the request ID can be
+// added to them
+type errorBodyWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	buffered bool
+}
+
+func (w *errorBodyWriter) holdBack() bool {
+	if !w.buffered && !w.Written() && w.Status() >= http.StatusBadRequest &&
+		strings.Contains(w.Header().Get("Content-Type"), "json") {
+		w.buffered = true
+	}
+	return w.buffered
+}
+
+func (w *errorBodyWriter) Write(data []byte) (int, error) {
+	if w.holdBack() {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorBodyWriter) WriteString(s string) (int, error) {
+	if w.holdBack() {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// flush writes the held back body, with the request and trace IDs when it is
+// an object
+func (w *errorBodyWriter) flush(requestID, traceID string) {
+	if !w.buffered {
+		return
+	}
+	body := w.body.Bytes()
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) == nil {
+		_, hasRequestID := fields["request_id"]
+		_, hasTraceID := fields["trace_id"]
+		if !hasRequestID || (traceID != "" && !hasTraceID) {
+			if !hasRequestID {
+				fields["request_id"] = requestID
+			}
+			if traceID != "" && !hasTraceID {
+				fields["trace_id"] = traceID
+			}
+			if withIDs, err := json.Marshal(fields); err == nil {
+				body = withIDs
+			}
+		}
+	}
+	_, _ = w.ResponseWriter.Write(body)
+}
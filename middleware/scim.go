@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/services"
+	"github.com/gin-gonic/gin"
+)
+
+// SCIMAuth authenticates SCIM provisioning clients with a per-tenant bearer
+// token, looked up in repos, and scopes the request to that tenant.
+func SCIMAuth(repos repository.Repositories) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		raw := strings.TrimPrefix(header, "Bearer ")
+		if header == "" || raw == header {
+			scimUnauthorized(c)
+			return
+		}
+
+		token, err := services.AuthenticateSCIMToken(c.Request.Context(), repos, raw)
+		if err != nil {
+			scimUnauthorized(c)
+			return
+		}
+
+		c.Set("tenant_id", token.TenantID)
+		c.Set("scim_token_id", token.ID)
+		logAuthenticated(c, slog.String("scim_token_id", token.ID), slog.String("tenant_id", token.TenantID))
+		c.Next()
+	}
+}
+
+func scimUnauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+		"status":  "401",
+		"detail":  "Invalid or missing bearer token",
+	})
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"errors"
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -14,9 +17,35 @@ const (
 	RoleGuest     Role = "GUEST"
 )
 
+// IsBuiltin reports whether the role is one of the fixed roles. Any other
+// role must be a CustomRole of the user's tenant.
+func (r Role) IsBuiltin() bool {
+	switch r {
+	case RoleAdmin, RoleModerator, RoleMember, RoleGuest:
+		return true
+	}
+	return false
+}
+
 type Tenant struct {
 	ID   string `gorm:"type:uuid;primaryKey" json:"id"`
 	Name string `gorm:"uniqueIndex;not null" json:"name"`
+	// security policy, managed by the tenant's ADMINs
+	RequireVerifiedEmailForLogin    bool `gorm:"not null;default:false" json:"require_verified_email_for_login"`
+	RequireVerifiedEmailForChannels bool `gorm:"not null;default:false" json:"require_verified_email_for_channels"`
+	// Require2FAForPrivileged forces users whose role holds a privileged
+	// permission, built in or custom, to enroll in TOTP
+	Require2FAForPrivileged bool `gorm:"column:require_2fa_for_privileged;not null;default:false" json:"require_2fa_for_privileged"`
+	// Domains are the email domains claimed by the tenant
+	Domains []TenantDomain `gorm:"foreignKey:TenantID" json:"domains,omitempty"`
+	// PlanID is the tenant's plan, which sets its usage limits
+	PlanID string `gorm:"not null;default:free" json:"plan_id"`
+	// OwnerID is the user who registered the tenant
+	OwnerID string `json:"owner_id,omitempty"`
+	// Suspended tenants are managed by platform admins; their users cannot sign in
+	Suspended   bool       `gorm:"not null;default:false" json:"suspended"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
 func (t *Tenant) BeforeCreate(tx *gorm.DB) (err error) {
@@ -26,6 +55,146 @@ func (t *Tenant) BeforeCreate(tx *gorm.DB) (err error) {
 	return nil
 }
 
+// RegistrationPolicy controls how people can join a tenant on their own
+type RegistrationPolicy string
+
+const (
+	// RegistrationDomainAndInvite lets people join through a verified email domain or an invitation
+	RegistrationDomainAndInvite RegistrationPolicy = "domain_and_invite"
+	// RegistrationInviteOnly only lets invited people join
+	RegistrationInviteOnly RegistrationPolicy = "invite_only"
+	// RegistrationClosed only lets ADMINs, SCIM and SSO add users
+	RegistrationClosed RegistrationPolicy = "closed"
+)
+
+// TenantSettings are a tenant's configurable behavior. A tenant without a
+// row uses services.DefaultTenantSettings. Version is bumped on every
+// change, so that concurrent edits can be detected.
+type TenantSettings struct {
+	TenantID string `gorm:"type:uuid;primaryKey" json:"tenant_id"`
+	Version  int    `gorm:"not null" json:"version"`
+	// DefaultRole is given to users created without a role, e.g. through
+	// SCIM, SSO, a verified domain or an invitation that names none
+	DefaultRole        Role               `gorm:"not null" json:"default_role"`
+	RegistrationPolicy RegistrationPolicy `gorm:"not null" json:"registration_policy"`
+	MessageMaxLength   int                `gorm:"not null" json:"message_max_length"`
+	// RetentionDays hides older messages; 0 keeps messages forever
+	RetentionDays int `gorm:"not null" json:"retention_days"`
+	// AllowedAttachmentTypes lists Stream attachment types (image, video,
+	// audio, file); empty forbids attachments
+	AllowedAttachmentTypes []string  `gorm:"serializer:json" json:"allowed_attachment_types"`
+	GuestAccess            bool      `gorm:"not null" json:"guest_access"`
+	UpdatedBy              string    `json:"updated_by,omitempty"`
+	UpdatedAt              time.Time `json:"updated_at"`
+}
+
+// Plan is a customer tier. A limit of 0 means unlimited.
+type Plan struct {
+	ID                   string    `gorm:"primaryKey" json:"id"`
+	Name                 string    `gorm:"not null" json:"name"`
+	MaxUsers             int64     `gorm:"not null" json:"max_users"`
+	MaxChannels          int64     `gorm:"not null" json:"max_channels"`
+	MaxMembersPerChannel int64     `gorm:"not null" json:"max_members_per_channel"`
+	MaxMessagesPerDay    int64     `gorm:"not null" json:"max_messages_per_day"`
+	MaxStorageBytes      int64     `gorm:"not null" json:"max_storage_bytes"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// TenantDailyUsage counts a tenant's messages and the bytes they added to
+// storage per UTC day. Deleted messages subtract their bytes on the day of
+// deletion, so storage in use is the sum over all days.
+type TenantDailyUsage struct {
+	TenantID     string    `gorm:"type:uuid;primaryKey" json:"tenant_id"`
+	Day          time.Time `gorm:"type:date;primaryKey" json:"day"`
+	Messages     int64     `gorm:"not null;default:0" json:"messages"`
+	StorageBytes int64     `gorm:"not null;default:0" json:"storage_bytes"`
+}
+
+// TenantDomain is an email domain claimed by a tenant. Once ownership is
+// proven with a DNS TXT record, people registering with an address at the
+// domain join the tenant. Several tenants may claim a domain, but only one
+// can verify it.
+type TenantDomain struct {
+	ID       string `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID string `gorm:"type:uuid;not null;uniqueIndex:idx_tenant_domain" json:"tenant_id"`
+	Domain   string `gorm:"not null;uniqueIndex:idx_tenant_domain;index:idx_verified_domain,unique,where:verified_at IS NOT NULL" json:"domain"`
+	// VerificationToken is published in the TXT record, see services.DomainChallenge
+	VerificationToken string     `gorm:"not null" json:"verification_token"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+func (d *TenantDomain) BeforeCreate(tx *gorm.DB) (err error) {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// Permission names an action that can be granted to roles, see services.PermissionCatalog
+type Permission string
+
+const (
+	PermChannelCreate         Permission = "channel.create"
+	PermChannelMembersManage  Permission = "channel.members.manage"
+	PermChannelJoin           Permission = "channel.join"
+	PermMessageSend           Permission = "message.send"
+	PermMessageDeleteAny      Permission = "message.delete.any"
+	PermUserCreate            Permission = "user.create"
+	PermUserInvite            Permission = "user.invite"
+	PermUserUpdate            Permission = "user.update"
+	PermUserUpdateRole        Permission = "user.update.role"
+	PermUserDelete            Permission = "user.delete"
+	PermUserUnlock            Permission = "user.unlock"
+	PermTenantManage          Permission = "tenant.manage"
+	PermTenantSCIMManage      Permission = "tenant.scim.manage"
+	PermTenantPermissionsEdit Permission = "tenant.permissions.manage"
+	PermAuditRead             Permission = "audit.read"
+)
+
+// RolePermission binds a permission to a role. Rows with an empty TenantID
+// are the platform defaults; a row for a tenant overrides the default for
+// that tenant, granting (Allowed) or denying the permission.
+type RolePermission struct {
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID   string     `gorm:"not null;default:'';uniqueIndex:idx_role_permission" json:"tenant_id,omitempty"`
+	Role       string     `gorm:"not null;uniqueIndex:idx_role_permission" json:"role"`
+	Permission Permission `gorm:"not null;uniqueIndex:idx_role_permission" json:"permission"`
+	Allowed    bool       `gorm:"not null" json:"allowed"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func (rp *RolePermission) BeforeCreate(tx *gorm.DB) (err error) {
+	if rp.ID == "" {
+		rp.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// CustomRole is a tenant-defined role. Users hold it through User.Role = Key;
+// its permissions live in the role_permissions table as tenant rows for Key.
+type CustomRole struct {
+	ID          string `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID    string `gorm:"not null;uniqueIndex:idx_tenant_role_key" json:"tenant_id"`
+	Key         Role   `gorm:"not null;uniqueIndex:idx_tenant_role_key" json:"key"`
+	Name        string `gorm:"not null" json:"name"`
+	Description string `json:"description"`
+	// StreamRole is the custom role created on Stream, unique across tenants
+	StreamRole  string       `gorm:"not null" json:"stream_role"`
+	Permissions []Permission `gorm:"-" json:"permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+func (cr *CustomRole) BeforeCreate(tx *gorm.DB) (err error) {
+	if cr.ID == "" {
+		cr.ID = uuid.New().String()
+	}
+	return nil
+}
+
 // TenantID is set by the backend logic, not by registration request
 type User struct {
 	ID       string `gorm:"type:uuid;primaryKey" json:"id"`
@@ -33,7 +202,25 @@ type User struct {
 	Name     string `json:"name"`
 	Password string `gorm:"not null" json:"password"`
 	Role     Role   `gorm:"default:MEMBER" json:"role"`
-	TenantID string `json:"tenant_id"`
+	TenantID string `gorm:"type:uuid;not null" json:"tenant_id"`
+	// ExternalID is the identifier assigned by a SCIM provisioning client
+	ExternalID string `gorm:"index" json:"external_id,omitempty"`
+	// Disabled users cannot log in and their tokens are rejected
+	Disabled bool `gorm:"not null;default:false" json:"disabled"`
+	// EmailVerified is reset whenever the email address changes
+	EmailVerified bool `gorm:"not null;default:false" json:"email_verified"`
+	// TOTPSecret is set on enrollment; TOTPEnabled only once the first code is confirmed
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastStep int64  `gorm:"not null;default:0" json:"-"`
+	// TokenVersion is embedded in every JWT; bumping it revokes all of the user's tokens
+	TokenVersion int `gorm:"not null;default:0" json:"-"`
+	// PlatformAdmin operates the whole platform across tenants, unlike the
+	// tenant-scoped ADMIN role. Only granted via PLATFORM_ADMIN_EMAILS.
+	PlatformAdmin bool `gorm:"not null;default:false" json:"-"`
+	// DomainJoined users joined through a verified email domain and must
+	// verify their address before they can log in
+	DomainJoined bool `gorm:"not null;default:false" json:"domain_joined"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
@@ -48,8 +235,10 @@ type Channel struct {
 	StreamId    string `gorm:"uniqueIndex;not null" json:"stream_id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	TenantID    string `json:"tenant_id"`
+	TenantID    string `gorm:"type:uuid;not null" json:"tenant_id"`
 	CreatedBy   string `json:"created_by"`
+	// ExternalID is set when the channel is provisioned as a SCIM group
+	ExternalID string `gorm:"index" json:"external_id,omitempty"`
 }
 
 func (c *Channel) BeforeCreate(tx *gorm.DB) (err error) {
@@ -61,9 +250,9 @@ func (c *Channel) BeforeCreate(tx *gorm.DB) (err error) {
 
 type ChannelMember struct {
 	ID        string `gorm:"type:uuid;primaryKey" json:"id"`
-	ChannelID string `gorm:"not null;index:idx_channel_user" json:"channel_id"`
-	UserID    string `gorm:"not null;index:idx_channel_user" json:"user_id"`
-	TenantID  string `gorm:"not null;index" json:"tenant_id"`
+	ChannelID string `gorm:"type:uuid;not null;uniqueIndex:idx_channel_user" json:"channel_id"`
+	UserID    string `gorm:"type:uuid;not null;uniqueIndex:idx_channel_user" json:"user_id"`
+	TenantID  string `gorm:"type:uuid;not null;index" json:"tenant_id"`
 	JoinedAt  int64  `gorm:"autoCreateTime" json:"joined_at"`
 }
 
@@ -73,3 +262,281 @@ func (cm *ChannelMember) BeforeCreate(tx *gorm.DB) (err error) {
 	}
 	return nil
 }
+
+// RefreshToken is an opaque, rotating refresh token. Only the SHA-256 hash of
+// the token is stored. Tokens descending from the same login share a FamilyID
+// so that reuse of a rotated token can revoke the whole chain.
+type RefreshToken struct {
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     string     `gorm:"not null;index" json:"user_id"`
+	TenantID   string     `gorm:"not null;index" json:"tenant_id"`
+	FamilyID   string     `gorm:"not null;index" json:"family_id"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy string     `json:"replaced_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (rt *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if rt.ID == "" {
+		rt.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// RevokedToken records an access token (by its jti) revoked before expiry.
+// Rows can be purged once ExpiresAt has passed.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey" json:"jti"`
+	UserID    string    `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PasswordResetToken is a single-use, expiring password reset token.
+// Only the SHA-256 hash of the emailed token is stored.
+type PasswordResetToken struct {
+	ID        string     `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (prt *PasswordResetToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if prt.ID == "" {
+		prt.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// EmailVerificationToken proves ownership of Email for UserID. A token only
+// verifies the user if their email has not changed since it was issued.
+type EmailVerificationToken struct {
+	ID        string     `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	Email     string     `gorm:"not null" json:"email"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (evt *EmailVerificationToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if evt.ID == "" {
+		evt.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// RecoveryCode is a single-use 2FA recovery code, stored hashed
+type RecoveryCode struct {
+	ID        string     `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (rc *RecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	if rc.ID == "" {
+		rc.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// TenantOIDCConfig configures single sign-on through the tenant's OpenID
+// Connect identity provider. RoleMapping maps values of RoleClaim onto roles.
+type TenantOIDCConfig struct {
+	ID             string          `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID       string          `gorm:"uniqueIndex;not null" json:"tenant_id"`
+	Issuer         string          `gorm:"not null" json:"issuer"`
+	ClientID       string          `gorm:"not null" json:"client_id"`
+	ClientSecret   string          `json:"-"`
+	AllowedDomains []string        `gorm:"serializer:json" json:"allowed_domains"`
+	RoleClaim      string          `json:"role_claim"`
+	RoleMapping    map[string]Role `gorm:"serializer:json" json:"role_mapping"`
+	DefaultRole    Role            `gorm:"default:MEMBER" json:"default_role"`
+	Enabled        bool            `gorm:"not null" json:"enabled"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+func (oc *TenantOIDCConfig) BeforeCreate(tx *gorm.DB) (err error) {
+	if oc.ID == "" {
+		oc.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// OIDCAuthState holds the state, nonce and PKCE verifier of a pending
+// authorization code flow. It is consumed by the callback.
+type OIDCAuthState struct {
+	State        string    `gorm:"primaryKey" json:"-"`
+	TenantID     string    `gorm:"not null" json:"tenant_id"`
+	Nonce        string    `gorm:"not null" json:"-"`
+	CodeVerifier string    `gorm:"not null" json:"-"`
+	ExpiresAt    time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserIdentity links a user to the subject of an external identity provider
+type UserIdentity struct {
+	ID        string    `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    string    `gorm:"not null;index" json:"user_id"`
+	TenantID  string    `gorm:"not null;index" json:"tenant_id"`
+	Issuer    string    `gorm:"not null;uniqueIndex:idx_identity_issuer_subject" json:"issuer"`
+	Subject   string    `gorm:"not null;uniqueIndex:idx_identity_issuer_subject" json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ui *UserIdentity) BeforeCreate(tx *gorm.DB) (err error) {
+	if ui.ID == "" {
+		ui.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// SCIMToken is a per-tenant bearer token for SCIM provisioning clients.
+// Only the SHA-256 hash of the token is stored.
+type SCIMToken struct {
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID   string     `gorm:"not null;index" json:"tenant_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	CreatedBy  string     `json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (st *SCIMToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if st.ID == "" {
+		st.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// Invitation lets the holder of the emailed token join a tenant with a
+// preassigned role. Only the SHA-256 hash of the token is stored.
+type Invitation struct {
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID   string     `gorm:"not null;index" json:"tenant_id"`
+	Email      string     `gorm:"not null;index" json:"email"`
+	Role       Role       `gorm:"not null" json:"role"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	InvitedBy  string     `gorm:"not null" json:"invited_by"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastSentAt time.Time  `json:"last_sent_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (i *Invitation) BeforeCreate(tx *gorm.DB) (err error) {
+	if i.ID == "" {
+		i.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// RateLimitBucket is a token bucket of the shared rate limit store
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;index"`
+}
+
+// LoginThrottle counts failed logins for an account (by email, known or not)
+// or a client IP, see services.RecordLoginFailure
+type LoginThrottle struct {
+	Key          string     `gorm:"primaryKey" json:"-"`
+	Failures     int        `gorm:"not null" json:"failures"`
+	Lockouts     int        `gorm:"not null" json:"lockouts"`
+	LastFailedAt time.Time  `gorm:"not null;index" json:"last_failed_at"`
+	BlockedUntil *time.Time `json:"blocked_until"`
+}
+
+// AuditChange is the value of a field before and after a change
+type AuditChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// AuditLog records who changed what in a tenant. Entries are append-only:
+// the model refuses updates and deletes. The entries of a tenant form a hash
+// chain: Seq counts them from 1 and Hash covers the entry and PrevHash, the
+// hash of the entry before it.
+type AuditLog struct {
+	ID         string                 `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID   string                 `gorm:"not null;index:idx_audit_tenant_time,priority:1;uniqueIndex:idx_audit_tenant_seq,priority:1" json:"tenant_id"`
+	Seq        int64                  `gorm:"not null;uniqueIndex:idx_audit_tenant_seq,priority:2" json:"seq"`
+	PrevHash   string                 `json:"prev_hash"`
+	Hash       string                 `gorm:"not null" json:"hash"`
+	ActorID    string                 `gorm:"index" json:"actor_id"`
+	ActorType  string                 `gorm:"not null" json:"actor_type"`
+	Action     string                 `gorm:"not null;index" json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `gorm:"index" json:"target_id"`
+	Changes    map[string]AuditChange `gorm:"serializer:json" json:"changes,omitempty"`
+	IP         string                 `json:"ip"`
+	RequestID  string                 `gorm:"index" json:"request_id"`
+	CreatedAt  time.Time              `gorm:"not null;index:idx_audit_tenant_time,priority:2" json:"created_at"`
+}
+
+var ErrAuditLogAppendOnly = errors.New("audit log entries cannot be changed")
+
+func (a *AuditLog) BeforeCreate(tx *gorm.DB) (err error) {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (a *AuditLog) BeforeUpdate(tx *gorm.DB) (err error) {
+	return ErrAuditLogAppendOnly
+}
+
+func (a *AuditLog) BeforeDelete(tx *gorm.DB) (err error) {
+	return ErrAuditLogAppendOnly
+}
+
+// AuditChainHead is the latest entry of a tenant's audit chain. Appending
+// locks it, so that entries are chained one at a time.
+type AuditChainHead struct {
+	TenantID  string    `gorm:"primaryKey" json:"tenant_id"`
+	Seq       int64     `gorm:"not null" json:"seq"`
+	Hash      string    `json:"hash"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AuditCheckpoint is a signed statement of a tenant's audit chain head. Once
+// exported, it proves that the entries up to Seq were not changed afterwards.
+type AuditCheckpoint struct {
+	ID       string `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID string `gorm:"not null;uniqueIndex:idx_audit_checkpoint_seq,priority:1" json:"tenant_id"`
+	Seq      int64  `gorm:"not null;uniqueIndex:idx_audit_checkpoint_seq,priority:2" json:"seq"`
+	Hash     string `gorm:"not null" json:"hash"`
+	KeyID    string `gorm:"not null" json:"key_id"`
+	// PublicKey is the base64 Ed25519 key that signed it, for reference only:
+	// verification uses the published key with KeyID
+	PublicKey string    `gorm:"not null" json:"public_key"`
+	Signature string    `gorm:"not null" json:"signature"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+func (a *AuditCheckpoint) BeforeCreate(tx *gorm.DB) (err error) {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (a *AuditCheckpoint) BeforeUpdate(tx *gorm.DB) (err error) {
+	return ErrAuditLogAppendOnly
+}
+
+func (a *AuditCheckpoint) BeforeDelete(tx *gorm.DB) (err error) {
+	return ErrAuditLogAppendOnly
+}
@@ -0,0 +1,442 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGORM returns repositories backed by db, which must have been opened with
+// TranslateError so that constraint violations can be told apart
+func NewGORM(db *gorm.DB) Repositories {
+	return Repositories{
+		Tenants:     gormTenants{db},
+		Users:       gormUsers{db},
+		Channels:    gormChannels{db},
+		Memberships: gormMemberships{db},
+		Invitations: gormInvitations{db},
+		OIDCConfigs: gormOIDCConfigs{db},
+		SCIMTokens:  gormSCIMTokens{db},
+	}
+}
+
+// translate maps GORM errors to the errors of this package
+func translate(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, gorm.ErrForeignKeyViolated):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	}
+	return err
+}
+
+// scoped starts a query on the rows of tenantID
+func scoped(ctx context.Context, db *gorm.DB, tenantID string) (*gorm.DB, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	return db.WithContext(ctx).Where("tenant_id = ?", tenantID), nil
+}
+
+// updated reports ErrNotFound when an update or delete matched no row
+func updated(result *gorm.DB) error {
+	if result.Error != nil {
+		return translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// search counts the records of query matching the filter and loads the
+// filter's page of them, in order, into dest
+func search(query *gorm.DB, filter Filter, order string, dest interface{}) (int64, error) {
+	if filter.Where != "" {
+		query = query.Where(filter.Where, filter.Args...)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return 0, translate(err)
+	}
+	err := query.Order(order).Offset(filter.Offset).Limit(filter.Limit).Find(dest).Error
+	return total, translate(err)
+}
+
+type gormTenants struct{ db *gorm.DB }
+
+func (r gormTenants) Create(ctx context.Context, tenant *models.Tenant) error {
+	return translate(r.db.WithContext(ctx).Create(tenant).Error)
+}
+
+func (r gormTenants) Get(ctx context.Context, tenantID string) (models.Tenant, error) {
+	var tenant models.Tenant
+	if tenantID == "" {
+		return tenant, ErrTenantRequired
+	}
+	err := r.db.WithContext(ctx).Where("id = ?", tenantID).First(&tenant).Error
+	return tenant, translate(err)
+}
+
+func (r gormTenants) List(ctx context.Context) ([]TenantSummary, error) {
+	var tenants []TenantSummary
+	err := r.db.WithContext(ctx).Table("tenants").
+		Select("tenants.*, (SELECT COUNT(*) FROM users WHERE users.tenant_id = tenants.id) AS user_count").
+		Order("tenants.name").
+		Scan(&tenants).Error
+	return tenants, translate(err)
+}
+
+func (r gormTenants) Update(ctx context.Context, tenant *models.Tenant) error {
+	if tenant.ID == "" {
+		return ErrTenantRequired
+	}
+	// Save would insert a tenant that does not exist, Updates only changes it
+	return updated(r.db.WithContext(ctx).Model(tenant).Select("*").Omit("id", "created_at", "Domains").Updates(tenant))
+}
+
+// Lock takes FOR NO KEY UPDATE rather than FOR UPDATE, which would also block
+// every insert referencing the tenant until fn returns
+func (r gormTenants) Lock(ctx context.Context, tenantID string, fn func(tx Repositories) error) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tenant models.Tenant
+		err := tx.Clauses(clause.Locking{Strength: "NO KEY UPDATE"}).Select("id").Where("id = ?", tenantID).First(&tenant).Error
+		if err != nil {
+			return translate(err)
+		}
+		return fn(NewGORM(tx))
+	})
+}
+
+type gormUsers struct{ db *gorm.DB }
+
+func (r gormUsers) Create(ctx context.Context, tenantID string, user *models.User) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	user.TenantID = tenantID
+	return translate(r.db.WithContext(ctx).Create(user).Error)
+}
+
+func (r gormUsers) Get(ctx context.Context, tenantID, userID string) (models.User, error) {
+	var user models.User
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return user, err
+	}
+	return user, translate(query.Where("id = ?", userID).First(&user).Error)
+}
+
+func (r gormUsers) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	return user, translate(r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error)
+}
+
+func (r gormUsers) List(ctx context.Context, tenantID string) ([]models.User, error) {
+	var users []models.User
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return users, translate(query.Find(&users).Error)
+}
+
+func (r gormUsers) ListByRole(ctx context.Context, tenantID string, role models.Role) ([]models.User, error) {
+	var users []models.User
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return users, translate(query.Where("role = ?", role).Order("email").Find(&users).Error)
+}
+
+func (r gormUsers) Search(ctx context.Context, tenantID string, filter Filter) ([]models.User, int64, error) {
+	var users []models.User
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := search(query.Model(&models.User{}), filter, "email", &users)
+	return users, total, err
+}
+
+func (r gormUsers) CountEnabled(ctx context.Context, tenantID string) (int64, error) {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = query.Model(&models.User{}).Where("disabled = ?", false).Count(&count).Error
+	return count, translate(err)
+}
+
+func (r gormUsers) Update(ctx context.Context, tenantID string, user *models.User) error {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return err
+	}
+	if user.ID == "" || user.TenantID != tenantID {
+		return ErrNotFound
+	}
+	return updated(query.Model(user).Select("*").Omit("id", "tenant_id").Updates(user))
+}
+
+func (r gormUsers) Delete(ctx context.Context, tenantID, userID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND tenant_id = ?", userID, tenantID).Delete(&models.ChannelMember{}).Error; err != nil {
+			return translate(err)
+		}
+		return updated(tx.Where("id = ? AND tenant_id = ?", userID, tenantID).Delete(&models.User{}))
+	})
+}
+
+type gormChannels struct{ db *gorm.DB }
+
+func (r gormChannels) Create(ctx context.Context, tenantID string, channel *models.Channel, memberIDs ...string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	channel.TenantID = tenantID
+	return translate(r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(channel).Error; err != nil {
+			return err
+		}
+		for _, userID := range memberIDs {
+			if err := tx.Create(&models.ChannelMember{ChannelID: channel.ID, UserID: userID, TenantID: tenantID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (r gormChannels) Get(ctx context.Context, tenantID, channelID string) (models.Channel, error) {
+	var channel models.Channel
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return channel, err
+	}
+	return channel, translate(query.Where("id = ?", channelID).First(&channel).Error)
+}
+
+func (r gormChannels) GetByStreamID(ctx context.Context, tenantID, streamID string) (models.Channel, error) {
+	var channel models.Channel
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return channel, err
+	}
+	return channel, translate(query.Where("stream_id = ?", streamID).First(&channel).Error)
+}
+
+func (r gormChannels) List(ctx context.Context, tenantID string) ([]models.Channel, error) {
+	var channels []models.Channel
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return channels, translate(query.Find(&channels).Error)
+}
+
+func (r gormChannels) ListByMember(ctx context.Context, tenantID, userID string) ([]models.Channel, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	var channels []models.Channel
+	err := r.db.WithContext(ctx).Table("channels").
+		Joins("JOIN channel_members ON channels.id = channel_members.channel_id").
+		Where("channel_members.user_id = ? AND channel_members.tenant_id = ?", userID, tenantID).
+		Find(&channels).Error
+	return channels, translate(err)
+}
+
+func (r gormChannels) Search(ctx context.Context, tenantID string, filter Filter) ([]models.Channel, int64, error) {
+	var channels []models.Channel
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := search(query.Model(&models.Channel{}), filter, "name", &channels)
+	return channels, total, err
+}
+
+func (r gormChannels) Count(ctx context.Context, tenantID string) (int64, error) {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	return count, translate(query.Model(&models.Channel{}).Count(&count).Error)
+}
+
+func (r gormChannels) Update(ctx context.Context, tenantID string, channel *models.Channel) error {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return err
+	}
+	if channel.ID == "" || channel.TenantID != tenantID {
+		return ErrNotFound
+	}
+	return updated(query.Model(channel).Select("*").Omit("id", "tenant_id").Updates(channel))
+}
+
+func (r gormChannels) Delete(ctx context.Context, tenantID, channelID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("channel_id = ? AND tenant_id = ?", channelID, tenantID).Delete(&models.ChannelMember{}).Error; err != nil {
+			return translate(err)
+		}
+		return updated(tx.Where("id = ? AND tenant_id = ?", channelID, tenantID).Delete(&models.Channel{}))
+	})
+}
+
+type gormMemberships struct{ db *gorm.DB }
+
+// Add relies on the composite foreign keys to reject a channel or user of
+// another tenant
+func (r gormMemberships) Add(ctx context.Context, tenantID, channelID, userID string) (models.ChannelMember, error) {
+	member := models.ChannelMember{ChannelID: channelID, UserID: userID, TenantID: tenantID}
+	if tenantID == "" {
+		return member, ErrTenantRequired
+	}
+	return member, translate(r.db.WithContext(ctx).Create(&member).Error)
+}
+
+func (r gormMemberships) Remove(ctx context.Context, tenantID, channelID, userID string) error {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return err
+	}
+	return updated(query.Where("channel_id = ? AND user_id = ?", channelID, userID).Delete(&models.ChannelMember{}))
+}
+
+func (r gormMemberships) IsMember(ctx context.Context, tenantID, channelID, userID string) (bool, error) {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return false, err
+	}
+	var count int64
+	err = query.Model(&models.ChannelMember{}).Where("channel_id = ? AND user_id = ?", channelID, userID).Count(&count).Error
+	return count > 0, translate(err)
+}
+
+func (r gormMemberships) ListMembers(ctx context.Context, tenantID, channelID string) ([]models.User, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	var users []models.User
+	err := r.db.WithContext(ctx).Table("users").
+		Joins("JOIN channel_members ON users.id = channel_members.user_id").
+		Where("channel_members.channel_id = ? AND channel_members.tenant_id = ?", channelID, tenantID).
+		Find(&users).Error
+	return users, translate(err)
+}
+
+func (r gormMemberships) Count(ctx context.Context, tenantID, channelID string) (int64, error) {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = query.Model(&models.ChannelMember{}).Where("channel_id = ?", channelID).Count(&count).Error
+	return count, translate(err)
+}
+
+type gormInvitations struct{ db *gorm.DB }
+
+func (r gormInvitations) Create(ctx context.Context, tenantID string, invitation *models.Invitation) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	invitation.TenantID = tenantID
+	return translate(r.db.WithContext(ctx).Create(invitation).Error)
+}
+
+func (r gormInvitations) Accept(ctx context.Context, tenantID, invitationID string) error {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return err
+	}
+	return updated(query.Model(&models.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitationID).
+		Update("accepted_at", time.Now()))
+}
+
+type gormOIDCConfigs struct{ db *gorm.DB }
+
+func (r gormOIDCConfigs) Get(ctx context.Context, tenantID string) (models.TenantOIDCConfig, error) {
+	var config models.TenantOIDCConfig
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return config, err
+	}
+	return config, translate(query.First(&config).Error)
+}
+
+func (r gormOIDCConfigs) Save(ctx context.Context, tenantID string, config *models.TenantOIDCConfig) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	config.TenantID = tenantID
+	return translate(r.db.WithContext(ctx).Save(config).Error)
+}
+
+func (r gormOIDCConfigs) Delete(ctx context.Context, tenantID string) error {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return err
+	}
+	return translate(query.Delete(&models.TenantOIDCConfig{}).Error)
+}
+
+type gormSCIMTokens struct{ db *gorm.DB }
+
+func (r gormSCIMTokens) Create(ctx context.Context, tenantID string, token *models.SCIMToken) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	token.TenantID = tenantID
+	return translate(r.db.WithContext(ctx).Create(token).Error)
+}
+
+func (r gormSCIMTokens) GetByHash(ctx context.Context, hash string) (models.SCIMToken, error) {
+	var token models.SCIMToken
+	return token, translate(r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&token).Error)
+}
+
+func (r gormSCIMTokens) List(ctx context.Context, tenantID string) ([]models.SCIMToken, error) {
+	var tokens []models.SCIMToken
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, translate(query.Order("created_at").Find(&tokens).Error)
+}
+
+func (r gormSCIMTokens) Touch(ctx context.Context, tenantID, tokenID string) error {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return err
+	}
+	return updated(query.Model(&models.SCIMToken{}).Where("id = ?", tokenID).UpdateColumn("last_used_at", time.Now()))
+}
+
+func (r gormSCIMTokens) Delete(ctx context.Context, tenantID, tokenID string) error {
+	query, err := scoped(ctx, r.db, tenantID)
+	if err != nil {
+		return err
+	}
+	return translate(query.Where("id = ?", tokenID).Delete(&models.SCIMToken{}).Error)
+}
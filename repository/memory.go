@@ -0,0 +1,723 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/google/uuid"
+)
+
+// memoryStore holds the records of the in-memory repositories. It enforces
+// the same unique constraints and tenant scoping as the database.
+type memoryStore struct {
+	mu sync.RWMutex
+	// locked is held by Lock, around fn
+	locked   sync.Mutex
+	tenants  map[string]models.Tenant
+	users    map[string]models.User
+	channels map[string]models.Channel
+	members  map[string]models.ChannelMember
+	// oidc is keyed by tenant ID
+	oidc        map[string]models.TenantOIDCConfig
+	invitations map[string]models.Invitation
+	scimTokens  map[string]models.SCIMToken
+}
+
+// NewMemory returns empty repositories that keep everything in process
+// memory, for tests
+func NewMemory() Repositories {
+	s := &memoryStore{
+		tenants:     map[string]models.Tenant{},
+		users:       map[string]models.User{},
+		channels:    map[string]models.Channel{},
+		members:     map[string]models.ChannelMember{},
+		oidc:        map[string]models.TenantOIDCConfig{},
+		invitations: map[string]models.Invitation{},
+		scimTokens:  map[string]models.SCIMToken{},
+	}
+	return s.repositories()
+}
+
+func (s *memoryStore) repositories() Repositories {
+	return Repositories{
+		Tenants:     memoryTenants{s},
+		Users:       memoryUsers{s},
+		Channels:    memoryChannels{s},
+		Memberships: memoryMemberships{s},
+		Invitations: memoryInvitations{s},
+		OIDCConfigs: memoryOIDCConfigs{s},
+		SCIMTokens:  memorySCIMTokens{s},
+	}
+}
+
+// page returns the bounds of the filter's page of total records
+func page(total int, filter Filter) (int, int) {
+	start := filter.Offset
+	if start > total {
+		start = total
+	}
+	end := total
+	if filter.Limit >= 0 && start+filter.Limit < end {
+		end = start + filter.Limit
+	}
+	return start, end
+}
+
+type memoryTenants struct{ s *memoryStore }
+
+func (r memoryTenants) Create(ctx context.Context, tenant *models.Tenant) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, t := range r.s.tenants {
+		if t.ID == tenant.ID || t.Name == tenant.Name {
+			return ErrDuplicate
+		}
+	}
+	if tenant.ID == "" {
+		tenant.ID = uuid.New().String()
+	}
+	if tenant.PlanID == "" {
+		tenant.PlanID = "free"
+	}
+	tenant.CreatedAt = time.Now()
+	r.s.tenants[tenant.ID] = *tenant
+	return nil
+}
+
+func (r memoryTenants) Get(ctx context.Context, tenantID string) (models.Tenant, error) {
+	if tenantID == "" {
+		return models.Tenant{}, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	tenant, ok := r.s.tenants[tenantID]
+	if !ok {
+		return models.Tenant{}, ErrNotFound
+	}
+	return tenant, nil
+}
+
+func (r memoryTenants) List(ctx context.Context) ([]TenantSummary, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	tenants := []TenantSummary{}
+	for _, tenant := range r.s.tenants {
+		summary := TenantSummary{Tenant: tenant}
+		for _, user := range r.s.users {
+			if user.TenantID == tenant.ID {
+				summary.UserCount++
+			}
+		}
+		tenants = append(tenants, summary)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+	return tenants, nil
+}
+
+func (r memoryTenants) Update(ctx context.Context, tenant *models.Tenant) error {
+	if tenant.ID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	existing, ok := r.s.tenants[tenant.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, t := range r.s.tenants {
+		if t.ID != tenant.ID && t.Name == tenant.Name {
+			return ErrDuplicate
+		}
+	}
+	updated := *tenant
+	updated.CreatedAt = existing.CreatedAt
+	updated.Domains = existing.Domains
+	r.s.tenants[tenant.ID] = updated
+	return nil
+}
+
+// Lock runs fn after the other Lock calls of every tenant. Unlike the
+// database, the store does not undo what fn wrote before failing.
+func (r memoryTenants) Lock(ctx context.Context, tenantID string, fn func(tx Repositories) error) error {
+	if _, err := r.Get(ctx, tenantID); err != nil {
+		return err
+	}
+	r.s.locked.Lock()
+	defer r.s.locked.Unlock()
+	return fn(r.s.repositories())
+}
+
+type memoryUsers struct{ s *memoryStore }
+
+func (r memoryUsers) Create(ctx context.Context, tenantID string, user *models.User) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.tenants[tenantID]; !ok {
+		return ErrNotFound
+	}
+	for _, u := range r.s.users {
+		if u.ID == user.ID || u.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	if user.Role == "" {
+		user.Role = models.RoleMember
+	}
+	user.TenantID = tenantID
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+func (r memoryUsers) Get(ctx context.Context, tenantID, userID string) (models.User, error) {
+	if tenantID == "" {
+		return models.User{}, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	user, ok := r.s.users[userID]
+	if !ok || user.TenantID != tenantID {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	for _, user := range r.s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r memoryUsers) List(ctx context.Context, tenantID string) ([]models.User, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	users := []models.User{}
+	for _, user := range r.s.users {
+		if user.TenantID == tenantID {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (r memoryUsers) ListByRole(ctx context.Context, tenantID string, role models.Role) ([]models.User, error) {
+	users, err := r.List(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	matching := []models.User{}
+	for _, user := range users {
+		if user.Role == role {
+			matching = append(matching, user)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Email < matching[j].Email })
+	return matching, nil
+}
+
+func (r memoryUsers) Search(ctx context.Context, tenantID string, filter Filter) ([]models.User, int64, error) {
+	if filter.Where != "" {
+		return nil, 0, ErrFilterUnsupported
+	}
+	users, err := r.List(ctx, tenantID)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	start, end := page(len(users), filter)
+	return users[start:end], int64(len(users)), nil
+}
+
+func (r memoryUsers) CountEnabled(ctx context.Context, tenantID string) (int64, error) {
+	if tenantID == "" {
+		return 0, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	var count int64
+	for _, user := range r.s.users {
+		if user.TenantID == tenantID && !user.Disabled {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryUsers) Update(ctx context.Context, tenantID string, user *models.User) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	existing, ok := r.s.users[user.ID]
+	if !ok || existing.TenantID != tenantID || user.TenantID != tenantID {
+		return ErrNotFound
+	}
+	for _, u := range r.s.users {
+		if u.ID != user.ID && u.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+func (r memoryUsers) Delete(ctx context.Context, tenantID, userID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.users[userID]
+	if !ok || user.TenantID != tenantID {
+		return ErrNotFound
+	}
+	delete(r.s.users, userID)
+	for id, member := range r.s.members {
+		if member.UserID == userID {
+			delete(r.s.members, id)
+		}
+	}
+	return nil
+}
+
+type memoryChannels struct{ s *memoryStore }
+
+func (r memoryChannels) Create(ctx context.Context, tenantID string, channel *models.Channel, memberIDs ...string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.tenants[tenantID]; !ok {
+		return ErrNotFound
+	}
+	for _, c := range r.s.channels {
+		if c.ID == channel.ID || c.StreamId == channel.StreamId {
+			return ErrDuplicate
+		}
+	}
+	seen := map[string]bool{}
+	for _, userID := range memberIDs {
+		if user, ok := r.s.users[userID]; !ok || user.TenantID != tenantID {
+			return ErrNotFound
+		}
+		if seen[userID] {
+			return ErrDuplicate
+		}
+		seen[userID] = true
+	}
+	if channel.ID == "" {
+		channel.ID = uuid.New().String()
+	}
+	channel.TenantID = tenantID
+	r.s.channels[channel.ID] = *channel
+	for _, userID := range memberIDs {
+		r.s.addMember(tenantID, channel.ID, userID)
+	}
+	return nil
+}
+
+func (r memoryChannels) Get(ctx context.Context, tenantID, channelID string) (models.Channel, error) {
+	if tenantID == "" {
+		return models.Channel{}, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	channel, ok := r.s.channels[channelID]
+	if !ok || channel.TenantID != tenantID {
+		return models.Channel{}, ErrNotFound
+	}
+	return channel, nil
+}
+
+func (r memoryChannels) GetByStreamID(ctx context.Context, tenantID, streamID string) (models.Channel, error) {
+	if tenantID == "" {
+		return models.Channel{}, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	for _, channel := range r.s.channels {
+		if channel.StreamId == streamID && channel.TenantID == tenantID {
+			return channel, nil
+		}
+	}
+	return models.Channel{}, ErrNotFound
+}
+
+func (r memoryChannels) List(ctx context.Context, tenantID string) ([]models.Channel, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	channels := []models.Channel{}
+	for _, channel := range r.s.channels {
+		if channel.TenantID == tenantID {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ID < channels[j].ID })
+	return channels, nil
+}
+
+func (r memoryChannels) ListByMember(ctx context.Context, tenantID, userID string) ([]models.Channel, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	channels := []models.Channel{}
+	for _, member := range r.s.members {
+		if member.UserID == userID && member.TenantID == tenantID {
+			channels = append(channels, r.s.channels[member.ChannelID])
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ID < channels[j].ID })
+	return channels, nil
+}
+
+func (r memoryChannels) Search(ctx context.Context, tenantID string, filter Filter) ([]models.Channel, int64, error) {
+	if filter.Where != "" {
+		return nil, 0, ErrFilterUnsupported
+	}
+	channels, err := r.List(ctx, tenantID)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+	start, end := page(len(channels), filter)
+	return channels[start:end], int64(len(channels)), nil
+}
+
+func (r memoryChannels) Count(ctx context.Context, tenantID string) (int64, error) {
+	if tenantID == "" {
+		return 0, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	var count int64
+	for _, channel := range r.s.channels {
+		if channel.TenantID == tenantID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryChannels) Update(ctx context.Context, tenantID string, channel *models.Channel) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	existing, ok := r.s.channels[channel.ID]
+	if !ok || existing.TenantID != tenantID || channel.TenantID != tenantID {
+		return ErrNotFound
+	}
+	for _, c := range r.s.channels {
+		if c.ID != channel.ID && c.StreamId == channel.StreamId {
+			return ErrDuplicate
+		}
+	}
+	r.s.channels[channel.ID] = *channel
+	return nil
+}
+
+func (r memoryChannels) Delete(ctx context.Context, tenantID, channelID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	channel, ok := r.s.channels[channelID]
+	if !ok || channel.TenantID != tenantID {
+		return ErrNotFound
+	}
+	delete(r.s.channels, channelID)
+	for id, member := range r.s.members {
+		if member.ChannelID == channelID {
+			delete(r.s.members, id)
+		}
+	}
+	return nil
+}
+
+type memoryMemberships struct{ s *memoryStore }
+
+func (r memoryMemberships) Add(ctx context.Context, tenantID, channelID, userID string) (models.ChannelMember, error) {
+	if tenantID == "" {
+		return models.ChannelMember{}, ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	channel, ok := r.s.channels[channelID]
+	if !ok || channel.TenantID != tenantID {
+		return models.ChannelMember{}, ErrNotFound
+	}
+	user, ok := r.s.users[userID]
+	if !ok || user.TenantID != tenantID {
+		return models.ChannelMember{}, ErrNotFound
+	}
+	for _, member := range r.s.members {
+		if member.ChannelID == channelID && member.UserID == userID {
+			return models.ChannelMember{}, ErrDuplicate
+		}
+	}
+	return r.s.addMember(tenantID, channelID, userID), nil
+}
+
+// addMember records a membership; the caller holds the lock and has checked it
+func (s *memoryStore) addMember(tenantID, channelID, userID string) models.ChannelMember {
+	member := models.ChannelMember{
+		ID:        uuid.New().String(),
+		ChannelID: channelID,
+		UserID:    userID,
+		TenantID:  tenantID,
+		JoinedAt:  time.Now().Unix(),
+	}
+	s.members[member.ID] = member
+	return member
+}
+
+func (r memoryMemberships) Remove(ctx context.Context, tenantID, channelID, userID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for id, member := range r.s.members {
+		if member.ChannelID == channelID && member.UserID == userID && member.TenantID == tenantID {
+			delete(r.s.members, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r memoryMemberships) IsMember(ctx context.Context, tenantID, channelID, userID string) (bool, error) {
+	if tenantID == "" {
+		return false, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	for _, member := range r.s.members {
+		if member.ChannelID == channelID && member.UserID == userID && member.TenantID == tenantID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryMemberships) ListMembers(ctx context.Context, tenantID, channelID string) ([]models.User, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	users := []models.User{}
+	for _, member := range r.s.members {
+		if member.ChannelID == channelID && member.TenantID == tenantID {
+			users = append(users, r.s.users[member.UserID])
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (r memoryMemberships) Count(ctx context.Context, tenantID, channelID string) (int64, error) {
+	if tenantID == "" {
+		return 0, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	var count int64
+	for _, member := range r.s.members {
+		if member.ChannelID == channelID && member.TenantID == tenantID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memoryInvitations struct{ s *memoryStore }
+
+func (r memoryInvitations) Create(ctx context.Context, tenantID string, invitation *models.Invitation) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.tenants[tenantID]; !ok {
+		return ErrNotFound
+	}
+	for _, inv := range r.s.invitations {
+		if inv.ID == invitation.ID || inv.TokenHash == invitation.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	if invitation.ID == "" {
+		invitation.ID = uuid.New().String()
+	}
+	invitation.TenantID = tenantID
+	invitation.CreatedAt = time.Now()
+	r.s.invitations[invitation.ID] = *invitation
+	return nil
+}
+
+func (r memoryInvitations) Accept(ctx context.Context, tenantID, invitationID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	invitation, ok := r.s.invitations[invitationID]
+	if !ok || invitation.TenantID != tenantID || invitation.AcceptedAt != nil || invitation.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	r.s.invitations[invitationID] = invitation
+	return nil
+}
+
+type memoryOIDCConfigs struct{ s *memoryStore }
+
+func (r memoryOIDCConfigs) Get(ctx context.Context, tenantID string) (models.TenantOIDCConfig, error) {
+	if tenantID == "" {
+		return models.TenantOIDCConfig{}, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	config, ok := r.s.oidc[tenantID]
+	if !ok {
+		return models.TenantOIDCConfig{}, ErrNotFound
+	}
+	return config, nil
+}
+
+func (r memoryOIDCConfigs) Save(ctx context.Context, tenantID string, config *models.TenantOIDCConfig) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.tenants[tenantID]; !ok {
+		return ErrNotFound
+	}
+	if config.ID == "" {
+		config.ID = uuid.New().String()
+		config.CreatedAt = time.Now()
+	}
+	config.TenantID = tenantID
+	config.UpdatedAt = time.Now()
+	r.s.oidc[tenantID] = *config
+	return nil
+}
+
+func (r memoryOIDCConfigs) Delete(ctx context.Context, tenantID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	delete(r.s.oidc, tenantID)
+	return nil
+}
+
+type memorySCIMTokens struct{ s *memoryStore }
+
+func (r memorySCIMTokens) Create(ctx context.Context, tenantID string, token *models.SCIMToken) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.tenants[tenantID]; !ok {
+		return ErrNotFound
+	}
+	for _, t := range r.s.scimTokens {
+		if t.ID == token.ID || t.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	token.TenantID = tenantID
+	token.CreatedAt = time.Now()
+	r.s.scimTokens[token.ID] = *token
+	return nil
+}
+
+func (r memorySCIMTokens) GetByHash(ctx context.Context, hash string) (models.SCIMToken, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	for _, token := range r.s.scimTokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return models.SCIMToken{}, ErrNotFound
+}
+
+func (r memorySCIMTokens) List(ctx context.Context, tenantID string) ([]models.SCIMToken, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	tokens := []models.SCIMToken{}
+	for _, token := range r.s.scimTokens {
+		if token.TenantID == tenantID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (r memorySCIMTokens) Touch(ctx context.Context, tenantID, tokenID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	token, ok := r.s.scimTokens[tokenID]
+	if !ok || token.TenantID != tenantID {
+		return ErrNotFound
+	}
+	now := time.Now()
+	token.LastUsedAt = &now
+	r.s.scimTokens[tokenID] = token
+	return nil
+}
+
+func (r memorySCIMTokens) Delete(ctx context.Context, tenantID, tokenID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if token, ok := r.s.scimTokens[tokenID]; ok && token.TenantID == tenantID {
+		delete(r.s.scimTokens, tokenID)
+	}
+	return nil
+}
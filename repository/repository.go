@@ -0,0 +1,158 @@
+// Package repository is how handlers read and write tenants, users, channels,
+// channel memberships, invitations, SSO configurations and SCIM tokens. Every
+// method except those on tenants themselves, UserRepository.GetByEmail and
+// SCIMTokenRepository.GetByHash takes the tenant ID and rejects an empty one,
+// so no query can reach across tenants by forgetting a WHERE clause.
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+)
+
+var (
+	// ErrNotFound is returned when a record does not exist in the tenant
+	ErrNotFound = errors.New("record not found")
+	// ErrTenantRequired is returned when a scoped method is called without a tenant ID
+	ErrTenantRequired = errors.New("tenant ID is required")
+	// ErrDuplicate is returned when a record violates a unique constraint
+	ErrDuplicate = errors.New("record already exists")
+	// ErrFilterUnsupported is returned by the in-memory repositories for a
+	// Filter with a condition
+	ErrFilterUnsupported = errors.New("filter conditions are not supported")
+)
+
+// Filter selects a page of the records matching a condition
+type Filter struct {
+	// Where is a SQL condition on the records' columns with its Args, as
+	// built by services.ParseSCIMFilter; empty matches every record
+	Where string
+	Args  []interface{}
+	// Offset skips that many records and Limit caps how many of the rest
+	// are returned; a negative Limit returns all of them
+	Offset int
+	Limit  int
+}
+
+// TenantRepository stores tenants
+type TenantRepository interface {
+	// Create assigns the tenant an ID
+	Create(ctx context.Context, tenant *models.Tenant) error
+	Get(ctx context.Context, tenantID string) (models.Tenant, error)
+	// List returns every tenant with its user count, ordered by name, for
+	// platform admins
+	List(ctx context.Context) ([]TenantSummary, error)
+	// Update saves the tenant's columns, leaving its domains unchanged
+	Update(ctx context.Context, tenant *models.Tenant) error
+	// Lock runs fn with repositories that share one transaction holding the
+	// tenant's row lock, so that Lock calls for the same tenant run one after
+	// another. The transaction is rolled back when fn returns an error. Plan
+	// limits are checked and enforced under it.
+	Lock(ctx context.Context, tenantID string, fn func(tx Repositories) error) error
+}
+
+// UserRepository stores the users of a tenant
+type UserRepository interface {
+	// Create assigns the user an ID and the tenant
+	Create(ctx context.Context, tenantID string, user *models.User) error
+	Get(ctx context.Context, tenantID, userID string) (models.User, error)
+	// GetByEmail finds a user of any tenant. Emails are unique across
+	// tenants, and signing in has to find the user before the tenant is known.
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	List(ctx context.Context, tenantID string) ([]models.User, error)
+	// ListByRole returns the users with the role, ordered by email
+	ListByRole(ctx context.Context, tenantID string, role models.Role) ([]models.User, error)
+	// Search returns a page of the users matching the filter, ordered by
+	// email, and how many match in total
+	Search(ctx context.Context, tenantID string, filter Filter) ([]models.User, int64, error)
+	// CountEnabled counts the users who are not disabled
+	CountEnabled(ctx context.Context, tenantID string) (int64, error)
+	Update(ctx context.Context, tenantID string, user *models.User) error
+	// Delete removes the user along with their channel memberships
+	Delete(ctx context.Context, tenantID, userID string) error
+}
+
+// ChannelRepository stores the channels of a tenant
+type ChannelRepository interface {
+	// Create assigns the channel an ID and the tenant, and makes memberIDs its
+	// members; the channel is not created when one of them cannot be added
+	Create(ctx context.Context, tenantID string, channel *models.Channel, memberIDs ...string) error
+	Get(ctx context.Context, tenantID, channelID string) (models.Channel, error)
+	GetByStreamID(ctx context.Context, tenantID, streamID string) (models.Channel, error)
+	List(ctx context.Context, tenantID string) ([]models.Channel, error)
+	// ListByMember returns the channels the user is a member of
+	ListByMember(ctx context.Context, tenantID, userID string) ([]models.Channel, error)
+	// Search returns a page of the channels matching the filter, ordered by
+	// name, and how many match in total
+	Search(ctx context.Context, tenantID string, filter Filter) ([]models.Channel, int64, error)
+	Count(ctx context.Context, tenantID string) (int64, error)
+	Update(ctx context.Context, tenantID string, channel *models.Channel) error
+	// Delete removes the channel along with its memberships
+	Delete(ctx context.Context, tenantID, channelID string) error
+}
+
+// MembershipRepository stores who is a member of which channel. The channel
+// and the user must both belong to the tenant.
+type MembershipRepository interface {
+	// Add returns ErrDuplicate when the user already is a member
+	Add(ctx context.Context, tenantID, channelID, userID string) (models.ChannelMember, error)
+	Remove(ctx context.Context, tenantID, channelID, userID string) error
+	IsMember(ctx context.Context, tenantID, channelID, userID string) (bool, error)
+	// ListMembers returns the users who are members of the channel
+	ListMembers(ctx context.Context, tenantID, channelID string) ([]models.User, error)
+	// Count counts the members of the channel
+	Count(ctx context.Context, tenantID, channelID string) (int64, error)
+}
+
+// InvitationRepository stores the invitations of a tenant
+type InvitationRepository interface {
+	// Create assigns the invitation an ID and the tenant
+	Create(ctx context.Context, tenantID string, invitation *models.Invitation) error
+	// Accept marks a pending invitation as accepted. It returns ErrNotFound
+	// when the invitation has been accepted or revoked already.
+	Accept(ctx context.Context, tenantID, invitationID string) error
+}
+
+// OIDCConfigRepository stores the SSO configuration of a tenant, of which
+// there is at most one
+type OIDCConfigRepository interface {
+	Get(ctx context.Context, tenantID string) (models.TenantOIDCConfig, error)
+	// Save creates or replaces the tenant's configuration
+	Save(ctx context.Context, tenantID string, config *models.TenantOIDCConfig) error
+	// Delete succeeds when the tenant has no configuration
+	Delete(ctx context.Context, tenantID string) error
+}
+
+// SCIMTokenRepository stores the SCIM provisioning tokens of a tenant
+type SCIMTokenRepository interface {
+	// Create assigns the token an ID and the tenant
+	Create(ctx context.Context, tenantID string, token *models.SCIMToken) error
+	// GetByHash finds a token of any tenant. The token is all a SCIM client
+	// presents, so the tenant is only known once it is found.
+	GetByHash(ctx context.Context, hash string) (models.SCIMToken, error)
+	// List returns the tenant's tokens, oldest first
+	List(ctx context.Context, tenantID string) ([]models.SCIMToken, error)
+	// Touch records that the token has just been used
+	Touch(ctx context.Context, tenantID, tokenID string) error
+	// Delete succeeds when the tenant has no such token
+	Delete(ctx context.Context, tenantID, tokenID string) error
+}
+
+// TenantSummary is a tenant as listed for platform admins
+type TenantSummary struct {
+	models.Tenant
+	UserCount int64 `json:"user_count"`
+}
+
+// Repositories groups the repositories handed to the handlers
+type Repositories struct {
+	Tenants     TenantRepository
+	Users       UserRepository
+	Channels    ChannelRepository
+	Memberships MembershipRepository
+	Invitations InvitationRepository
+	OIDCConfigs OIDCConfigRepository
+	SCIMTokens  SCIMTokenRepository
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopedMethodsRequireTenant(t *testing.T) {
+	ctx := context.Background()
+	// the GORM repositories must refuse before touching the database
+	for name, repos := range map[string]Repositories{"memory": NewMemory(), "gorm": NewGORM(nil)} {
+		_, err := repos.Users.Get(ctx, "", "user")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Users.List(ctx, "")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Users.Create(ctx, "", &models.User{}), ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Users.Update(ctx, "", &models.User{ID: "user"}), ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Users.Delete(ctx, "", "user"), ErrTenantRequired, name)
+		_, err = repos.Channels.GetByStreamID(ctx, "", "stream")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Channels.List(ctx, "")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Memberships.Add(ctx, "", "channel", "user")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Memberships.IsMember(ctx, "", "channel", "user")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Memberships.ListMembers(ctx, "", "channel")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Channels.Update(ctx, "", &models.Channel{ID: "channel"}), ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Channels.Delete(ctx, "", "channel"), ErrTenantRequired, name)
+		_, err = repos.OIDCConfigs.Get(ctx, "")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.OIDCConfigs.Save(ctx, "", &models.TenantOIDCConfig{}), ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.OIDCConfigs.Delete(ctx, ""), ErrTenantRequired, name)
+		_, err = repos.Tenants.Get(ctx, "")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Tenants.Lock(ctx, "", func(Repositories) error { return nil }), ErrTenantRequired, name)
+		_, err = repos.Users.CountEnabled(ctx, "")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Channels.Count(ctx, "")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Memberships.Count(ctx, "", "channel")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, _, err = repos.Users.Search(ctx, "", Filter{})
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Users.ListByRole(ctx, "", models.RoleAdmin)
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, _, err = repos.Channels.Search(ctx, "", Filter{})
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		_, err = repos.Channels.ListByMember(ctx, "", "user")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Invitations.Create(ctx, "", &models.Invitation{}), ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.Invitations.Accept(ctx, "", "invitation"), ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.SCIMTokens.Create(ctx, "", &models.SCIMToken{}), ErrTenantRequired, name)
+		_, err = repos.SCIMTokens.List(ctx, "")
+		assert.ErrorIs(t, err, ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.SCIMTokens.Touch(ctx, "", "token"), ErrTenantRequired, name)
+		assert.ErrorIs(t, repos.SCIMTokens.Delete(ctx, "", "token"), ErrTenantRequired, name)
+	}
+}
+
+func TestMemoryIsolatesTenants(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemory()
+	one, two := models.Tenant{Name: "One"}, models.Tenant{Name: "Two"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &one))
+	assert.NoError(t, repos.Tenants.Create(ctx, &two))
+	assert.ErrorIs(t, repos.Tenants.Create(ctx, &models.Tenant{Name: "One"}), ErrDuplicate)
+
+	alice := models.User{Email: "alice@one.test", TenantID: two.ID}
+	assert.NoError(t, repos.Users.Create(ctx, one.ID, &alice))
+	assert.Equal(t, one.ID, alice.TenantID, "the tenant argument wins over the field")
+	assert.ErrorIs(t, repos.Users.Create(ctx, two.ID, &models.User{Email: "alice@one.test"}), ErrDuplicate)
+	bob := models.User{Email: "bob@two.test"}
+	assert.NoError(t, repos.Users.Create(ctx, two.ID, &bob))
+
+	_, err := repos.Users.Get(ctx, two.ID, alice.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	users, err := repos.Users.List(ctx, one.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.User{alice}, users)
+	alice.Name = "Alice"
+	assert.ErrorIs(t, repos.Users.Update(ctx, two.ID, &alice), ErrNotFound)
+	assert.NoError(t, repos.Users.Update(ctx, one.ID, &alice))
+
+	channel := models.Channel{StreamId: "general", Name: "General"}
+	assert.NoError(t, repos.Channels.Create(ctx, one.ID, &channel))
+	_, err = repos.Channels.GetByStreamID(ctx, two.ID, "general")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = repos.Memberships.Add(ctx, one.ID, channel.ID, bob.ID)
+	assert.ErrorIs(t, err, ErrNotFound, "users cannot join another tenant's channel")
+	_, err = repos.Memberships.Add(ctx, one.ID, channel.ID, alice.ID)
+	assert.NoError(t, err)
+	_, err = repos.Memberships.Add(ctx, one.ID, channel.ID, alice.ID)
+	assert.ErrorIs(t, err, ErrDuplicate)
+
+	members, err := repos.Memberships.ListMembers(ctx, one.ID, channel.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.User{alice}, members)
+	members, err = repos.Memberships.ListMembers(ctx, two.ID, channel.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+
+	group := models.Channel{StreamId: "group", Name: "Group"}
+	assert.ErrorIs(t, repos.Channels.Create(ctx, one.ID, &group, alice.ID, bob.ID), ErrNotFound)
+	_, err = repos.Channels.GetByStreamID(ctx, one.ID, "group")
+	assert.ErrorIs(t, err, ErrNotFound, "a channel is not created without all of its members")
+	assert.NoError(t, repos.Channels.Create(ctx, one.ID, &group, alice.ID))
+	member, err := repos.Memberships.IsMember(ctx, one.ID, group.ID, alice.ID)
+	assert.NoError(t, err)
+	assert.True(t, member)
+	assert.ErrorIs(t, repos.Channels.Delete(ctx, two.ID, group.ID), ErrNotFound)
+	assert.NoError(t, repos.Channels.Delete(ctx, one.ID, group.ID))
+	member, err = repos.Memberships.IsMember(ctx, one.ID, group.ID, alice.ID)
+	assert.NoError(t, err)
+	assert.False(t, member, "deleting a channel removes its memberships")
+
+	assert.NoError(t, repos.OIDCConfigs.Save(ctx, one.ID, &models.TenantOIDCConfig{Issuer: "https://idp.one.test"}))
+	_, err = repos.OIDCConfigs.Get(ctx, two.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, repos.OIDCConfigs.Delete(ctx, two.ID))
+	config, err := repos.OIDCConfigs.Get(ctx, one.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://idp.one.test", config.Issuer)
+
+	assert.ErrorIs(t, repos.Users.Delete(ctx, two.ID, alice.ID), ErrNotFound)
+	assert.NoError(t, repos.Users.Delete(ctx, one.ID, alice.ID))
+	member, err = repos.Memberships.IsMember(ctx, one.ID, channel.ID, alice.ID)
+	assert.NoError(t, err)
+	assert.False(t, member, "deleting a user removes their memberships")
+}
+
+func TestMemorySearchPages(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemory()
+	tenant := models.Tenant{Name: "One"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &tenant))
+	for _, email := range []string{"carol@one.test", "alice@one.test", "bob@one.test"} {
+		assert.NoError(t, repos.Users.Create(ctx, tenant.ID, &models.User{Email: email}))
+	}
+
+	users, total, err := repos.Users.Search(ctx, tenant.ID, Filter{Offset: 1, Limit: 1})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, total)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "bob@one.test", users[0].Email)
+	}
+	users, total, err = repos.Users.Search(ctx, tenant.ID, Filter{Offset: 5, Limit: -1})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, total)
+	assert.Empty(t, users)
+	_, _, err = repos.Users.Search(ctx, tenant.ID, Filter{Where: "email = ?", Args: []interface{}{"bob@one.test"}})
+	assert.ErrorIs(t, err, ErrFilterUnsupported)
+}
+
+func TestMemoryInvitationsAndSCIMTokens(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemory()
+	one, two := models.Tenant{Name: "One"}, models.Tenant{Name: "Two"}
+	assert.NoError(t, repos.Tenants.Create(ctx, &one))
+	assert.NoError(t, repos.Tenants.Create(ctx, &two))
+
+	invitation := models.Invitation{Email: "carol@one.test", TokenHash: "invitation-hash"}
+	assert.NoError(t, repos.Invitations.Create(ctx, one.ID, &invitation))
+	assert.ErrorIs(t, repos.Invitations.Accept(ctx, two.ID, invitation.ID), ErrNotFound)
+	assert.NoError(t, repos.Invitations.Accept(ctx, one.ID, invitation.ID))
+	assert.ErrorIs(t, repos.Invitations.Accept(ctx, one.ID, invitation.ID), ErrNotFound, "an invitation is accepted once")
+
+	token := models.SCIMToken{Name: "IdP", TokenHash: "token-hash"}
+	assert.NoError(t, repos.SCIMTokens.Create(ctx, one.ID, &token))
+	found, err := repos.SCIMTokens.GetByHash(ctx, "token-hash")
+	assert.NoError(t, err)
+	assert.Equal(t, one.ID, found.TenantID)
+	assert.ErrorIs(t, repos.SCIMTokens.Touch(ctx, two.ID, token.ID), ErrNotFound)
+	assert.NoError(t, repos.SCIMTokens.Delete(ctx, two.ID, token.ID))
+	tokens, err := repos.SCIMTokens.List(ctx, one.ID)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 1, "tokens are only deleted in their tenant")
+	assert.NoError(t, repos.SCIMTokens.Delete(ctx, one.ID, token.ID))
+	_, err = repos.SCIMTokens.GetByHash(ctx, "token-hash")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm"
+)
+
+// Audit actor types
+const (
+	AuditActorUser = "user"
+	AuditActorSCIM = "scim_token"
+	// AuditActorAnonymous is an unauthenticated caller, e.g. a failed login
+	AuditActorAnonymous = "anonymous"
+)
+
+// Audited actions
+const (
+	AuditAuthLogin                = "auth.login"
+	AuditAuthLoginFailed          = "auth.login_failed"
+	AuditAuthLogout               = "auth.logout"
+	AuditAuthRefresh              = "auth.token_refresh"
+	AuditAuthPasswordResetRequest = "auth.password_reset_request"
+	AuditAuthPasswordReset        = "auth.password_reset"
+	AuditAuthEmailVerify          = "auth.email_verify"
+	AuditAuthEmailResend          = "auth.email_verification_resend"
+	AuditAuthOIDCLogin            = "auth.oidc_login"
+
+	AuditUserCreate          = "user.create"
+	AuditUserUpdate          = "user.update"
+	AuditUserDelete          = "user.delete"
+	AuditUserUnlock          = "user.unlock"
+	AuditUserPasswordChange  = "user.password_change"
+	AuditUser2FAEnroll       = "user.2fa_enroll"
+	AuditUser2FAEnable       = "user.2fa_enable"
+	AuditUser2FADisable      = "user.2fa_disable"
+	AuditUserRecoveryCodes   = "user.recovery_codes_regenerate"
+	AuditTenantCreate        = "tenant.create"
+	AuditTenantSecurity      = "tenant.security_update"
+	AuditTenantOIDCUpdate    = "tenant.oidc_update"
+	AuditTenantOIDCDelete    = "tenant.oidc_delete"
+	AuditTenantSettings      = "tenant.settings_update"
+	AuditTenantSuspend       = "tenant.suspend"
+	AuditTenantReactivate    = "tenant.reactivate"
+	AuditTenantPlan          = "tenant.plan_change"
+	AuditPermissionUpdate    = "permission.update"
+	AuditPermissionDelete    = "permission.delete"
+	AuditRoleCreate          = "role.create"
+	AuditRoleUpdate          = "role.update"
+	AuditRoleDelete          = "role.delete"
+	AuditDomainClaim         = "domain.claim"
+	AuditDomainVerify        = "domain.verify"
+	AuditDomainDelete        = "domain.delete"
+	AuditInvitationCreate    = "invitation.create"
+	AuditInvitationResend    = "invitation.resend"
+	AuditInvitationRevoke    = "invitation.revoke"
+	AuditInvitationAccept    = "invitation.accept"
+	AuditSCIMTokenCreate     = "scim_token.create"
+	AuditSCIMTokenDelete     = "scim_token.delete"
+	AuditChannelCreate       = "channel.create"
+	AuditChannelUpdate       = "channel.update"
+	AuditChannelDelete       = "channel.delete"
+	AuditChannelMemberAdd    = "channel.member_add"
+	AuditChannelMemberRemove = "channel.member_remove"
+	AuditChannelJoin         = "channel.join"
+	AuditChannelLeave        = "channel.leave"
+	AuditMessageSend         = "message.send"
+	AuditMessageDelete       = "message.delete"
+)
+
+// Audit target types
+const (
+	AuditTargetUser       = "user"
+	AuditTargetTenant     = "tenant"
+	AuditTargetRole       = "role"
+	AuditTargetDomain     = "domain"
+	AuditTargetInvitation = "invitation"
+	AuditTargetSCIMToken  = "scim_token"
+	AuditTargetChannel    = "channel"
+	AuditTargetMessage    = "message"
+)
+
+const (
+	AuditDefaultPageSize = 50
+	AuditMaxPageSize     = 200
+	auditExportBatch     = 500
+	// AuditRedacted replaces secrets in recorded changes
+	AuditRedacted = "[redacted]"
+)
+
+// auditRedactedFields are never written to the audit log, only that they changed
+var auditRedactedFields = map[string]bool{
+	"password": true, "client_secret": true, "secret": true, "token": true, "refresh_token": true,
+}
+
+// auditIgnoredFields change on every write and would drown out the real changes
+var auditIgnoredFields = map[string]bool{"updated_at": true}
+
+// AuditEntry describes one audited change. Before and After are the target
+// before and after the change (nil on create and delete respectively); only
+// the fields that differ are recorded.
+type AuditEntry struct {
+	TenantID   string
+	ActorID    string
+	ActorType  string
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+	// Changes are recorded in addition to the differences of Before and After
+	Changes   map[string]models.AuditChange
+	IP        string
+	RequestID string
+}
+
+// AuditFilter narrows the audit log. Action may end in ".*" to match a
+// group of actions, e.g. "user.*".
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	RequestID  string
+	From       *time.Time
+	To         *time.Time
+}
+
+// AuditLogPage is one page of audit log entries, newest first
+type AuditLogPage struct {
+	Items    []models.AuditLog `json:"items"`
+	Total    int64             `json:"total"`
+	Page     int               `json:"page"`
+	PageSize int               `json:"page_size"`
+}
+
+// RecordAudit appends an entry to the tenant's audit log
+func RecordAudit(entry AuditEntry) error {
+	changes := AuditDiff(entry.Before, entry.After)
+	for key, change := range entry.Changes {
+		if changes == nil {
+			changes = map[string]models.AuditChange{}
+		}
+		changes[key] = change
+	}
+	changes, err := normalizeAuditChanges(changes)
+	if err != nil {
+		return err
+	}
+	record := models.AuditLog{
+		TenantID:   entry.TenantID,
+		ActorID:    entry.ActorID,
+		ActorType:  entry.ActorType,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Changes:    changes,
+		IP:         entry.IP,
+		RequestID:  entry.RequestID,
+	}
+	return appendAuditLog(&record)
+}
+
+// AuditDiff returns the JSON fields that differ between before and after,
+// with secrets redacted
+func AuditDiff(before, after interface{}) map[string]models.AuditChange {
+	b, a := auditFields(before), auditFields(after)
+	changes := map[string]models.AuditChange{}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			a[key] = nil
+		}
+	}
+	for key, afterValue := range a {
+		beforeValue := b[key]
+		if auditIgnoredFields[key] || reflect.DeepEqual(beforeValue, afterValue) {
+			continue
+		}
+		if auditRedactedFields[key] {
+			beforeValue, afterValue = redactAuditValue(beforeValue), redactAuditValue(afterValue)
+		}
+		changes[key] = models.AuditChange{Before: beforeValue, After: afterValue}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// ListAuditLogs returns a page (1-based) of the tenant's audit log
+func ListAuditLogs(tenantID string, filter AuditFilter, page, pageSize int) (AuditLogPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = AuditDefaultPageSize
+	}
+	if pageSize > AuditMaxPageSize {
+		pageSize = AuditMaxPageSize
+	}
+	result := AuditLogPage{Items: []models.AuditLog{}, Page: page, PageSize: pageSize}
+	// a new session, so that counting does not leak into the page query
+	query := filter.apply(db.DB.Model(&models.AuditLog{}).Where("tenant_id = ?", tenantID)).Session(&gorm.Session{})
+	if err := query.Count(&result.Total).Error; err != nil {
+		return result, err
+	}
+	err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&result.Items).Error
+	return result, err
+}
+
+// ExportAuditLogs calls fn with every matching entry of the tenant's audit
+// log, oldest first, reading the log in batches
+func ExportAuditLogs(tenantID string, filter AuditFilter, fn func(models.AuditLog) error) error {
+	var last *models.AuditLog
+	for {
+		query := filter.apply(db.DB.Where("tenant_id = ?", tenantID))
+		if last != nil {
+			query = query.Where("(created_at, id) > (?, ?)", last.CreatedAt, last.ID)
+		}
+		var batch []models.AuditLog
+		if err := query.Order("created_at, id").Limit(auditExportBatch).Find(&batch).Error; err != nil {
+			return err
+		}
+		for _, entry := range batch {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if len(batch) < auditExportBatch {
+			return nil
+		}
+		last = &batch[len(batch)-1]
+	}
+}
+
+func (f AuditFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.ActorID != "" {
+		query = query.Where("actor_id = ?", f.ActorID)
+	}
+	if prefix, ok := strings.CutSuffix(f.Action, "*"); ok {
+		query = query.Where("action LIKE ?", strings.ReplaceAll(prefix, "%", `\%`)+"%")
+	} else if f.Action != "" {
+		query = query.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		query = query.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != "" {
+		query = query.Where("target_id = ?", f.TargetID)
+	}
+	if f.RequestID != "" {
+		query = query.Where("request_id = ?", f.RequestID)
+	}
+	if f.From != nil {
+		query = query.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		query = query.Where("created_at < ?", *f.To)
+	}
+	return query
+}
+
+// auditFields flattens v into its top-level JSON fields; values that are not
+// JSON objects are recorded under "value"
+func auditFields(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		var value interface{}
+		_ = json.Unmarshal(raw, &value)
+		return map[string]interface{}{"value": value}
+	}
+	return fields
+}
+
+func redactAuditValue(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return AuditRedacted
+}
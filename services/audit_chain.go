@@ -0,0 +1,211 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// auditVerifyBatch is how many entries verification reads at a time
+const auditVerifyBatch = 1000
+
+// AuditChainBreak is the first place where a tenant's audit chain does not
+// hold together
+type AuditChainBreak struct {
+	Seq     int64  `json:"seq"`
+	EntryID string `json:"entry_id,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// AuditVerification is the outcome of walking a tenant's audit chain
+type AuditVerification struct {
+	TenantID string `json:"tenant_id"`
+	Valid    bool   `json:"valid"`
+	Entries  int64  `json:"entries"`
+	HeadSeq  int64  `json:"head_seq"`
+	HeadHash string `json:"head_hash"`
+	// Checkpoints is how many checkpoints matched the chain
+	Checkpoints int              `json:"checkpoints"`
+	Break       *AuditChainBreak `json:"break,omitempty"`
+}
+
+// auditHashInput is what an entry's hash covers, in a fixed field order
+type auditHashInput struct {
+	TenantID   string                        `json:"tenant_id"`
+	Seq        int64                         `json:"seq"`
+	PrevHash   string                        `json:"prev_hash"`
+	ID         string                        `json:"id"`
+	CreatedAt  string                        `json:"created_at"`
+	ActorID    string                        `json:"actor_id"`
+	ActorType  string                        `json:"actor_type"`
+	Action     string                        `json:"action"`
+	TargetType string                        `json:"target_type"`
+	TargetID   string                        `json:"target_id"`
+	Changes    map[string]models.AuditChange `json:"changes"`
+	IP         string                        `json:"ip"`
+	RequestID  string                        `json:"request_id"`
+}
+
+// AuditHash returns the hex SHA-256 of the entry's contents and PrevHash
+func AuditHash(entry models.AuditLog) string {
+	raw, _ := json.Marshal(auditHashInput{
+		TenantID:   entry.TenantID,
+		Seq:        entry.Seq,
+		PrevHash:   entry.PrevHash,
+		ID:         entry.ID,
+		CreatedAt:  entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ActorID:    entry.ActorID,
+		ActorType:  entry.ActorType,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Changes:    entry.Changes,
+		IP:         entry.IP,
+		RequestID:  entry.RequestID,
+	})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// appendAuditLog chains record onto its tenant's audit log
+func appendAuditLog(record *models.AuditLog) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.AuditChainHead{TenantID: record.TenantID}).Error; err != nil {
+			return err
+		}
+		var head models.AuditChainHead
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("tenant_id = ?", record.TenantID).First(&head).Error; err != nil {
+			return err
+		}
+
+		record.ID = uuid.New().String()
+		// the database keeps microseconds; hashing more would break verification
+		record.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		record.Seq = head.Seq + 1
+		record.PrevHash = head.Hash
+		record.Hash = AuditHash(*record)
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return tx.Model(&head).Updates(map[string]interface{}{"seq": record.Seq, "hash": record.Hash, "updated_at": record.CreatedAt}).Error
+	})
+}
+
+// normalizeAuditChanges gives changes the shape they have when read back from
+// the database, so that the hash computed now matches the one verified later
+func normalizeAuditChanges(changes map[string]models.AuditChange) (map[string]models.AuditChange, error) {
+	if changes == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]models.AuditChange
+	err = json.Unmarshal(raw, &normalized)
+	return normalized, err
+}
+
+// checkAuditLink checks that entry follows the entry with seq prevSeq and
+// hash prevHash and that its contents match its hash
+func checkAuditLink(prevSeq int64, prevHash string, entry models.AuditLog) *AuditChainBreak {
+	switch {
+	case entry.Seq != prevSeq+1:
+		return &AuditChainBreak{Seq: prevSeq + 1, Reason: fmt.Sprintf("entry %d is missing", prevSeq+1)}
+	case entry.PrevHash != prevHash:
+		return &AuditChainBreak{Seq: entry.Seq, EntryID: entry.ID, Reason: fmt.Sprintf("entry does not link to entry %d", prevSeq)}
+	case AuditHash(entry) != entry.Hash:
+		return &AuditChainBreak{Seq: entry.Seq, EntryID: entry.ID, Reason: "entry was altered"}
+	}
+	return nil
+}
+
+// VerifyAuditChain walks the tenant's audit chain from the first entry and
+// reports the first broken link. The chain is also checked against the
+// tenant's stored checkpoints and against trusted, e.g. checkpoints exported
+// earlier and kept elsewhere. Checkpoints must be signed by the current
+// signer or one of keys, e.g. a retired key published with an export.
+func VerifyAuditChain(tenantID string, trusted []models.AuditCheckpoint, keys []AuditPublicKey) (AuditVerification, error) {
+	result := AuditVerification{TenantID: tenantID}
+	keys = append([]AuditPublicKey{GetAuditSigner().PublicKey()}, keys...)
+
+	var stored []models.AuditCheckpoint
+	if err := db.DB.Where("tenant_id = ?", tenantID).Order("seq").Find(&stored).Error; err != nil {
+		return result, err
+	}
+	checkpoints := map[int64][]models.AuditCheckpoint{}
+	for _, cp := range append(stored, trusted...) {
+		if cp.TenantID != tenantID {
+			continue
+		}
+		if err := VerifyAuditCheckpoint(cp, keys...); err != nil {
+			result.Break = &AuditChainBreak{Seq: cp.Seq, Reason: fmt.Sprintf("checkpoint at entry %d: %v", cp.Seq, err)}
+			return result, nil
+		}
+		checkpoints[cp.Seq] = append(checkpoints[cp.Seq], cp)
+	}
+
+	var prevSeq int64
+	var prevHash string
+	for {
+		var batch []models.AuditLog
+		if err := db.DB.Where("tenant_id = ? AND seq > ?", tenantID, prevSeq).Order("seq").Limit(auditVerifyBatch).Find(&batch).Error; err != nil {
+			return result, err
+		}
+		for _, entry := range batch {
+			if brk := checkAuditLink(prevSeq, prevHash, entry); brk != nil {
+				result.Break = brk
+				return result, nil
+			}
+			for _, cp := range checkpoints[entry.Seq] {
+				if cp.Hash != entry.Hash {
+					result.Break = &AuditChainBreak{Seq: entry.Seq, EntryID: entry.ID, Reason: fmt.Sprintf("entry differs from checkpoint signed at %s", cp.CreatedAt.UTC().Format(time.RFC3339))}
+					return result, nil
+				}
+				result.Checkpoints++
+			}
+			prevSeq, prevHash = entry.Seq, entry.Hash
+			result.Entries++
+		}
+		if len(batch) < auditVerifyBatch {
+			break
+		}
+	}
+	result.HeadSeq, result.HeadHash = prevSeq, prevHash
+
+	// entries cut off the end of the chain still show in its head and checkpoints
+	var head models.AuditChainHead
+	err := db.DB.Where("tenant_id = ?", tenantID).Limit(1).Find(&head).Error
+	if err != nil {
+		return result, err
+	}
+	if head.Seq > prevSeq {
+		result.Break = &AuditChainBreak{Seq: prevSeq + 1, Reason: fmt.Sprintf("entries %d to %d are missing", prevSeq+1, head.Seq)}
+		return result, nil
+	}
+	if head.Seq == prevSeq && head.Hash != prevHash {
+		result.Break = &AuditChainBreak{Seq: prevSeq, Reason: "the chain head does not match the last entry"}
+		return result, nil
+	}
+	var lastCheckpoint int64
+	for seq := range checkpoints {
+		if seq > lastCheckpoint {
+			lastCheckpoint = seq
+		}
+	}
+	if lastCheckpoint > prevSeq {
+		result.Break = &AuditChainBreak{Seq: prevSeq + 1, Reason: fmt.Sprintf("entries %d to %d are missing", prevSeq+1, lastCheckpoint)}
+		return result, nil
+	}
+	result.Valid = true
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func chainedAuditEntries(n int) []models.AuditLog {
+	entries := make([]models.AuditLog, n)
+	prevHash := ""
+	for i := range entries {
+		entries[i] = models.AuditLog{ID: string(rune('a' + i)), TenantID: "t1", Seq: int64(i + 1), PrevHash: prevHash,
+			Action: AuditUserUpdate, CreatedAt: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC)}
+		entries[i].Hash = AuditHash(entries[i])
+		prevHash = entries[i].Hash
+	}
+	return entries
+}
+
+func TestCheckAuditLinkAcceptsIntactChain(t *testing.T) {
+	var prevSeq int64
+	prevHash := ""
+	for _, entry := range chainedAuditEntries(3) {
+		assert.Nil(t, checkAuditLink(prevSeq, prevHash, entry))
+		prevSeq, prevHash = entry.Seq, entry.Hash
+	}
+}
+
+func TestCheckAuditLinkReportsBreaks(t *testing.T) {
+	entries := chainedAuditEntries(3)
+
+	altered := entries[1]
+	altered.Action = AuditUserDelete
+	brk := checkAuditLink(1, entries[0].Hash, altered)
+	assert.Equal(t, int64(2), brk.Seq)
+	assert.Equal(t, "entry was altered", brk.Reason)
+
+	brk = checkAuditLink(1, entries[0].Hash, entries[2])
+	assert.Equal(t, int64(2), brk.Seq, "a removed entry leaves a gap")
+
+	relinked := entries[2]
+	relinked.Seq = 2
+	relinked.Hash = AuditHash(relinked)
+	brk = checkAuditLink(1, entries[0].Hash, relinked)
+	assert.Equal(t, "entry does not link to entry 1", brk.Reason)
+}
+
+func TestAuditHashSurvivesStorageRoundTrip(t *testing.T) {
+	changes, err := normalizeAuditChanges(map[string]models.AuditChange{
+		"members":    {After: []string{"u1", "u2"}},
+		"operations": {After: json.RawMessage(`{"op":"add","value":1}`)},
+	})
+	assert.NoError(t, err)
+	entry := models.AuditLog{ID: "a", TenantID: "t1", Seq: 1, Changes: changes, CreatedAt: time.Now().Truncate(time.Microsecond)}
+	hash := AuditHash(entry)
+
+	raw, _ := json.Marshal(entry.Changes)
+	entry.Changes = nil
+	assert.NoError(t, json.Unmarshal(raw, &entry.Changes))
+	entry.CreatedAt = entry.CreatedAt.In(time.FixedZone("EAT", 3*3600))
+	assert.Equal(t, hash, AuditHash(entry))
+}
+
+func TestAuditCheckpointSignature(t *testing.T) {
+	signer, err := NewAuditSigner(make([]byte, 32))
+	assert.NoError(t, err)
+	cp := signer.Sign("t1", 42, "abc", time.Now())
+	assert.NoError(t, VerifyAuditCheckpoint(cp, signer.PublicKey()))
+	assert.Equal(t, signer.KeyID, cp.KeyID)
+
+	forged := cp
+	forged.Hash = "def"
+	assert.ErrorIs(t, VerifyAuditCheckpoint(forged, signer.PublicKey()), ErrInvalidCheckpoint)
+
+	// a checkpoint re-signed with another key and carrying it is still refused
+	seed := make([]byte, 32)
+	seed[0] = 1
+	other, err := NewAuditSigner(seed)
+	assert.NoError(t, err)
+	resigned := other.Sign("t1", 42, "def", time.Now())
+	assert.ErrorIs(t, VerifyAuditCheckpoint(resigned, signer.PublicKey()), ErrUnknownAuditKey)
+	resigned.KeyID = signer.KeyID
+	assert.ErrorIs(t, VerifyAuditCheckpoint(resigned, signer.PublicKey()), ErrInvalidCheckpoint)
+
+	_, err = NewAuditSigner([]byte("short"))
+	assert.ErrorIs(t, err, ErrInvalidAuditSigningKey)
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidAuditSigningKey = errors.New("AUDIT_SIGNING_KEY must be a base64 Ed25519 seed of 32 bytes")
+	ErrInvalidCheckpoint      = errors.New("invalid checkpoint signature")
+	ErrUnknownAuditKey        = errors.New("checkpoint signed with an unknown key")
+)
+
+// AuditSigner signs audit checkpoints with an Ed25519 key
+type AuditSigner struct {
+	KeyID string
+	key   ed25519.PrivateKey
+}
+
+// AuditPublicKey is what verifiers need to check checkpoint signatures
+type AuditPublicKey struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
+
+// AuditCheckpointExport is a tenant's signed checkpoints together with the
+// key currently signing them
+type AuditCheckpointExport struct {
+	TenantID    string                   `json:"tenant_id"`
+	SigningKey  AuditPublicKey           `json:"signing_key"`
+	Checkpoints []models.AuditCheckpoint `json:"checkpoints"`
+}
+
+// NewAuditSigner derives a signer from a 32 byte seed
+func NewAuditSigner(seed []byte) (*AuditSigner, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidAuditSigningKey
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return &AuditSigner{KeyID: hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+// PublicKey returns the key that verifies this signer's checkpoints
+func (s *AuditSigner) PublicKey() AuditPublicKey {
+	return AuditPublicKey{
+		KeyID:     s.KeyID,
+		Algorithm: "Ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+	}
+}
+
+// Sign returns a checkpoint of the chain head (seq, hash) signed at now
+func (s *AuditSigner) Sign(tenantID string, seq int64, hash string, now time.Time) models.AuditCheckpoint {
+	cp := models.AuditCheckpoint{
+		TenantID:  tenantID,
+		Seq:       seq,
+		Hash:      hash,
+		KeyID:     s.KeyID,
+		PublicKey: s.PublicKey().PublicKey,
+		CreatedAt: now.UTC().Truncate(time.Microsecond),
+	}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, auditCheckpointMessage(cp)))
+	return cp
+}
+
+// VerifyAuditCheckpoint checks the checkpoint's signature against the key of
+// keys with its KeyID. The public key stored with the checkpoint is not used:
+// whoever can rewrite the checkpoint can replace it too.
+func VerifyAuditCheckpoint(cp models.AuditCheckpoint, keys ...AuditPublicKey) error {
+	for _, key := range keys {
+		if key.KeyID != cp.KeyID {
+			continue
+		}
+		publicKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return ErrInvalidCheckpoint
+		}
+		signature, err := base64.StdEncoding.DecodeString(cp.Signature)
+		if err != nil || !ed25519.Verify(publicKey, auditCheckpointMessage(cp), signature) {
+			return ErrInvalidCheckpoint
+		}
+		return nil
+	}
+	return ErrUnknownAuditKey
+}
+
+// auditCheckpointMessage is the signed content of a checkpoint
+func auditCheckpointMessage(cp models.AuditCheckpoint) []byte {
+	return []byte(fmt.Sprintf("chat-app audit checkpoint\n%s\n%d\n%s\n%s",
+		cp.TenantID, cp.Seq, cp.Hash, cp.CreatedAt.UTC().Format(time.RFC3339Nano)))
+}
+
+var auditSigner *AuditSigner
+var auditSignerOnce sync.Once
+
+// GetAuditSigner returns the signer configured by AUDIT_SIGNING_KEY. Without
+// it, outside release mode, a key is generated at startup, so checkpoints of
+// earlier runs no longer verify.
+func GetAuditSigner() *AuditSigner {
+	auditSignerOnce.Do(func() {
+		if auditSigner != nil {
+			return
+		}
+		if value := os.Getenv("AUDIT_SIGNING_KEY"); value != "" {
+			seed, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				slog.Error(ErrInvalidAuditSigningKey.Error())
+				os.Exit(1)
+			}
+			if auditSigner, err = NewAuditSigner(seed); err != nil {
+				slog.Error("invalid audit signing key", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+		slog.Warn("AUDIT_SIGNING_KEY not set, signing audit checkpoints with a generated key")
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			slog.Error("failed to generate audit signing key", "error", err)
+			os.Exit(1)
+		}
+		auditSigner, _ = NewAuditSigner(seed)
+	})
+	return auditSigner
+}
+
+// SetAuditSigner overrides the signer, e.g. in tests
+func SetAuditSigner(s *AuditSigner) {
+	auditSigner = s
+}
+
+// CreateAuditCheckpoints signs a checkpoint for every tenant whose audit
+// chain grew since its last checkpoint and returns how many were created.
+// Instances running this at the same time create each checkpoint once.
+func CreateAuditCheckpoints() (int, error) {
+	var heads []models.AuditChainHead
+	err := db.DB.Where("seq > 0 AND seq > COALESCE((SELECT MAX(seq) FROM audit_checkpoints WHERE audit_checkpoints.tenant_id = audit_chain_heads.tenant_id), 0)").
+		Find(&heads).Error
+	if err != nil {
+		return 0, err
+	}
+	signer := GetAuditSigner()
+	created := 0
+	for _, head := range heads {
+		cp := signer.Sign(head.TenantID, head.Seq, head.Hash, time.Now())
+		result := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&cp)
+		if result.Error != nil {
+			return created, result.Error
+		}
+		created += int(result.RowsAffected)
+	}
+	return created, nil
+}
+
+// ListAuditCheckpoints returns the tenant's checkpoints, oldest first
+func ListAuditCheckpoints(tenantID string) (AuditCheckpointExport, error) {
+	export := AuditCheckpointExport{TenantID: tenantID, SigningKey: GetAuditSigner().PublicKey(), Checkpoints: []models.AuditCheckpoint{}}
+	err := db.DB.Where("tenant_id = ?", tenantID).Order("seq").Find(&export.Checkpoints).Error
+	return export, err
+}
+
+// CreateAuditCheckpointsPeriodically signs checkpoints every interval
+func CreateAuditCheckpointsPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		if n, err := CreateAuditCheckpoints(); err != nil {
+			slog.Error("failed to create audit checkpoints", "error", err)
+		} else if n > 0 {
+			slog.Info("signed audit checkpoints", "count", n, "key_id", GetAuditSigner().KeyID)
+		}
+	}
+}
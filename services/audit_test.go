@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditDiffRecordsOnlyChangedFields(t *testing.T) {
+	before := models.Channel{ID: "c1", Name: "general", TenantID: "t1"}
+	after := before
+	after.Name = "announcements"
+
+	changes := AuditDiff(before, after)
+	assert.Equal(t, map[string]models.AuditChange{"name": {Before: "general", After: "announcements"}}, changes)
+	assert.Nil(t, AuditDiff(before, before))
+}
+
+func TestAuditDiffRedactsSecretsAndIgnoresTimestamps(t *testing.T) {
+	before := map[string]interface{}{"client_secret": "old", "updated_at": "2024-01-01"}
+	after := map[string]interface{}{"client_secret": "new", "updated_at": "2024-01-02"}
+
+	changes := AuditDiff(before, after)
+	assert.Equal(t, map[string]models.AuditChange{"client_secret": {Before: AuditRedacted, After: AuditRedacted}}, changes)
+}
+
+func TestAuditDiffCreateAndDelete(t *testing.T) {
+	var none *models.Channel
+	channel := models.Channel{ID: "c1", Name: "general"}
+
+	created := AuditDiff(none, channel)
+	assert.Equal(t, models.AuditChange{After: "general"}, created["name"])
+
+	deleted := AuditDiff(channel, nil)
+	assert.Equal(t, models.AuditChange{Before: "general"}, deleted["name"])
+}
@@ -3,63 +3,80 @@ package services
 import (
 	"context"
 	"errors"
+	"time"
 
-	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/metrics"
 	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
 )
 
 const QueryByIDAndTenantIdLiteral = "id = ?::uuid AND tenant_id = ?"
 
-func AddUserToChannel(channelID, userID, tenantID string) error {
-	var channel models.Channel
-	if err := db.DB.Where(QueryByIDAndTenantIdLiteral, channelID, tenantID).First(&channel).Error; err != nil {
+var ErrAlreadyMember = errors.New("user already in channel")
+
+func AddUserToChannel(ctx context.Context, repos repository.Repositories, channelID, userID, tenantID string) error {
+	channel, err := repos.Channels.Get(ctx, tenantID, channelID)
+	if err != nil {
 		return errors.New("channel not found or access denied")
 	}
 
-	var user models.User
-	if err := db.DB.Where(QueryByIDAndTenantIdLiteral, userID, tenantID).First(&user).Error; err != nil {
+	user, err := repos.Users.Get(ctx, tenantID, userID)
+	if err != nil {
 		return errors.New("user not found or access denied")
 	}
 
-	var existing models.ChannelMember
-	if err := db.DB.Where("channel_id = ? AND user_id = ?", channelID, userID).First(&existing).Error; err == nil {
-		return errors.New("user already in channel")
+	if err := checkEmailVerifiedForChannels(user); err != nil {
+		return err
 	}
 
-	member := models.ChannelMember{
-		ChannelID: channelID,
-		UserID:    userID,
-		TenantID:  tenantID,
+	if member, err := repos.Memberships.IsMember(ctx, tenantID, channelID, userID); err != nil {
+		return err
+	} else if member {
+		return ErrAlreadyMember
 	}
-
-	if err := db.DB.Create(&member).Error; err != nil {
+	err = repos.Tenants.Lock(ctx, tenantID, func(tx repository.Repositories) error {
+		if err := CheckChannelMemberQuota(ctx, tx, tenantID, channelID, 1); err != nil {
+			return err
+		}
+		_, err := tx.Memberships.Add(ctx, tenantID, channelID, userID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return ErrAlreadyMember
+		}
 		return err
 	}
 
 	client := GetStreamClient()
 	ch := client.Channel("messaging", channel.StreamId)
-	_, err := ch.AddMembers(context.Background(), []string{userID})
+	start := time.Now()
+	_, err = ch.AddMembers(ctx, []string{userID})
+	metrics.ObserveStreamCall(metrics.StreamAddMembers, start, err)
 	if err != nil {
-		db.DB.Delete(&member)
+		repos.Memberships.Remove(ctx, tenantID, channelID, userID)
 		return errors.New("failed to add user to stream channel: " + err.Error())
 	}
 
 	return nil
 }
 
-func RemoveUserFromChannel(channelID, userID, tenantID string) error {
-	var channel models.Channel
-	if err := db.DB.Where(QueryByIDAndTenantIdLiteral, channelID, tenantID).First(&channel).Error; err != nil {
+func RemoveUserFromChannel(ctx context.Context, repos repository.Repositories, channelID, userID, tenantID string) error {
+	channel, err := repos.Channels.Get(ctx, tenantID, channelID)
+	if err != nil {
 		return errors.New("channel not found or access denied")
 	}
 
-	if err := db.DB.Where("channel_id = ? AND user_id = ? AND tenant_id = ?", channelID, userID, tenantID).Delete(&models.ChannelMember{}).Error; err != nil {
+	// removing a user who is not a member still removes them on Stream
+	if err := repos.Memberships.Remove(ctx, tenantID, channelID, userID); err != nil && !errors.Is(err, repository.ErrNotFound) {
 		return err
 	}
 
 	client := GetStreamClient()
 	ch := client.Channel("messaging", channel.StreamId)
-	_, err := ch.RemoveMembers(context.Background(), []string{userID}, nil)
+	start := time.Now()
+	_, err = ch.RemoveMembers(ctx, []string{userID}, nil)
+	metrics.ObserveStreamCall(metrics.StreamRemoveMembers, start, err)
 	if err != nil {
 		return errors.New("failed to remove user from stream channel: " + err.Error())
 	}
@@ -67,17 +84,71 @@ func RemoveUserFromChannel(channelID, userID, tenantID string) error {
 	return nil
 }
 
-func IsUserChannelMember(channelID, userID, tenantID string) bool {
-	var count int64
-	db.DB.Model(&models.ChannelMember{}).Where("channel_id = ? AND user_id = ? AND tenant_id = ?", channelID, userID, tenantID).Count(&count)
-	return count > 0
+func GetChannelMembers(ctx context.Context, repos repository.Repositories, channelID, tenantID string) ([]models.User, error) {
+	return repos.Memberships.ListMembers(ctx, tenantID, channelID)
 }
 
-func GetChannelMembers(channelID, tenantID string) ([]models.User, error) {
-	var users []models.User
-	err := db.DB.Table("users").
-		Joins("JOIN channel_members ON users.id::text = channel_members.user_id").
-		Where("channel_members.channel_id = ? AND channel_members.tenant_id = ?", channelID, tenantID).
-		Find(&users).Error
-	return users, err
+// CreateGroupChannel creates a channel on Stream and in the database whose
+// members are exactly memberIDs. creatorID is recorded as the creator only.
+// The Stream channel is created with the tenant locked, so that a channel
+// over the plan's limit never reaches Stream.
+func CreateGroupChannel(ctx context.Context, repos repository.Repositories, channel models.Channel, creatorID string, memberIDs []string) (models.Channel, error) {
+	for _, userID := range memberIDs {
+		if _, err := repos.Users.Get(ctx, channel.TenantID, userID); err != nil {
+			return channel, errors.New("user not found or access denied")
+		}
+	}
+
+	err := repos.Tenants.Lock(ctx, channel.TenantID, func(tx repository.Repositories) error {
+		if err := CheckChannelQuota(ctx, tx, channel.TenantID); err != nil {
+			return err
+		}
+		if err := CheckChannelMemberQuota(ctx, tx, channel.TenantID, "", int64(len(memberIDs))); err != nil {
+			return err
+		}
+		streamChannelID, err := CreateStreamChannelWithMembers(ctx, channel, creatorID, memberIDs)
+		if err != nil {
+			return err
+		}
+		channel.StreamId = streamChannelID
+		channel.CreatedBy = creatorID
+		return tx.Channels.Create(ctx, channel.TenantID, &channel, memberIDs...)
+	})
+	return channel, err
+}
+
+// RenameChannel updates the channel name in the database and on Stream
+func RenameChannel(ctx context.Context, repos repository.Repositories, channel models.Channel, name string) error {
+	channel.Name = name
+	if err := repos.Channels.Update(ctx, channel.TenantID, &channel); err != nil {
+		return err
+	}
+	ch := GetStreamClient().Channel("messaging", channel.StreamId)
+	start := time.Now()
+	_, err := ch.Update(ctx, map[string]interface{}{"name": name}, nil)
+	metrics.ObserveStreamCall(metrics.StreamUpdateChannel, start, err)
+	if err != nil {
+		return errors.New("failed to rename stream channel: " + err.Error())
+	}
+	return nil
+}
+
+// DeleteChannel removes a channel, its memberships and the Stream channel
+func DeleteChannel(ctx context.Context, repos repository.Repositories, channelID, tenantID string) error {
+	channel, err := repos.Channels.Get(ctx, tenantID, channelID)
+	if err != nil {
+		return errors.New("channel not found or access denied")
+	}
+	if err := repos.Channels.Delete(ctx, tenantID, channel.ID); err != nil {
+		return err
+	}
+
+	ch := GetStreamClient().Channel("messaging", channel.StreamId)
+	start := time.Now()
+	_, err = ch.Delete(ctx)
+	metrics.ObserveStreamCall(metrics.StreamDeleteChannel, start, err)
+	if err != nil {
+		return errors.New("failed to delete stream channel: " + err.Error())
+	}
+	return nil
 }
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+)
+
+const (
+	// DomainChallengePrefix is prepended to the domain to name the TXT record
+	DomainChallengePrefix = "_chat-app-challenge."
+	// DomainChallengeValuePrefix is prepended to the token in the TXT record
+	DomainChallengeValuePrefix = "chat-app-verification="
+	domainLookupTimeout        = 5 * time.Second
+)
+
+var (
+	ErrInvalidDomain            = errors.New("invalid domain")
+	ErrPublicEmailDomain        = errors.New("public email domains cannot be claimed")
+	ErrDomainNotFound           = errors.New("domain not found")
+	ErrDomainVerifiedElsewhere  = errors.New("domain is already verified by another organization")
+	ErrDomainVerificationFailed = errors.New("verification TXT record not found")
+	ErrOrgNameRequired          = errors.New("organization name is required")
+)
+
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// publicEmailDomains are shared by unrelated people, so nobody may claim them
+var publicEmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "outlook.com": true, "hotmail.com": true,
+	"live.com": true, "msn.com": true, "yahoo.com": true, "icloud.com": true, "me.com": true,
+	"aol.com": true, "proton.me": true, "protonmail.com": true, "gmx.com": true,
+	"yandex.com": true, "mail.com": true, "zoho.com": true,
+}
+
+// TXTResolver looks up DNS TXT records
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// StaticTXTResolver answers from a fixed set of records. It is meant for
+// local development, where the claimed domain's DNS cannot be edited.
+type StaticTXTResolver map[string][]string
+
+func (r StaticTXTResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[strings.TrimSuffix(strings.ToLower(name), ".")]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+// ParseStaticTXTRecords parses "name=value;name=value" into a StaticTXTResolver
+func ParseStaticTXTRecords(s string) StaticTXTResolver {
+	r := StaticTXTResolver{}
+	for _, entry := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		r[name] = append(r[name], value)
+	}
+	return r
+}
+
+// txtResolver queries DNS unless SetTXTResolver sets another one
+var txtResolver TXTResolver = net.DefaultResolver
+
+// GetTXTResolver returns the configured resolver
+func GetTXTResolver() TXTResolver {
+	return txtResolver
+}
+
+// SetTXTResolver sets the resolver, e.g. a StaticTXTResolver when DNS_RESOLVER
+// is static, or a fake one in tests
+func SetTXTResolver(r TXTResolver) {
+	txtResolver = r
+}
+
+// NormalizeDomain lowercases a domain and checks that it is a plausible
+// registrable name, e.g. "@Example.COM." becomes "example.com"
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@"), ".")
+	if len(domain) > 253 || !domainPattern.MatchString(domain) {
+		return "", ErrInvalidDomain
+	}
+	return domain, nil
+}
+
+// EmailDomain returns the normalized domain part of an email address
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	domain, err := NormalizeDomain(email[at+1:])
+	if err != nil {
+		return ""
+	}
+	return domain
+}
+
+// DomainChallenge returns the TXT record the tenant must publish to prove it
+// controls the domain
+func DomainChallenge(claim models.TenantDomain) (name, value string) {
+	return DomainChallengePrefix + claim.Domain, DomainChallengeValuePrefix + claim.VerificationToken
+}
+
+// ListTenantDomains lists the domains claimed by a tenant
+func ListTenantDomains(tenantID string) ([]models.TenantDomain, error) {
+	var domains []models.TenantDomain
+	err := db.DB.Where("tenant_id = ?", tenantID).Order("domain").Find(&domains).Error
+	return domains, err
+}
+
+// ClaimDomain records a tenant's claim on a domain. Claiming a domain the
+// tenant already claimed returns the existing claim.
+func ClaimDomain(tenantID, domain string) (models.TenantDomain, error) {
+	var claim models.TenantDomain
+	domain, err := NormalizeDomain(domain)
+	if err != nil {
+		return claim, err
+	}
+	if publicEmailDomains[domain] {
+		return claim, ErrPublicEmailDomain
+	}
+
+	err = db.DB.Where("tenant_id = ? AND domain = ?", tenantID, domain).First(&claim).Error
+	if err == nil {
+		return claim, nil
+	}
+	if !db.IsRecordNotFoundError(err) {
+		return claim, err
+	}
+
+	token, err := utils.NewOpaqueToken()
+	if err != nil {
+		return claim, err
+	}
+	claim = models.TenantDomain{TenantID: tenantID, Domain: domain, VerificationToken: token}
+	return claim, db.DB.Create(&claim).Error
+}
+
+// VerifyDomain checks the claim's TXT record and marks the domain verified
+func VerifyDomain(tenantID, id string) (models.TenantDomain, error) {
+	claim, err := getTenantDomain(tenantID, id)
+	if err != nil {
+		return claim, err
+	}
+	if claim.VerifiedAt != nil {
+		return claim, nil
+	}
+
+	var count int64
+	if err := db.DB.Model(&models.TenantDomain{}).
+		Where("domain = ? AND tenant_id <> ? AND verified_at IS NOT NULL", claim.Domain, tenantID).
+		Count(&count).Error; err != nil {
+		return claim, err
+	}
+	if count > 0 {
+		return claim, ErrDomainVerifiedElsewhere
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), domainLookupTimeout)
+	defer cancel()
+	if err := checkDomainChallenge(ctx, GetTXTResolver(), claim); err != nil {
+		return claim, err
+	}
+
+	now := time.Now()
+	if err := db.DB.Model(&claim).Update("verified_at", now).Error; err != nil {
+		// the partial unique index rejects a concurrent verification by another tenant
+		return claim, ErrDomainVerifiedElsewhere
+	}
+	claim.VerifiedAt = &now
+	return claim, nil
+}
+
+// DeleteTenantDomain removes a claim; people at the domain no longer auto-join
+func DeleteTenantDomain(tenantID, id string) error {
+	claim, err := getTenantDomain(tenantID, id)
+	if err != nil {
+		return err
+	}
+	return db.DB.Delete(&claim).Error
+}
+
+// DomainJoinTenant returns the active tenant that verified the email's
+// domain, if any
+func DomainJoinTenant(email string) (models.Tenant, bool, error) {
+	var tenant models.Tenant
+	domain := EmailDomain(email)
+	if domain == "" {
+		return tenant, false, nil
+	}
+	var claim models.TenantDomain
+	if err := db.DB.Where("domain = ? AND verified_at IS NOT NULL", domain).First(&claim).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return tenant, false, nil
+		}
+		return tenant, false, err
+	}
+	if err := db.DB.Where("id = ?", claim.TenantID).First(&tenant).Error; err != nil {
+		return tenant, false, err
+	}
+	return tenant, true, nil
+}
+
+// JoinTenantByDomain creates user in a tenant found by DomainJoinTenant with
+// the tenant's default role, unless its registration policy forbids it
+func JoinTenantByDomain(ctx context.Context, repos repository.Repositories, tenant models.Tenant, user models.User) (models.User, error) {
+	if tenant.Suspended {
+		return user, ErrTenantSuspended
+	}
+	if err := CheckRegistrationPolicy(tenant.ID, true); err != nil {
+		return user, err
+	}
+	if _, err := repos.Users.GetByEmail(ctx, user.Email); err == nil {
+		return user, ErrEmailTaken
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return user, err
+	}
+
+	user.Role = DefaultRoleFor(tenant.ID)
+	user.DomainJoined = true
+	user.EmailVerified = false
+	err := repos.Tenants.Lock(ctx, tenant.ID, func(tx repository.Repositories) error {
+		if err := CheckUserQuota(ctx, tx, tenant.ID); err != nil {
+			return err
+		}
+		return tx.Users.Create(ctx, tenant.ID, &user)
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		return user, ErrEmailTaken
+	}
+	return user, err
+}
+
+func getTenantDomain(tenantID, id string) (models.TenantDomain, error) {
+	var claim models.TenantDomain
+	if err := db.DB.Where(QueryByIDAndTenantIdLiteral, id, tenantID).First(&claim).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return claim, ErrDomainNotFound
+		}
+		return claim, err
+	}
+	return claim, nil
+}
+
+// checkDomainChallenge looks for the claim's challenge among the TXT records
+func checkDomainChallenge(ctx context.Context, resolver TXTResolver, claim models.TenantDomain) error {
+	name, value := DomainChallenge(claim)
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return ErrDomainVerificationFailed
+		}
+		return fmt.Errorf("failed to look up %s: %w", name, err)
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == value {
+			return nil
+		}
+	}
+	return ErrDomainVerificationFailed
+}
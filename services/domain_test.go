@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingResolver struct{}
+
+func (failingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, errors.New("i/o timeout")
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	domain, err := NormalizeDomain(" @Example.COM. ")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", domain)
+
+	for _, invalid := range []string{"", "localhost", "exa mple.com", "-example.com", "example.c0m"} {
+		_, err := NormalizeDomain(invalid)
+		assert.ErrorIs(t, err, ErrInvalidDomain, invalid)
+	}
+}
+
+func TestEmailDomain(t *testing.T) {
+	assert.Equal(t, "acme.io", EmailDomain("Jane@Acme.IO"))
+	assert.Equal(t, "", EmailDomain("not-an-email"))
+}
+
+func TestCheckDomainChallenge(t *testing.T) {
+	claim := models.TenantDomain{Domain: "acme.io", VerificationToken: "abc123"}
+	resolver := ParseStaticTXTRecords("_chat-app-challenge.acme.io=v=spf1 -all;_chat-app-challenge.ACME.io.=chat-app-verification=abc123")
+
+	assert.NoError(t, checkDomainChallenge(context.Background(), resolver, claim))
+
+	claim.VerificationToken = "other"
+	assert.ErrorIs(t, checkDomainChallenge(context.Background(), resolver, claim), ErrDomainVerificationFailed)
+
+	claim.Domain = "unknown.io"
+	assert.ErrorIs(t, checkDomainChallenge(context.Background(), resolver, claim), ErrDomainVerificationFailed)
+
+	err := checkDomainChallenge(context.Background(), failingResolver{}, claim)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrDomainVerificationFailed)
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+)
+
+const EmailVerificationTTL = 48 * time.Hour
+
+var (
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailNotVerified         = errors.New("email address not verified")
+)
+
+// ValidateEmail checks that email is a bare address such as user@example.com
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// SendEmailVerification emails a verification link for the user's current
+// email address. Earlier links for the user stop working.
+func SendEmailVerification(user models.User) error {
+	raw, err := utils.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	if err := db.DB.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.EmailVerificationToken{}).Error; err != nil {
+		return err
+	}
+	if err := db.DB.Create(&models.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().Add(EmailVerificationTTL),
+	}).Error; err != nil {
+		return err
+	}
+
+	link := AppURL("/verify-email?token=" + url.QueryEscape(raw))
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n", user.Name, link),
+	})
+	return nil
+}
+
+// RequestEmailVerification emails a new verification link to the account with
+// email, if it exists and is not verified yet, and returns that account. It
+// serves users who cannot sign in until they verify.
+func RequestEmailVerification(email string) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if user.EmailVerified {
+		return nil, nil
+	}
+	if err := SendEmailVerification(user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// VerifyEmail consumes a verification token, marks the user's email
+// verified and returns the user
+func VerifyEmail(raw string) (models.User, error) {
+	var user models.User
+	var token models.EmailVerificationToken
+	if err := db.DB.Where("token_hash = ?", utils.HashToken(raw)).First(&token).Error; err != nil {
+		return user, ErrInvalidVerificationToken
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return user, ErrInvalidVerificationToken
+	}
+
+	result := db.DB.Model(&models.EmailVerificationToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return user, ErrInvalidVerificationToken
+	}
+
+	// the address may have changed again since the link was sent
+	result = db.DB.Model(&models.User{}).
+		Where("id = ? AND email = ?", token.UserID, token.Email).
+		Update("email_verified", true)
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return user, ErrInvalidVerificationToken
+	}
+	err := db.DB.Where("id = ?", token.UserID).First(&user).Error
+	return user, err
+}
+
+// CheckEmailVerifiedForLogin enforces the tenant's login verification policy.
+// Users who joined through a verified domain always need a verified address,
+// as the address is what granted them access.
+func CheckEmailVerifiedForLogin(user models.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+	if user.DomainJoined {
+		return ErrEmailNotVerified
+	}
+	var tenant models.Tenant
+	if err := db.DB.Where("id = ?", user.TenantID).First(&tenant).Error; err != nil {
+		return err
+	}
+	if tenant.RequireVerifiedEmailForLogin {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// checkEmailVerifiedForChannels enforces the tenant's channel verification policy
+func checkEmailVerifiedForChannels(user models.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+	var tenant models.Tenant
+	if err := db.DB.Where("id = ?", user.TenantID).First(&tenant).Error; err != nil {
+		return err
+	}
+	if tenant.RequireVerifiedEmailForChannels {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEmail(t *testing.T) {
+	valid := []string{"user@example.com", "first.last+tag@sub.example.org"}
+	invalid := []string{"", "user", "user@", "John <john@example.com>", " user@example.com"}
+
+	for _, email := range valid {
+		assert.NoError(t, ValidateEmail(email), email)
+	}
+	for _, email := range invalid {
+		assert.ErrorIs(t, ValidateEmail(email), ErrInvalidEmail, email)
+	}
+}
+
+func TestRequestEmailVerification(t *testing.T) {
+	mock := testutil.SetupMockDB(t)
+	expectUser := func(verified bool) {
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE email = \$1`).
+			WithArgs("user1@example.com", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "tenant_id", "email_verified"}).
+				AddRow(testutil.UserOne, "user1@example.com", testutil.TenantOne, verified))
+	}
+
+	// an unverified user gets a new link, replacing the expired one
+	expectUser(false)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "email_verification_tokens" WHERE user_id = \$1 AND used_at IS NULL`).
+		WithArgs(testutil.UserOne).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "email_verification_tokens"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	user, err := RequestEmailVerification("user1@example.com")
+	assert.NoError(t, err)
+	if assert.NotNil(t, user) {
+		assert.Equal(t, testutil.UserOne, user.ID)
+	}
+
+	expectUser(true)
+	user, err = RequestEmailVerification("user1@example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE email = \$1`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	user, err = RequestEmailVerification("nobody@example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"gorm.io/gorm"
+)
+
+const InvitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidInvitation   = errors.New("invalid or expired invitation")
+	ErrInvitationNotFound  = errors.New("invitation not found")
+	ErrInvitationNotActive = errors.New("invitation has already been accepted or revoked")
+	ErrEmailTaken          = errors.New("email is already registered")
+	ErrTenantNameTaken     = errors.New("organization name is already taken")
+)
+
+// Invitation statuses as reported by InvitationStatus
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// InvitationStatus derives the status of an invitation
+func InvitationStatus(inv models.Invitation) string {
+	switch {
+	case inv.AcceptedAt != nil:
+		return InvitationAccepted
+	case inv.RevokedAt != nil:
+		return InvitationRevoked
+	case time.Now().After(inv.ExpiresAt):
+		return InvitationExpired
+	}
+	return InvitationPending
+}
+
+// RegisterTenant creates a new tenant owned by user, who becomes its ADMIN
+func RegisterTenant(orgName string, user models.User) (models.Tenant, models.User, error) {
+	tenant := models.Tenant{Name: strings.TrimSpace(orgName)}
+	if tenant.Name == "" {
+		return tenant, user, ErrOrgNameRequired
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Tenant{}).Where("LOWER(name) = LOWER(?)", tenant.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTenantNameTaken
+		}
+		if err := tx.Model(&models.User{}).Where("email = ?", user.Email).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+
+		if err := tx.Create(&tenant).Error; err != nil {
+			return err
+		}
+		user.TenantID = tenant.ID
+		user.Role = models.RoleAdmin
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		tenant.OwnerID = user.ID
+		return tx.Model(&tenant).Update("owner_id", user.ID).Error
+	})
+	return tenant, user, err
+}
+
+// CreateInvitation invites email to join the tenant with role and emails the link
+func CreateInvitation(tenantID, email string, role models.Role, invitedBy string) (models.Invitation, error) {
+	inv := models.Invitation{
+		TenantID:  tenantID,
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		Role:      role,
+		InvitedBy: invitedBy,
+	}
+	if err := ValidateEmail(inv.Email); err != nil {
+		return inv, err
+	}
+	if err := CheckRegistrationPolicy(tenantID, false); err != nil {
+		return inv, err
+	}
+	if err := CheckGuestAccess(tenantID, role); err != nil {
+		return inv, err
+	}
+	var count int64
+	if err := db.DB.Model(&models.User{}).Where("email = ?", inv.Email).Count(&count).Error; err != nil {
+		return inv, err
+	}
+	if count > 0 {
+		return inv, ErrEmailTaken
+	}
+
+	// a new invitation for the same address replaces pending ones
+	if err := db.DB.Model(&models.Invitation{}).
+		Where("tenant_id = ? AND email = ? AND accepted_at IS NULL AND revoked_at IS NULL", tenantID, inv.Email).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return inv, err
+	}
+
+	raw, err := rotateInvitationToken(&inv)
+	if err != nil {
+		return inv, err
+	}
+	if err := db.DB.Create(&inv).Error; err != nil {
+		return inv, err
+	}
+	sendInvitation(inv, raw)
+	return inv, nil
+}
+
+// ResendInvitation issues a fresh link for a pending or expired invitation;
+// the previous link stops working
+func ResendInvitation(tenantID, id string) (models.Invitation, error) {
+	inv, err := GetInvitation(tenantID, id)
+	if err != nil {
+		return inv, err
+	}
+	if inv.AcceptedAt != nil || inv.RevokedAt != nil {
+		return inv, ErrInvitationNotActive
+	}
+	raw, err := rotateInvitationToken(&inv)
+	if err != nil {
+		return inv, err
+	}
+	if err := db.DB.Save(&inv).Error; err != nil {
+		return inv, err
+	}
+	sendInvitation(inv, raw)
+	return inv, nil
+}
+
+// RevokeInvitation makes a pending invitation unusable
+func RevokeInvitation(tenantID, id string) (models.Invitation, error) {
+	inv, err := GetInvitation(tenantID, id)
+	if err != nil {
+		return inv, err
+	}
+	if inv.AcceptedAt != nil || inv.RevokedAt != nil {
+		return inv, ErrInvitationNotActive
+	}
+	now := time.Now()
+	inv.RevokedAt = &now
+	return inv, db.DB.Model(&inv).Update("revoked_at", now).Error
+}
+
+func GetInvitation(tenantID, id string) (models.Invitation, error) {
+	var inv models.Invitation
+	if err := db.DB.Where(QueryByIDAndTenantIdLiteral, id, tenantID).First(&inv).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return inv, ErrInvitationNotFound
+		}
+		return inv, err
+	}
+	return inv, nil
+}
+
+// ListInvitations lists a tenant's invitations, optionally only those with status
+func ListInvitations(tenantID, status string) ([]models.Invitation, error) {
+	query := db.DB.Where("tenant_id = ?", tenantID)
+	now := time.Now()
+	switch status {
+	case InvitationPending:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", now)
+	case InvitationAccepted:
+		query = query.Where("accepted_at IS NOT NULL")
+	case InvitationRevoked:
+		query = query.Where("revoked_at IS NOT NULL")
+	case InvitationExpired:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", now)
+	}
+	var invitations []models.Invitation
+	err := query.Order("created_at DESC").Find(&invitations).Error
+	return invitations, err
+}
+
+// LookupInvitation returns the pending invitation for a raw token
+func LookupInvitation(raw string) (models.Invitation, error) {
+	var inv models.Invitation
+	if err := db.DB.Where("token_hash = ?", utils.HashToken(raw)).First(&inv).Error; err != nil {
+		return inv, ErrInvalidInvitation
+	}
+	if InvitationStatus(inv) != InvitationPending {
+		return inv, ErrInvalidInvitation
+	}
+	return inv, nil
+}
+
+// AcceptInvitation creates the invited user in the tenant with the
+// preassigned role. The email address is verified by the link itself.
+func AcceptInvitation(ctx context.Context, repos repository.Repositories, raw, name, password string) (models.User, error) {
+	var user models.User
+	inv, err := LookupInvitation(raw)
+	if err != nil {
+		return user, err
+	}
+	if err := CheckTenantActive(inv.TenantID); err != nil {
+		return user, err
+	}
+	if err := CheckRegistrationPolicy(inv.TenantID, false); err != nil {
+		return user, err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return user, err
+	}
+
+	// the invitation is only used up when the user is created
+	err = repos.Tenants.Lock(ctx, inv.TenantID, func(tx repository.Repositories) error {
+		if err := tx.Invitations.Accept(ctx, inv.TenantID, inv.ID); errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidInvitation
+		} else if err != nil {
+			return err
+		}
+		if _, err := tx.Users.GetByEmail(ctx, inv.Email); err == nil {
+			return ErrEmailTaken
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		// the role may have been deleted since the invitation was sent
+		role := inv.Role
+		if !IsValidRole(inv.TenantID, role) {
+			role = DefaultRoleFor(inv.TenantID)
+		}
+		user = models.User{
+			Name:          name,
+			Email:         inv.Email,
+			Password:      string(hash),
+			Role:          role,
+			EmailVerified: true,
+		}
+		if err := CheckUserQuota(ctx, tx, inv.TenantID); err != nil {
+			return err
+		}
+		return tx.Users.Create(ctx, inv.TenantID, &user)
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		return user, ErrEmailTaken
+	}
+	return user, err
+}
+
+func rotateInvitationToken(inv *models.Invitation) (string, error) {
+	raw, err := utils.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	inv.TokenHash = utils.HashToken(raw)
+	inv.ExpiresAt = time.Now().Add(InvitationTTL)
+	inv.LastSentAt = time.Now()
+	return raw, nil
+}
+
+func sendInvitation(inv models.Invitation, raw string) {
+	var tenant models.Tenant
+	db.DB.Select("name").Where("id = ?", inv.TenantID).First(&tenant)
+
+	link := AppURL("/accept-invitation?token=" + url.QueryEscape(raw))
+	sendMailAsync(Mail{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("You have been invited to join %s", tenant.Name),
+		Body: fmt.Sprintf("Hi,\n\nYou have been invited to join %s on Chat App as %s.\n\nAccept the invitation by opening the link below (valid for %d days):\n\n%s\n",
+			tenant.Name, inv.Role, int(InvitationTTL.Hours()/24), link),
+	})
+}
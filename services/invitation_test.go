@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// invitationTest is a pending invitation of carol@acme.test with the raw
+// token "raw", in a tenant of its own so that cached settings do not leak
+// between tests
+type invitationTest struct {
+	mock     sqlmock.Sqlmock
+	repos    repository.Repositories
+	tenantID string
+}
+
+func newInvitationTest(t *testing.T) invitationTest {
+	mock := testutil.SetupMockDB(t)
+	return invitationTest{mock: mock, repos: repository.NewGORM(db.DB), tenantID: uuid.New().String()}
+}
+
+func (s invitationTest) rows(expiresAt time.Time, acceptedAt, revokedAt interface{}) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "tenant_id", "email", "role", "token_hash", "expires_at", "accepted_at", "revoked_at"}).
+		AddRow("inv-1", s.tenantID, "carol@acme.test", "MEMBER", utils.HashToken("raw"), expiresAt, acceptedAt, revokedAt)
+}
+
+// expectLookup expects the token raw to be looked up and found as rows
+func (s invitationTest) expectLookup(raw string, rows *sqlmock.Rows) {
+	s.mock.ExpectQuery(`SELECT \* FROM "invitations" WHERE token_hash = \$1`).
+		WithArgs(utils.HashToken(raw), 1).
+		WillReturnRows(rows)
+}
+
+// expectAcceptable expects the tenant to be active and open to invitations
+func (s invitationTest) expectAcceptable() {
+	s.mock.ExpectQuery(`SELECT "id","suspended" FROM "tenants"`).
+		WithArgs(s.tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "suspended"}).AddRow(s.tenantID, false))
+	s.mock.ExpectQuery(`SELECT \* FROM "tenant_settings"`).WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}))
+}
+
+// expectClaim expects the tenant to be locked and the invitation to be
+// marked accepted, which matches rowsAffected rows, and the address to be
+// looked up, held by a user when taken
+func (s invitationTest) expectClaim(rowsAffected int64, taken bool) {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(`SELECT "id" FROM "tenants" WHERE id = \$1 .* FOR NO KEY UPDATE`).
+		WithArgs(s.tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(s.tenantID))
+	s.mock.ExpectExec(`UPDATE "invitations" SET "accepted_at"=\$1 WHERE tenant_id = \$2 AND \(id = \$3 AND accepted_at IS NULL AND revoked_at IS NULL\)`).
+		WithArgs(sqlmock.AnyArg(), s.tenantID, "inv-1").
+		WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+	if rowsAffected == 0 {
+		return
+	}
+	rows := sqlmock.NewRows([]string{"id", "email"})
+	if taken {
+		rows.AddRow("user-1", "carol@acme.test")
+	}
+	s.mock.ExpectQuery(`SELECT \* FROM "users" WHERE email = \$1`).
+		WithArgs("carol@acme.test", 1).
+		WillReturnRows(rows)
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	s := newInvitationTest(t)
+	s.expectLookup("raw", s.rows(time.Now().Add(time.Hour), nil, nil))
+	s.expectAcceptable()
+	s.expectClaim(1, false)
+	s.mock.ExpectQuery(`SELECT "id","plan_id" FROM "tenants"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "plan_id"}).AddRow(s.tenantID, "free"))
+	s.mock.ExpectQuery(`SELECT \* FROM "plans"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "max_users"}).AddRow("free", 10))
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE tenant_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	s.mock.ExpectExec(`INSERT INTO "users"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	user, err := AcceptInvitation(context.Background(), s.repos, "raw", "Carol", "a-long-password")
+	assert.NoError(t, err)
+	assert.Equal(t, s.tenantID, user.TenantID)
+	assert.Equal(t, "carol@acme.test", user.Email)
+	assert.EqualValues(t, "MEMBER", user.Role)
+	// the link proved the address
+	assert.True(t, user.EmailVerified)
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestAcceptInvitationRefusesUnusableTokens(t *testing.T) {
+	s := newInvitationTest(t)
+	accept := func() error {
+		_, err := AcceptInvitation(context.Background(), s.repos, "raw", "Carol", "a-long-password")
+		return err
+	}
+
+	s.expectLookup("raw", s.rows(time.Now().Add(-time.Minute), nil, nil))
+	assert.ErrorIs(t, accept(), ErrInvalidInvitation, "expired")
+
+	s.expectLookup("raw", s.rows(time.Now().Add(time.Hour), time.Now(), nil))
+	assert.ErrorIs(t, accept(), ErrInvalidInvitation, "already accepted")
+
+	s.expectLookup("raw", s.rows(time.Now().Add(time.Hour), nil, time.Now()))
+	assert.ErrorIs(t, accept(), ErrInvalidInvitation, "revoked")
+
+	// a concurrent acceptance of the same token got there first
+	s.expectLookup("raw", s.rows(time.Now().Add(time.Hour), nil, nil))
+	s.expectAcceptable()
+	s.expectClaim(0, false)
+	s.mock.ExpectRollback()
+	assert.ErrorIs(t, accept(), ErrInvalidInvitation, "reused")
+
+	// the address was registered since the invitation was sent
+	s.expectLookup("raw", s.rows(time.Now().Add(time.Hour), nil, nil))
+	s.mock.ExpectQuery(`SELECT "id","suspended" FROM "tenants"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "suspended"}).AddRow(s.tenantID, false))
+	s.expectClaim(1, true)
+	s.mock.ExpectRollback()
+	assert.ErrorIs(t, accept(), ErrEmailTaken, "email taken")
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestResendInvitationReplacesTheToken(t *testing.T) {
+	s := newInvitationTest(t)
+	s.mock.ExpectQuery(`SELECT \* FROM "invitations" WHERE id = \$1::uuid AND tenant_id = \$2`).
+		WithArgs("inv-1", s.tenantID, 1).
+		WillReturnRows(s.rows(time.Now().Add(-time.Minute), nil, nil))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "invitations" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	inv, err := ResendInvitation(s.tenantID, "inv-1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, utils.HashToken("raw"), inv.TokenHash)
+	assert.Equal(t, InvitationPending, InvitationStatus(inv))
+
+	// the old link no longer matches any invitation
+	s.mock.ExpectQuery(`SELECT \* FROM "invitations" WHERE token_hash = \$1`).
+		WithArgs(utils.HashToken("raw"), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = AcceptInvitation(context.Background(), s.repos, "raw", "Carol", "a-long-password")
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+
+	s.mock.ExpectQuery(`SELECT \* FROM "invitations" WHERE id = \$1::uuid AND tenant_id = \$2`).
+		WillReturnRows(s.rows(time.Now().Add(time.Hour), time.Now(), nil))
+	_, err = ResendInvitation(s.tenantID, "inv-1")
+	assert.ErrorIs(t, err, ErrInvitationNotActive)
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	s := newInvitationTest(t)
+	s.mock.ExpectQuery(`SELECT \* FROM "invitations" WHERE id = \$1::uuid AND tenant_id = \$2`).
+		WithArgs("inv-1", s.tenantID, 1).
+		WillReturnRows(s.rows(time.Now().Add(time.Hour), nil, nil))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "invitations" SET "revoked_at"=\$1 WHERE "id" = \$2`).
+		WithArgs(sqlmock.AnyArg(), "inv-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	inv, err := RevokeInvitation(s.tenantID, "inv-1")
+	assert.NoError(t, err)
+	assert.Equal(t, InvitationRevoked, InvitationStatus(inv))
+
+	s.mock.ExpectQuery(`SELECT \* FROM "invitations" WHERE id = \$1::uuid AND tenant_id = \$2`).
+		WillReturnRows(s.rows(time.Now().Add(time.Hour), nil, time.Now()))
+	_, err = RevokeInvitation(s.tenantID, "inv-1")
+	assert.ErrorIs(t, err, ErrInvitationNotActive)
+
+	s.mock.ExpectQuery(`SELECT \* FROM "invitations" WHERE id = \$1::uuid AND tenant_id = \$2`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = RevokeInvitation(s.tenantID, "inv-2")
+	assert.ErrorIs(t, err, ErrInvitationNotFound)
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestCreateInvitationRefusesTakenEmails(t *testing.T) {
+	s := newInvitationTest(t)
+	s.mock.ExpectQuery(`SELECT \* FROM "tenant_settings"`).WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}))
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE email = \$1`).
+		WithArgs("carol@acme.test").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	_, err := CreateInvitation(s.tenantID, " Carol@Acme.test ", "MEMBER", "admin-1")
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	assert.NoError(t, s.mock.ExpectationsWereMet())
+}
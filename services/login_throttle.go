@@ -0,0 +1,193 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// loginFailureWindow is how long a failed login is remembered
+const loginFailureWindow = time.Hour
+
+// dummyPasswordHash is compared against when the email is unknown, so that
+// the response takes as long as for a wrong password
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// throttlePolicy delays logins progressively after FreeAttempts failures
+// and locks them after LockAfter failures. Every further lockout doubles,
+// up to MaxLock.
+type throttlePolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	Lock         time.Duration
+	MaxLock      time.Duration
+}
+
+var (
+	accountThrottle = throttlePolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second, LockAfter: 10, Lock: 15 * time.Minute, MaxLock: 24 * time.Hour}
+	// one address may be shared by many people (offices, NAT), so it gets more leeway
+	ipThrottle = throttlePolicy{FreeAttempts: 20, BaseDelay: time.Second, MaxDelay: 30 * time.Second, LockAfter: 100, Lock: 15 * time.Minute, MaxLock: 24 * time.Hour}
+)
+
+// LoginThrottledError reports that logins are blocked for RetryAfter
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed login attempts"
+}
+
+// CheckLoginAllowed returns a LoginThrottledError while logins for the email
+// or from the IP are delayed or locked. Unknown emails are throttled just
+// like known ones, so the answer does not reveal whether an account exists.
+func CheckLoginAllowed(email, ip string) error {
+	var throttles []models.LoginThrottle
+	if err := db.DB.Where("key IN ?", []string{accountThrottleKey(email), ipThrottleKey(ip)}).Find(&throttles).Error; err != nil {
+		return err
+	}
+	var wait time.Duration
+	now := time.Now()
+	for _, t := range throttles {
+		if t.BlockedUntil != nil && t.BlockedUntil.After(now) && t.BlockedUntil.Sub(now) > wait {
+			wait = t.BlockedUntil.Sub(now)
+		}
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a failed login for the email and the IP. When
+// this locks a known account, its owner is told by email.
+func RecordLoginFailure(email, ip string, user *models.User) error {
+	locked, err := recordThrottleFailure(accountThrottleKey(email), accountThrottle)
+	if err != nil {
+		return err
+	}
+	if _, err := recordThrottleFailure(ipThrottleKey(ip), ipThrottle); err != nil {
+		return err
+	}
+	if locked != nil && user != nil {
+		sendLockoutMail(*user, *locked)
+	}
+	return nil
+}
+
+// RecordLoginSuccess forgets the failed logins of the account. Failures from
+// the IP are kept, or an attacker could reset them with an account of their own.
+func RecordLoginSuccess(email string) error {
+	return ClearLoginFailures(email)
+}
+
+// ClearLoginFailures lifts delays and lockouts of an account
+func ClearLoginFailures(email string) error {
+	return db.DB.Where("key = ?", accountThrottleKey(email)).Delete(&models.LoginThrottle{}).Error
+}
+
+// UnlockUser lifts the lockout of a user of the tenant
+func UnlockUser(tenantID, userID string) error {
+	var user models.User
+	if err := db.DB.Where(QueryByIDAndTenantIdLiteral, userID, tenantID).First(&user).Error; err != nil {
+		return err
+	}
+	return ClearLoginFailures(user.Email)
+}
+
+// CompareDummyPassword spends the time of a password check when there is no
+// user to check against
+func CompareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = HashPassword("not a password")
+	})
+	_ = CheckPassword(password, string(dummyPasswordHash))
+}
+
+// recordThrottleFailure counts a failure under key and returns the lockout
+// end when this failure locked it
+func recordThrottleFailure(key string, policy throttlePolicy) (*time.Time, error) {
+	var locked *time.Time
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.LoginThrottle{Key: key, LastFailedAt: now}).Error; err != nil {
+			return err
+		}
+		var throttle models.LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&throttle).Error; err != nil {
+			return err
+		}
+		var lockedNow bool
+		throttle, lockedNow = applyLoginFailure(throttle, now, policy)
+		if lockedNow {
+			locked = throttle.BlockedUntil
+		}
+		return tx.Save(&throttle).Error
+	})
+	return locked, err
+}
+
+// applyLoginFailure adds a failure at now to t. It reports whether the
+// failure locked t, as opposed to only delaying the next attempt.
+func applyLoginFailure(t models.LoginThrottle, now time.Time, policy throttlePolicy) (models.LoginThrottle, bool) {
+	if now.Sub(t.LastFailedAt) > loginFailureWindow && (t.BlockedUntil == nil || t.BlockedUntil.Before(now)) {
+		t.Failures = 0
+	}
+	if now.Sub(t.LastFailedAt) > policy.MaxLock {
+		t.Lockouts = 0
+	}
+	t.Failures++
+	t.LastFailedAt = now
+
+	if t.Failures >= policy.LockAfter {
+		t.Lockouts++
+		lock := policy.Lock << (t.Lockouts - 1)
+		if lock > policy.MaxLock || lock <= 0 {
+			lock = policy.MaxLock
+		}
+		until := now.Add(lock)
+		t.BlockedUntil = &until
+		t.Failures = 0
+		return t, true
+	}
+	if t.Failures > policy.FreeAttempts {
+		delay := policy.BaseDelay << (t.Failures - policy.FreeAttempts - 1)
+		if delay > policy.MaxDelay || delay <= 0 {
+			delay = policy.MaxDelay
+		}
+		until := now.Add(delay)
+		t.BlockedUntil = &until
+	}
+	return t, false
+}
+
+func sendLockoutMail(user models.User, until time.Time) {
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Your account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\nThere were too many failed attempts to sign in to your account, so signing in is blocked until %s.\n\nIf this was not you, reset your password now:\n\n%s\n\nAn administrator of your organization can also unlock your account.\n",
+			user.Name, until.UTC().Format(time.RFC1123), AppURL("/forgot-password")),
+	})
+	slog.Warn("locked logins after repeated failures", "user_id", user.ID, "tenant_id", user.TenantID, "until", until.Format(time.RFC3339))
+}
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
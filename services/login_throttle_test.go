@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyLoginFailureDelaysThenLocks(t *testing.T) {
+	policy := throttlePolicy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, LockAfter: 6, Lock: time.Minute, MaxLock: time.Hour}
+	now := time.Now()
+	throttle := models.LoginThrottle{LastFailedAt: now}
+
+	var locked bool
+	for i := 0; i < 2; i++ {
+		throttle, locked = applyLoginFailure(throttle, now, policy)
+		assert.False(t, locked)
+		assert.Nil(t, throttle.BlockedUntil, "free attempts are not delayed")
+	}
+
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		throttle, locked = applyLoginFailure(throttle, now, policy)
+		assert.False(t, locked)
+		assert.Equal(t, now.Add(delay), *throttle.BlockedUntil)
+	}
+
+	throttle, locked = applyLoginFailure(throttle, now, policy)
+	assert.True(t, locked)
+	assert.Equal(t, now.Add(time.Minute), *throttle.BlockedUntil)
+	assert.Equal(t, 0, throttle.Failures)
+	assert.Equal(t, 1, throttle.Lockouts)
+}
+
+func TestApplyLoginFailureLockoutsDouble(t *testing.T) {
+	policy := throttlePolicy{FreeAttempts: 0, BaseDelay: time.Second, MaxDelay: time.Second, LockAfter: 1, Lock: time.Minute, MaxLock: 3 * time.Minute}
+	now := time.Now()
+	throttle := models.LoginThrottle{LastFailedAt: now}
+
+	for _, lock := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		throttle, _ = applyLoginFailure(throttle, now, policy)
+		assert.Equal(t, now.Add(lock), *throttle.BlockedUntil)
+	}
+
+	// quiet for longer than the longest lock: start over
+	later := now.Add(time.Hour)
+	throttle, _ = applyLoginFailure(throttle, later, policy)
+	assert.Equal(t, 1, throttle.Lockouts)
+}
+
+func TestApplyLoginFailureForgetsOldFailures(t *testing.T) {
+	policy := accountThrottle
+	now := time.Now()
+	throttle := models.LoginThrottle{Failures: 3, LastFailedAt: now.Add(-2 * loginFailureWindow)}
+	throttle, _ = applyLoginFailure(throttle, now, policy)
+	assert.Equal(t, 1, throttle.Failures)
+	assert.Nil(t, throttle.BlockedUntil)
+}
+
+func TestAccountThrottleKeyIgnoresCase(t *testing.T) {
+	assert.Equal(t, accountThrottleKey("Ann@Example.com "), accountThrottleKey("ann@example.com"))
+}
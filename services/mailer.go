@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail is a plain-text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email (password resets, verification links, ...)
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// SMTPMailer sends mail through an SMTP relay
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + mail.To,
+		"Subject: " + mail.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		mail.Body,
+	}, "\r\n")
+
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{mail.To}, []byte(msg))
+}
+
+// LogMailer writes mail to a file. When Path is empty only the recipient and
+// subject are logged, as bodies carry reset and verification tokens. It is
+// meant for local development.
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *LogMailer) Send(ctx context.Context, mail Mail) error {
+	if m.Path == "" {
+		slog.InfoContext(ctx, "mail", "to", mail.To, "subject", mail.Subject)
+		return nil
+	}
+	entry := fmt.Sprintf("--- %s\nTo: %s\nSubject: %s\n\n%s\n", time.Now().Format(time.RFC3339), mail.To, mail.Subject, mail.Body)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(entry)
+	return err
+}
+
+// mailer logs mail until SetMailer sets the configured one
+var mailer Mailer = &LogMailer{}
+
+// GetMailer returns the configured mailer
+func GetMailer() Mailer {
+	return mailer
+}
+
+// SetMailer sets the mailer selected by the config, or a fake one in tests
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// sendMailAsync sends mail in the background so that response timing does
+// not reveal whether an account exists.
+func sendMailAsync(mail Mail) {
+	go func() {
+		if err := GetMailer().Send(context.Background(), mail); err != nil {
+			slog.Error("failed to send mail", "subject", mail.Subject, "to", mail.To, "error", err)
+		}
+	}()
+}
+
+var appBaseURL string
+
+// SetAppBaseURL sets the frontend application that AppURL links into
+func SetAppBaseURL(u string) {
+	appBaseURL = u
+}
+
+// AppURL builds a link into the frontend application
+func AppURL(path string) string {
+	return strings.TrimRight(appBaseURL, "/") + path
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogMailerWritesToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := &LogMailer{Path: path}
+
+	err := m.Send(context.Background(), Mail{To: "user@example.com", Subject: "Reset your password", Body: "link"})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "To: user@example.com")
+	assert.Contains(t, string(data), "Subject: Reset your password")
+}
+
+func TestLogMailerLogsNoBody(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	m := &LogMailer{}
+	err := m.Send(context.Background(), Mail{To: "user@example.com", Subject: "Reset your password", Body: "https://app.test/reset?token=secret"})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "user@example.com")
+	assert.Contains(t, buf.String(), "Reset your password")
+	assert.NotContains(t, buf.String(), "secret")
+}
@@ -0,0 +1,660 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	oidcStateTTL       = 10 * time.Minute
+	oidcDiscoveryTTL   = time.Hour
+	oidcRequestTimeout = 10 * time.Second
+	// oidcJWKSRefetchInterval limits how often an unknown key id refetches
+	// the provider's keys
+	oidcJWKSRefetchInterval = time.Minute
+	// oidcClockSkew is how far an ID token's iat may be from our clock; the
+	// token is issued by the code exchange just before it is verified
+	oidcClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrOIDCNotConfigured = errors.New("single sign-on is not configured for this organization")
+	ErrOIDCInvalidState  = errors.New("invalid or expired login state")
+	ErrOIDCDomainDenied  = errors.New("email domain is not allowed for this organization")
+	ErrOIDCEmailInUse    = errors.New("email is already registered with another organization")
+	// ErrOIDCEmailUnverified is returned when an unverified email would link
+	// the identity to an existing account
+	ErrOIDCEmailUnverified = errors.New("the identity provider has not verified this email, so it cannot sign in to the existing account")
+	// ErrOIDCIssuerNotAllowed is returned for issuers on loopback, private or
+	// link-local addresses, unless OIDC_ALLOW_PRIVATE_NETWORKS is true
+	ErrOIDCIssuerNotAllowed = errors.New("identity provider must be reachable over https at a public address")
+)
+
+// nonPublicPrefixes are the special-purpose ranges not covered by the
+// netip.Addr predicates
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// oidcHTTPClient makes the requests to identity providers. Issuers are
+// supplied by tenant admins, so unless private networks are allowed it only
+// connects to public addresses, whatever a host resolves to.
+var oidcHTTPClient = newOIDCHTTPClient(false)
+
+var (
+	oidcRedirectURL          string
+	oidcAllowPrivateNetworks bool
+)
+
+// ConfigureOIDC sets the callback URL registered with every identity provider
+// and whether issuers may be on private networks
+func ConfigureOIDC(redirectURL string, allowPrivateNetworks bool) {
+	oidcRedirectURL = redirectURL
+	oidcAllowPrivateNetworks = allowPrivateNetworks
+	oidcHTTPClient = newOIDCHTTPClient(allowPrivateNetworks)
+}
+
+func getOIDCHTTPClient() *http.Client {
+	return oidcHTTPClient
+}
+
+func newOIDCHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: oidcRequestTimeout}
+	if !allowPrivate {
+		// checked on the resolved address of every connection, redirects included
+		dialer.ControlContext = func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !publicAddress(addr) {
+				return fmt.Errorf("%w: %s", ErrOIDCIssuerNotAllowed, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would hide the destination from the address check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: oidcRequestTimeout, Transport: transport}
+}
+
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateOIDCIssuer rejects issuer URLs that are not https, or that point at
+// a loopback, private or link-local address, unless private networks are
+// allowed. Host names are checked again on every connection.
+func ValidateOIDCIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" || u.User != nil {
+		return ErrOIDCIssuerNotAllowed
+	}
+	if oidcAllowPrivateNetworks {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return ErrOIDCIssuerNotAllowed
+		}
+		return nil
+	}
+	if u.Scheme != "https" || strings.EqualFold(u.Hostname(), "localhost") {
+		return ErrOIDCIssuerNotAllowed
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !publicAddress(addr) {
+		return ErrOIDCIssuerNotAllowed
+	}
+	return nil
+}
+
+// oidcProvider is the subset of the discovery document we rely on
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetchedAt time.Time
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	// keysFetchedAt is when the keys were last requested
+	keysFetchedAt time.Time
+}
+
+var oidcProviders sync.Map // issuer -> *oidcProvider
+
+// OIDCRedirectURL is the callback URL registered with every identity provider
+func OIDCRedirectURL() string {
+	return oidcRedirectURL
+}
+
+// discoverOIDCProvider fetches (and caches) the issuer's discovery document
+func discoverOIDCProvider(ctx context.Context, issuer string) (*oidcProvider, error) {
+	if cached, ok := oidcProviders.Load(issuer); ok {
+		p := cached.(*oidcProvider)
+		if time.Since(p.fetchedAt) < oidcDiscoveryTTL {
+			return p, nil
+		}
+	}
+
+	wellKnown := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	var p oidcProvider
+	if err := getJSON(ctx, wellKnown, &p); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if p.Issuer != issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q != %q", p.Issuer, issuer)
+	}
+	p.fetchedAt = time.Now()
+	oidcProviders.Store(issuer, &p)
+	return &p, nil
+}
+
+// key returns the verification key for kid, refetching the JWKS if the key
+// is unknown (the provider may have rotated keys), at most once per
+// oidcJWKSRefetchInterval.
+func (p *oidcProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcJWKSRefetchInterval {
+		return nil, fmt.Errorf("oidc jwks: unknown key id %q", kid)
+	}
+	p.keysFetchedAt = time.Now()
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	p.keys = keys
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc jwks: unknown key id %q", kid)
+}
+
+// StartOIDCLogin begins the authorization code flow with PKCE for a tenant
+// and returns the identity provider URL to redirect the browser to.
+func StartOIDCLogin(ctx context.Context, tenantID string) (string, error) {
+	if err := CheckTenantActive(tenantID); err != nil {
+		if errors.Is(err, ErrTenantSuspended) {
+			return "", err
+		}
+		return "", ErrOIDCNotConfigured
+	}
+	var config models.TenantOIDCConfig
+	if err := db.DB.WithContext(ctx).Where("tenant_id = ? AND enabled = ?", tenantID, true).First(&config).Error; err != nil {
+		return "", ErrOIDCNotConfigured
+	}
+	provider, err := discoverOIDCProvider(ctx, config.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	state, err1 := utils.NewOpaqueToken()
+	nonce, err2 := utils.NewOpaqueToken()
+	verifier, err3 := utils.NewOpaqueToken()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return "", err
+	}
+
+	// drop abandoned flows
+	db.DB.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.OIDCAuthState{})
+	if err := db.DB.WithContext(ctx).Create(&models.OIDCAuthState{
+		State:        utils.HashToken(state),
+		TenantID:     tenantID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", config.ClientID)
+	q.Set("redirect_uri", OIDCRedirectURL())
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return provider.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// CompleteOIDCLogin handles the callback: it redeems the code, verifies the
+// ID token and returns the (possibly just provisioned) user.
+func CompleteOIDCLogin(ctx context.Context, repos repository.Repositories, state, code string) (models.User, error) {
+	var user models.User
+
+	var pending models.OIDCAuthState
+	if err := db.DB.WithContext(ctx).Where("state = ?", utils.HashToken(state)).First(&pending).Error; err != nil {
+		return user, ErrOIDCInvalidState
+	}
+	// single use, whatever the outcome
+	result := db.DB.WithContext(ctx).Where("state = ?", pending.State).Delete(&models.OIDCAuthState{})
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(pending.ExpiresAt) {
+		return user, ErrOIDCInvalidState
+	}
+
+	if err := CheckTenantActive(pending.TenantID); err != nil {
+		return user, err
+	}
+	var config models.TenantOIDCConfig
+	if err := db.DB.WithContext(ctx).Where("tenant_id = ? AND enabled = ?", pending.TenantID, true).First(&config).Error; err != nil {
+		return user, ErrOIDCNotConfigured
+	}
+	provider, err := discoverOIDCProvider(ctx, config.Issuer)
+	if err != nil {
+		return user, err
+	}
+
+	rawIDToken, err := exchangeOIDCCode(ctx, provider, config, code, pending.CodeVerifier)
+	if err != nil {
+		return user, err
+	}
+	claims, err := verifyIDToken(ctx, provider, config, rawIDToken, pending.Nonce)
+	if err != nil {
+		return user, err
+	}
+
+	return provisionOIDCUser(ctx, repos, config, claims)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// exchangeOIDCCode redeems an authorization code and returns the raw ID token
+func exchangeOIDCCode(ctx context.Context, provider *oidcProvider, config models.TenantOIDCConfig, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", OIDCRedirectURL())
+	form.Set("client_id", config.ClientID)
+	form.Set("code_verifier", verifier)
+	if config.ClientSecret != "" {
+		form.Set("client_secret", config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := getOIDCHTTPClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token exchange: status %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("oidc token exchange: no id_token in response")
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken checks the ID token signature, issuer, audience, expiry, issue
+// time and nonce
+func verifyIDToken(ctx context.Context, provider *oidcProvider, config models.TenantOIDCConfig, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256"}))
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return provider.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("oidc id_token: %w", err)
+	}
+
+	// the parser only checks exp and iat when they are present
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Unix(), true) {
+		return nil, errors.New("oidc id_token: missing or past expiry")
+	}
+	iat, ok := numericClaim(claims, "iat")
+	if !ok || iat.Before(now.Add(-oidcClockSkew)) || iat.After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("oidc id_token: missing or stale issue time")
+	}
+	if !claims.VerifyIssuer(config.Issuer, true) {
+		return nil, errors.New("oidc id_token: wrong issuer")
+	}
+	if !claims.VerifyAudience(config.ClientID, true) {
+		return nil, errors.New("oidc id_token: wrong audience")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("oidc id_token: nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("oidc id_token: missing subject")
+	}
+	return claims, nil
+}
+
+// numericClaim reads a NumericDate claim such as iat
+func numericClaim(claims jwt.MapClaims, name string) (time.Time, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+// mapOIDCRole maps the configured role claim (a string or list of strings)
+// onto our roles. The most privileged match in the tenant's policy wins.
+func mapOIDCRole(config models.TenantOIDCConfig, claims jwt.MapClaims, policy Policy) models.Role {
+	role := config.DefaultRole
+	if role == "" {
+		role = models.RoleMember
+	}
+	if config.RoleClaim == "" || len(config.RoleMapping) == 0 {
+		return role
+	}
+
+	var values []string
+	switch v := claims[config.RoleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := models.Role("")
+	for _, value := range values {
+		if mapped, ok := config.RoleMapping[value]; ok && (best == "" || outranks(policy, mapped, best)) {
+			best = mapped
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return role
+}
+
+// outranks reports whether role a is more privileged than role b: it holds
+// more permissions, or as many and is the higher built-in role. Custom roles
+// are thus ranked among the built-in ones by what they may do.
+func outranks(policy Policy, a, b models.Role) bool {
+	if countA, countB := len(grantedPermissions(policy, string(a))), len(grantedPermissions(policy, string(b))); countA != countB {
+		return countA > countB
+	}
+	builtin := map[models.Role]int{models.RoleGuest: 1, models.RoleMember: 2, models.RoleModerator: 3, models.RoleAdmin: 4}
+	if builtin[a] != builtin[b] {
+		return builtin[a] > builtin[b]
+	}
+	return a < b
+}
+
+// provisionOIDCUser finds the user linked to the ID token subject, links an
+// existing user of the tenant with the same email, or creates a new user.
+func provisionOIDCUser(ctx context.Context, repos repository.Repositories, config models.TenantOIDCConfig, claims jwt.MapClaims) (models.User, error) {
+	var user models.User
+
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	emailVerified := claimTrue(claims["email_verified"])
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if err := ValidateEmail(email); err != nil {
+		return user, errors.New("identity provider did not return a valid email")
+	}
+	if len(config.AllowedDomains) > 0 && !emailDomainAllowed(email, config.AllowedDomains) {
+		return user, ErrOIDCDomainDenied
+	}
+	if config.DefaultRole == "" {
+		config.DefaultRole = DefaultRoleFor(config.TenantID)
+	}
+	var policy Policy
+	if config.RoleClaim != "" && len(config.RoleMapping) > 0 {
+		var err error
+		if policy, err = TenantPolicy(config.TenantID); err != nil {
+			return user, err
+		}
+	}
+	role := mapOIDCRole(config, claims, policy)
+
+	var identity models.UserIdentity
+	err := db.DB.WithContext(ctx).Where("issuer = ? AND subject = ?", config.Issuer, subject).First(&identity).Error
+	switch {
+	case err == nil:
+		if err := db.DB.WithContext(ctx).Where("id = ? AND tenant_id = ?", identity.UserID, config.TenantID).First(&user).Error; err != nil {
+			return user, err
+		}
+		if user.Disabled {
+			return user, ErrAccountDisabled
+		}
+		// keep the role in sync with the identity provider when a mapping is configured
+		if config.RoleClaim != "" && user.Role != role {
+			user.Role = role
+			if err := db.DB.WithContext(ctx).Model(&user).Update("role", role).Error; err != nil {
+				return user, err
+			}
+			if err := RevokeAllUserTokens(user.ID); err != nil {
+				return user, err
+			}
+			if err := db.DB.WithContext(ctx).Where("id = ?", user.ID).First(&user).Error; err != nil {
+				return user, err
+			}
+		}
+		return user, nil
+	case !db.IsRecordNotFoundError(err):
+		return user, err
+	}
+
+	// first login with this identity: link or create the user
+	err = db.DB.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	switch {
+	case err == nil:
+		if user.TenantID != config.TenantID {
+			return models.User{}, ErrOIDCEmailInUse
+		}
+		// an identity provider accepting unverified addresses could
+		// otherwise take over any account of the tenant
+		if !emailVerified {
+			return models.User{}, ErrOIDCEmailUnverified
+		}
+		if user.Disabled {
+			return user, ErrAccountDisabled
+		}
+	case db.IsRecordNotFoundError(err):
+		if name == "" {
+			name = email
+		}
+		// SSO users have no usable password
+		random, err := utils.NewOpaqueToken()
+		if err != nil {
+			return user, err
+		}
+		hash, err := HashPassword(random)
+		if err != nil {
+			return user, err
+		}
+		user = models.User{
+			Name:          name,
+			Email:         email,
+			Password:      string(hash),
+			Role:          role,
+			EmailVerified: emailVerified,
+		}
+		err = repos.Tenants.Lock(ctx, config.TenantID, func(tx repository.Repositories) error {
+			if err := CheckUserQuota(ctx, tx, config.TenantID); err != nil {
+				return err
+			}
+			return tx.Users.Create(ctx, config.TenantID, &user)
+		})
+		if err != nil {
+			return user, err
+		}
+		if err := CreateStreamUser(ctx, user); err != nil {
+			return user, err
+		}
+		slog.InfoContext(ctx, "provisioned user via OIDC", "provisioned_user_id", user.ID, "tenant_id", user.TenantID)
+	default:
+		return user, err
+	}
+
+	if err := db.DB.WithContext(ctx).Create(&models.UserIdentity{
+		UserID:   user.ID,
+		TenantID: user.TenantID,
+		Issuer:   config.Issuer,
+		Subject:  subject,
+	}).Error; err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// claimTrue reports whether a boolean claim is true; some providers send
+// booleans as strings
+func claimTrue(claim interface{}) bool {
+	switch v := claim.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func emailDomainAllowed(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range domains {
+		if strings.EqualFold(strings.TrimSpace(allowed), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := getOIDCHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIssuer is a minimal OpenID provider serving discovery, JWKS and a token
+// endpoint that returns a signed ID token for a fixed authorization code.
+type mockIssuer struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	code     string
+	verifier string
+	nonce    string
+	claims   jwt.MapClaims
+	// jwksFetches counts the requests for the keys
+	jwksFetches atomic.Int32
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockIssuer{key: key, clientID: "chat-app", code: "auth-code", verifier: "verifier", nonce: "nonce"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.jwksFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != m.code || pkceChallenge(r.Form.Get("code_verifier")) != pkceChallenge(m.verifier) {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	// the mock listens on loopback, which the default client refuses
+	previous := oidcHTTPClient
+	oidcHTTPClient = m.server.Client()
+	t.Cleanup(func() { oidcHTTPClient = previous })
+
+	m.claims = jwt.MapClaims{
+		"iss":    m.server.URL,
+		"aud":    m.clientID,
+		"sub":    "user-123",
+		"email":  "jane@acme.test",
+		"nonce":  m.nonce,
+		"groups": []string{"chat-admins", "everyone"},
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"iat":    time.Now().Unix(),
+	}
+	return m
+}
+
+func TestOIDCCodeExchangeAgainstMockIssuer(t *testing.T) {
+	m := newMockIssuer(t)
+	config := models.TenantOIDCConfig{Issuer: m.server.URL, ClientID: m.clientID}
+	ctx := context.Background()
+
+	provider, err := discoverOIDCProvider(ctx, config.Issuer)
+	require.NoError(t, err)
+
+	raw, err := exchangeOIDCCode(ctx, provider, config, m.code, m.verifier)
+	require.NoError(t, err)
+
+	claims, err := verifyIDToken(ctx, provider, config, raw, m.nonce)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@acme.test", claims["email"])
+
+	_, err = verifyIDToken(ctx, provider, config, raw, "other-nonce")
+	assert.Error(t, err)
+
+	_, err = verifyIDToken(ctx, provider, models.TenantOIDCConfig{Issuer: m.server.URL, ClientID: "someone-else"}, raw, m.nonce)
+	assert.Error(t, err)
+
+	_, err = exchangeOIDCCode(ctx, provider, config, m.code, "wrong-verifier")
+	assert.Error(t, err)
+}
+
+func TestOIDCIDTokenNeedsExpiryAndIssueTime(t *testing.T) {
+	m := newMockIssuer(t)
+	config := models.TenantOIDCConfig{Issuer: m.server.URL, ClientID: m.clientID}
+	ctx := context.Background()
+	provider, err := discoverOIDCProvider(ctx, config.Issuer)
+	require.NoError(t, err)
+	verify := func(change func(jwt.MapClaims)) error {
+		claims := jwt.MapClaims{}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		change(claims)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		raw, err := token.SignedString(m.key)
+		require.NoError(t, err)
+		_, err = verifyIDToken(ctx, provider, config, raw, m.nonce)
+		return err
+	}
+
+	assert.NoError(t, verify(func(jwt.MapClaims) {}))
+	assert.Error(t, verify(func(c jwt.MapClaims) { delete(c, "exp") }), "no expiry")
+	assert.Error(t, verify(func(c jwt.MapClaims) { delete(c, "iat") }), "no issue time")
+	assert.Error(t, verify(func(c jwt.MapClaims) { c["iat"] = time.Now().Add(-time.Hour).Unix() }), "issued long ago")
+	assert.Error(t, verify(func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }), "issued in the future")
+}
+
+func TestOIDCUnknownKeyIDRefetchesKeysOnce(t *testing.T) {
+	m := newMockIssuer(t)
+	config := models.TenantOIDCConfig{Issuer: m.server.URL, ClientID: m.clientID}
+	ctx := context.Background()
+	provider, err := discoverOIDCProvider(ctx, config.Issuer)
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+	token.Header["kid"] = "unknown-key"
+	raw, err := token.SignedString(m.key)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = verifyIDToken(ctx, provider, config, raw, m.nonce)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), m.jwksFetches.Load())
+}
+
+func TestOIDCIssuerAddresses(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:10.0.0.1":  false,
+		"::ffff:127.0.0.1": false,
+	} {
+		assert.Equal(t, public, publicAddress(netip.MustParseAddr(addr)), addr)
+	}
+
+	assert.NoError(t, ValidateOIDCIssuer("https://login.example.com/realms/acme"))
+	for _, issuer := range []string{"http://login.example.com", "https://localhost:8443", "https://127.0.0.1", "https://[::1]/", "https://169.254.169.254/latest", "file:///etc/passwd"} {
+		assert.ErrorIs(t, ValidateOIDCIssuer(issuer), ErrOIDCIssuerNotAllowed, issuer)
+	}
+
+	// host names are checked once resolved, on every connection
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	_, err := newOIDCHTTPClient(false).Get(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+	assert.ErrorIs(t, err, ErrOIDCIssuerNotAllowed)
+	_, err = newOIDCHTTPClient(true).Get(server.URL)
+	assert.NoError(t, err)
+}
+
+func TestMapOIDCRole(t *testing.T) {
+	policy := Policy{}
+	for role, permissions := range DefaultRolePermissions {
+		policy[string(role)] = map[models.Permission]bool{}
+		for _, p := range permissions {
+			policy[string(role)][p] = true
+		}
+	}
+	policy["SUPPORT_AGENT"] = map[models.Permission]bool{
+		models.PermChannelJoin: true, models.PermMessageSend: true, models.PermMessageDeleteAny: true,
+	}
+	config := models.TenantOIDCConfig{
+		RoleClaim:   "groups",
+		RoleMapping: map[string]models.Role{"everyone": models.RoleMember, "chat-admins": models.RoleAdmin},
+		DefaultRole: models.RoleGuest,
+	}
+
+	assert.Equal(t, models.RoleAdmin, mapOIDCRole(config, jwt.MapClaims{"groups": []interface{}{"everyone", "chat-admins"}}, policy))
+	assert.Equal(t, models.RoleMember, mapOIDCRole(config, jwt.MapClaims{"groups": "everyone"}, policy))
+	assert.Equal(t, models.RoleGuest, mapOIDCRole(config, jwt.MapClaims{}, policy))
+
+	// a custom role is ranked by its permissions, not ignored
+	config.RoleMapping = map[string]models.Role{"support": "SUPPORT_AGENT"}
+	assert.Equal(t, models.Role("SUPPORT_AGENT"), mapOIDCRole(config, jwt.MapClaims{"groups": []interface{}{"support"}}, policy))
+	config.RoleMapping = map[string]models.Role{"support": "SUPPORT_AGENT", "everyone": models.RoleMember, "chat-admins": models.RoleAdmin}
+	assert.Equal(t, models.Role("SUPPORT_AGENT"), mapOIDCRole(config, jwt.MapClaims{"groups": []interface{}{"everyone", "support"}}, policy))
+	assert.Equal(t, models.RoleAdmin, mapOIDCRole(config, jwt.MapClaims{"groups": []interface{}{"support", "chat-admins"}}, policy))
+}
+
+func TestProvisionOIDCUserLinksOnlyVerifiedEmails(t *testing.T) {
+	mock := testutil.SetupMockDB(t)
+	config := models.TenantOIDCConfig{TenantID: testutil.TenantOne, Issuer: "https://idp.test", DefaultRole: models.RoleMember}
+	claims := jwt.MapClaims{"sub": "idp-user", "email": "user1@example.com", "email_verified": false}
+
+	mock.ExpectQuery(`SELECT \* FROM "user_identities"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(testutil.MockUserRows())
+	_, err := provisionOIDCUser(context.Background(), repository.NewGORM(db.DB), config, claims)
+	assert.ErrorIs(t, err, ErrOIDCEmailUnverified)
+
+	claims["email_verified"] = "true"
+	mock.ExpectQuery(`SELECT \* FROM "user_identities"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(testutil.MockUserRows())
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "user_identities"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	user, err := provisionOIDCUser(context.Background(), repository.NewGORM(db.DB), config, claims)
+	assert.NoError(t, err)
+	assert.Equal(t, testutil.UserOne, user.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+)
+
+const PasswordResetTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// RequestPasswordReset emails a single-use reset link to the user and
+// returns them. Unknown emails are silently ignored (nil user) so callers
+// cannot probe for accounts.
+func RequestPasswordReset(email string) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	raw, err := utils.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// only the most recently requested link stays valid
+	if err := db.DB.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		return nil, err
+	}
+	if err := db.DB.Create(&models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().Add(PasswordResetTTL),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	link := AppURL("/reset-password?token=" + url.QueryEscape(raw))
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes.\n\n%s\n\nIf you did not request this, you can ignore this email.\n",
+			user.Name, int(PasswordResetTTL.Minutes()), link),
+	})
+	return &user, nil
+}
+
+// ResetPassword consumes a reset token, sets the new password and revokes
+// every existing token of the user, whom it returns.
+func ResetPassword(raw, newPassword string) (models.User, error) {
+	var user models.User
+	var token models.PasswordResetToken
+	if err := db.DB.Where("token_hash = ?", utils.HashToken(raw)).First(&token).Error; err != nil {
+		return user, ErrInvalidResetToken
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return user, ErrInvalidResetToken
+	}
+
+	// mark as used first so that concurrent requests cannot both succeed
+	result := db.DB.Model(&models.PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return user, ErrInvalidResetToken
+	}
+
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return user, err
+	}
+	if err := db.DB.Model(&models.User{}).Where("id = ?", token.UserID).Update("password", string(hash)).Error; err != nil {
+		return user, err
+	}
+	// the owner proved control of the mailbox, so a lockout no longer protects them
+	if err := db.DB.Select("id", "email", "tenant_id").Where("id = ?", token.UserID).First(&user).Error; err != nil {
+		return user, err
+	}
+	if err := ClearLoginFailures(user.Email); err != nil {
+		return user, err
+	}
+	return user, RevokeAllUserTokens(token.UserID)
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrPermissionLockout = errors.New("ADMIN cannot lose the permission to manage permissions")
+)
+
+// PermissionInfo describes an entry of the permission catalog
+type PermissionInfo struct {
+	Name        models.Permission `json:"name"`
+	Description string            `json:"description"`
+}
+
+// PermissionCatalog lists every permission the API checks
+var PermissionCatalog = []PermissionInfo{
+	{models.PermChannelCreate, "Create channels"},
+	{models.PermChannelMembersManage, "Add and remove other users to and from channels"},
+	{models.PermChannelJoin, "Join and leave channels"},
+	{models.PermMessageSend, "Send messages to channels the user is a member of"},
+	{models.PermMessageDeleteAny, "Delete messages written by other users"},
+	{models.PermUserCreate, "Create users in the organization"},
+	{models.PermUserInvite, "Invite people to join the organization"},
+	{models.PermUserUpdate, "Update other users' names and email addresses"},
+	{models.PermUserUpdateRole, "Change users' roles"},
+	{models.PermUserDelete, "Delete users"},
+	{models.PermUserUnlock, "Unlock accounts locked after failed logins"},
+	{models.PermTenantManage, "Manage organization security and single sign-on settings"},
+	{models.PermTenantSCIMManage, "Manage SCIM provisioning tokens"},
+	{models.PermTenantPermissionsEdit, "Change which roles hold which permissions"},
+	{models.PermAuditRead, "Read and export the audit log"},
+}
+
+// DefaultRolePermissions is seeded into the policy table as the platform
+// defaults. Changing the table takes effect without a redeploy; changing
+// this map only affects permissions not yet in the table.
+var DefaultRolePermissions = map[models.Role][]models.Permission{
+	models.RoleAdmin: {
+		models.PermChannelCreate, models.PermChannelMembersManage, models.PermChannelJoin,
+		models.PermMessageSend, models.PermMessageDeleteAny,
+		models.PermUserCreate, models.PermUserInvite, models.PermUserUpdate, models.PermUserUpdateRole, models.PermUserDelete, models.PermUserUnlock,
+		models.PermTenantManage, models.PermTenantSCIMManage, models.PermTenantPermissionsEdit, models.PermAuditRead,
+	},
+	models.RoleModerator: {
+		models.PermChannelCreate, models.PermChannelMembersManage, models.PermChannelJoin,
+		models.PermMessageSend, models.PermMessageDeleteAny,
+		models.PermUserCreate, models.PermUserUpdate,
+	},
+	models.RoleMember: {models.PermChannelJoin, models.PermMessageSend},
+	models.RoleGuest:  {models.PermChannelJoin, models.PermMessageSend},
+}
+
+// permissionCacheTTL bounds how long a policy change takes to reach other instances
+const permissionCacheTTL = 30 * time.Second
+
+// Policy maps role -> permission -> allowed
+type Policy map[string]map[models.Permission]bool
+
+type cachedPolicy struct {
+	policy   Policy
+	loadedAt time.Time
+}
+
+var (
+	policyCacheMu sync.RWMutex
+	policyCache   = map[string]cachedPolicy{}
+)
+
+func IsKnownPermission(permission models.Permission) bool {
+	for _, p := range PermissionCatalog {
+		if p.Name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// SeedDefaultPermissions inserts the platform defaults that are missing from
+// the policy table, leaving rows edited in the database untouched
+func SeedDefaultPermissions() error {
+	var rows []models.RolePermission
+	for role, permissions := range DefaultRolePermissions {
+		granted := map[models.Permission]bool{}
+		for _, p := range permissions {
+			granted[p] = true
+		}
+		for _, p := range PermissionCatalog {
+			rows = append(rows, models.RolePermission{Role: string(role), Permission: p.Name, Allowed: granted[p.Name]})
+		}
+	}
+	return db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// HasPermission reports whether role holds permission in the tenant
+func HasPermission(tenantID, role string, permission models.Permission) (bool, error) {
+	policy, err := TenantPolicy(tenantID)
+	if err != nil {
+		return false, err
+	}
+	return policy[role][permission], nil
+}
+
+// Within reports whether role holds no permission that callerRole lacks
+func (p Policy) Within(callerRole, role string) bool {
+	for permission, allowed := range p[role] {
+		if allowed && !p[callerRole][permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// RoleWithin reports whether callerRole holds every permission of role in the
+// tenant, so that acting on a user of role gains the caller nothing
+func RoleWithin(tenantID, callerRole, role string) (bool, error) {
+	policy, err := TenantPolicy(tenantID)
+	if err != nil {
+		return false, err
+	}
+	return policy.Within(callerRole, role), nil
+}
+
+// TenantPolicy returns the effective policy of a tenant: the platform
+// defaults with the tenant's overrides applied
+func TenantPolicy(tenantID string) (Policy, error) {
+	policyCacheMu.RLock()
+	cached, ok := policyCache[tenantID]
+	policyCacheMu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < permissionCacheTTL {
+		return cached.policy, nil
+	}
+
+	var rows []models.RolePermission
+	if err := db.DB.Where("tenant_id IN ?", []string{"", tenantID}).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	policy := resolvePolicy(rows)
+
+	policyCacheMu.Lock()
+	policyCache[tenantID] = cachedPolicy{policy: policy, loadedAt: time.Now()}
+	policyCacheMu.Unlock()
+	return policy, nil
+}
+
+// resolvePolicy applies tenant rows over the platform default rows
+func resolvePolicy(rows []models.RolePermission) Policy {
+	policy := Policy{}
+	set := func(row models.RolePermission) {
+		if policy[row.Role] == nil {
+			policy[row.Role] = map[models.Permission]bool{}
+		}
+		policy[row.Role][row.Permission] = row.Allowed
+	}
+	for _, row := range rows {
+		if row.TenantID == "" {
+			set(row)
+		}
+	}
+	for _, row := range rows {
+		if row.TenantID != "" {
+			set(row)
+		}
+	}
+	return policy
+}
+
+// TenantPermissionOverrides lists a tenant's overrides of the defaults
+func TenantPermissionOverrides(tenantID string) ([]models.RolePermission, error) {
+	var rows []models.RolePermission
+	err := db.DB.Where("tenant_id = ?", tenantID).Order("role, permission").Find(&rows).Error
+	return rows, err
+}
+
+// SetTenantPermission grants or denies a permission to a role within a tenant
+func SetTenantPermission(ctx context.Context, tenantID, role string, permission models.Permission, allowed bool) (models.RolePermission, error) {
+	row := models.RolePermission{TenantID: tenantID, Role: role, Permission: permission, Allowed: allowed}
+	if !IsKnownPermission(permission) {
+		return row, ErrUnknownPermission
+	}
+	if role == string(models.RoleAdmin) && permission == models.PermTenantPermissionsEdit && !allowed {
+		return row, ErrPermissionLockout
+	}
+	if !models.Role(role).IsBuiltin() && permission == models.PermTenantPermissionsEdit && allowed {
+		return row, ErrPermissionNotDelegable
+	}
+	err := db.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "role"}, {Name: "permission"}},
+		DoUpdates: clause.AssignmentColumns([]string{"allowed", "updated_at"}),
+	}).Create(&row).Error
+	invalidatePolicy(tenantID)
+	if err != nil {
+		return row, err
+	}
+	return row, resyncCustomRole(ctx, tenantID, models.Role(role))
+}
+
+// ClearTenantPermission removes a tenant override so the default applies again
+func ClearTenantPermission(ctx context.Context, tenantID, role string, permission models.Permission) error {
+	err := db.DB.WithContext(ctx).Where("tenant_id = ? AND role = ? AND permission = ?", tenantID, role, permission).
+		Delete(&models.RolePermission{}).Error
+	invalidatePolicy(tenantID)
+	if err != nil {
+		return err
+	}
+	return resyncCustomRole(ctx, tenantID, models.Role(role))
+}
+
+func invalidatePolicy(tenantID string) {
+	policyCacheMu.Lock()
+	delete(policyCache, tenantID)
+	policyCacheMu.Unlock()
+}
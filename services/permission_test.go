@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvePolicyAppliesTenantOverrides(t *testing.T) {
+	policy := resolvePolicy([]models.RolePermission{
+		{TenantID: "t1", Role: "MODERATOR", Permission: models.PermChannelCreate, Allowed: false},
+		{Role: "MODERATOR", Permission: models.PermChannelCreate, Allowed: true},
+		{Role: "MEMBER", Permission: models.PermChannelCreate, Allowed: false},
+		{TenantID: "t1", Role: "MEMBER", Permission: models.PermMessageDeleteAny, Allowed: true},
+	})
+
+	assert.False(t, policy["MODERATOR"][models.PermChannelCreate])
+	assert.False(t, policy["MEMBER"][models.PermChannelCreate])
+	assert.True(t, policy["MEMBER"][models.PermMessageDeleteAny])
+	assert.False(t, policy["GUEST"][models.PermMessageSend])
+}
+
+func TestDefaultRolePermissionsAreCatalogued(t *testing.T) {
+	for role, permissions := range DefaultRolePermissions {
+		for _, p := range permissions {
+			assert.True(t, IsKnownPermission(p), "%s: %s", role, p)
+		}
+	}
+	assert.Len(t, DefaultRolePermissions[models.RoleAdmin], len(PermissionCatalog))
+}
+
+func TestPolicyWithin(t *testing.T) {
+	policy := resolvePolicy([]models.RolePermission{
+		{Role: "ADMIN", Permission: models.PermUserUpdate, Allowed: true},
+		{Role: "ADMIN", Permission: models.PermTenantManage, Allowed: true},
+		{Role: "MODERATOR", Permission: models.PermUserUpdate, Allowed: true},
+		{Role: "MODERATOR", Permission: models.PermTenantManage, Allowed: false},
+		{Role: "MEMBER", Permission: models.PermTenantManage, Allowed: false},
+	})
+
+	assert.True(t, policy.Within("ADMIN", "MODERATOR"))
+	assert.True(t, policy.Within("MODERATOR", "MEMBER"))
+	assert.True(t, policy.Within("MODERATOR", "UNKNOWN"))
+	assert.False(t, policy.Within("MODERATOR", "ADMIN"))
+	assert.False(t, policy.Within("MEMBER", "MODERATOR"))
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	stream_chat "github.com/GetStream/stream-chat-go/v5"
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultPlanID is the plan of tenants that were not assigned one
+const DefaultPlanID = "free"
+
+// Quota names, as reported in QuotaError and the usage report
+const (
+	QuotaUsers             = "users"
+	QuotaChannels          = "channels"
+	QuotaMembersPerChannel = "members_per_channel"
+	QuotaMessagesPerDay    = "messages_per_day"
+	QuotaStorage           = "storage_bytes"
+)
+
+var (
+	ErrPlanNotFound = errors.New("plan not found")
+	// ErrAttachmentSizeRequired is returned for an attachment with a file
+	// but without a positive file_size, which storage could not be charged for
+	ErrAttachmentSizeRequired = errors.New("attachments with an asset or image URL must declare a positive file_size")
+)
+
+// DefaultPlans are seeded into the plans table. Changing the table takes
+// effect without a redeploy; changing this list only adds missing plans.
+var DefaultPlans = []models.Plan{
+	{ID: "free", Name: "Free", MaxUsers: 10, MaxChannels: 5, MaxMembersPerChannel: 10, MaxMessagesPerDay: 1000, MaxStorageBytes: 100 << 20},
+	{ID: "team", Name: "Team", MaxUsers: 250, MaxChannels: 200, MaxMembersPerChannel: 250, MaxMessagesPerDay: 100000, MaxStorageBytes: 25 << 30},
+	{ID: "enterprise", Name: "Enterprise"},
+}
+
+// QuotaError reports that an action would exceed a limit of the tenant's plan
+type QuotaError struct {
+	Quota string
+	Limit int64
+	Used  int64
+	// RetryAfter is set for limits that reset, i.e. the daily message limit
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("plan limit reached: %s (%d of %d)", e.Quota, e.Used, e.Limit)
+}
+
+// UsageItem is the consumption of one quota; a Limit of 0 means unlimited
+type UsageItem struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+// UsageReport shows a tenant's consumption against its plan's limits
+type UsageReport struct {
+	Plan     models.Plan `json:"plan"`
+	Users    UsageItem   `json:"users"`
+	Channels UsageItem   `json:"channels"`
+	// LargestChannel is the member count of the tenant's largest channel
+	LargestChannel UsageItem `json:"largest_channel"`
+	MessagesToday  UsageItem `json:"messages_today"`
+	Storage        UsageItem `json:"storage_bytes"`
+	// ResetsAt is when the daily message count starts over
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// SeedPlans inserts the default plans that are missing from the plans table
+func SeedPlans() error {
+	plans := append([]models.Plan{}, DefaultPlans...)
+	return db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&plans).Error
+}
+
+func ListPlans() ([]models.Plan, error) {
+	var plans []models.Plan
+	err := db.DB.Order("max_users = 0, max_users").Find(&plans).Error
+	return plans, err
+}
+
+// GetPlan returns the plan with the given ID, or ErrPlanNotFound
+func GetPlan(planID string) (models.Plan, error) {
+	var plan models.Plan
+	if err := db.DB.Where("id = ?", planID).First(&plan).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return plan, ErrPlanNotFound
+		}
+		return plan, err
+	}
+	return plan, nil
+}
+
+// TenantPlan returns the plan of a tenant
+func TenantPlan(tenantID string) (models.Plan, error) {
+	var plan models.Plan
+	var tenant models.Tenant
+	if err := db.DB.Select("id", "plan_id").Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+		return plan, err
+	}
+	planID := tenant.PlanID
+	if planID == "" {
+		planID = DefaultPlanID
+	}
+	err := db.DB.Where("id = ?", planID).First(&plan).Error
+	return plan, err
+}
+
+// CheckUserQuota fails when the tenant cannot have another (enabled) user.
+// Call it inside repos.Tenants.Lock and create the user with the same
+// repositories, so that concurrent requests cannot both take the last place.
+func CheckUserQuota(ctx context.Context, repos repository.Repositories, tenantID string) error {
+	plan, err := TenantPlan(tenantID)
+	if err != nil {
+		return err
+	}
+	if plan.MaxUsers == 0 {
+		return nil
+	}
+	used, err := repos.Users.CountEnabled(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	return checkLimit(QuotaUsers, plan.MaxUsers, used, 1)
+}
+
+// CheckChannelQuota fails when the tenant cannot have another channel. Like
+// CheckUserQuota, it belongs inside repos.Tenants.Lock.
+func CheckChannelQuota(ctx context.Context, repos repository.Repositories, tenantID string) error {
+	plan, err := TenantPlan(tenantID)
+	if err != nil {
+		return err
+	}
+	if plan.MaxChannels == 0 {
+		return nil
+	}
+	used, err := repos.Channels.Count(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	return checkLimit(QuotaChannels, plan.MaxChannels, used, 1)
+}
+
+// CheckChannelMemberQuota fails when adding members to the channel would
+// exceed the plan's limit. An empty channelID checks a new channel. Like
+// CheckUserQuota, it belongs inside repos.Tenants.Lock.
+func CheckChannelMemberQuota(ctx context.Context, repos repository.Repositories, tenantID, channelID string, adding int64) error {
+	plan, err := TenantPlan(tenantID)
+	if err != nil {
+		return err
+	}
+	if plan.MaxMembersPerChannel == 0 {
+		return nil
+	}
+	var used int64
+	if channelID != "" {
+		if used, err = repos.Memberships.Count(ctx, tenantID, channelID); err != nil {
+			return err
+		}
+	}
+	return checkLimit(QuotaMembersPerChannel, plan.MaxMembersPerChannel, used, adding)
+}
+
+// ReserveMessage counts a message of size bytes against the tenant's daily
+// message limit and storage limit. The daily count is incremented atomically,
+// and the storage limit is checked with the tenant row locked, so concurrent
+// senders cannot exceed either. Call ReleaseMessage when the message could
+// not be sent after all.
+func ReserveMessage(tenantID string, size int64) error {
+	plan, err := TenantPlan(tenantID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if plan.MaxStorageBytes > 0 {
+			// see repository.TenantRepository.Lock
+			var tenant models.Tenant
+			if err := tx.Clauses(clause.Locking{Strength: "NO KEY UPDATE"}).Select("id").Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+				return err
+			}
+			used, err := storageUsed(tx, tenantID)
+			if err != nil {
+				return err
+			}
+			if err := checkLimit(QuotaStorage, plan.MaxStorageBytes, used, size); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Exec(`INSERT INTO tenant_daily_usages (tenant_id, day, messages, storage_bytes) VALUES (?, ?, 1, ?)
+			ON CONFLICT (tenant_id, day) DO UPDATE
+			SET messages = tenant_daily_usages.messages + 1, storage_bytes = tenant_daily_usages.storage_bytes + EXCLUDED.storage_bytes
+			WHERE ? = 0 OR tenant_daily_usages.messages < ?`,
+			tenantID, usageDay(now), size, plan.MaxMessagesPerDay, plan.MaxMessagesPerDay)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &QuotaError{
+				Quota:      QuotaMessagesPerDay,
+				Limit:      plan.MaxMessagesPerDay,
+				Used:       plan.MaxMessagesPerDay,
+				RetryAfter: nextUsageDay(now).Sub(now),
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseMessage undoes ReserveMessage for a message that was not sent
+func ReleaseMessage(tenantID string, size int64) error {
+	return db.DB.Model(&models.TenantDailyUsage{}).
+		Where("tenant_id = ? AND day = ?", tenantID, usageDay(time.Now())).
+		Updates(map[string]interface{}{
+			"messages":      clause.Expr{SQL: "GREATEST(messages - 1, 0)"},
+			"storage_bytes": clause.Expr{SQL: "storage_bytes - ?", Vars: []interface{}{size}},
+		}).Error
+}
+
+// ReleaseStorage gives back the storage of a deleted message
+func ReleaseStorage(tenantID string, size int64) error {
+	return db.DB.Exec(`INSERT INTO tenant_daily_usages (tenant_id, day, messages, storage_bytes) VALUES (?, ?, 0, ?)
+		ON CONFLICT (tenant_id, day) DO UPDATE SET storage_bytes = tenant_daily_usages.storage_bytes + EXCLUDED.storage_bytes`,
+		tenantID, usageDay(time.Now()), -size).Error
+}
+
+// MessageSize is the storage a message counts for: its text plus the
+// declared file_size of its attachments
+func MessageSize(text string, attachments []*stream_chat.Attachment) int64 {
+	size := int64(len(text))
+	for _, a := range attachments {
+		if a == nil {
+			continue
+		}
+		size += attachmentSize(a)
+	}
+	return size
+}
+
+// CheckAttachmentSizes rejects attachments with an asset or image URL but
+// without a positive file_size. The size is declared by the client, so an
+// attachment could otherwise carry a file without counting against the
+// storage limit.
+func CheckAttachmentSizes(attachments []*stream_chat.Attachment) error {
+	for _, a := range attachments {
+		if a == nil || (a.AssetURL == "" && a.ImageURL == "") {
+			continue
+		}
+		if attachmentSize(a) <= 0 {
+			return ErrAttachmentSizeRequired
+		}
+	}
+	return nil
+}
+
+func attachmentSize(a *stream_chat.Attachment) int64 {
+	if fileSize, ok := a.ExtraData["file_size"].(float64); ok && fileSize > 0 {
+		return int64(fileSize)
+	}
+	return 0
+}
+
+// TenantUsage reports the tenant's consumption against its plan
+func TenantUsage(tenantID string) (UsageReport, error) {
+	var report UsageReport
+	plan, err := TenantPlan(tenantID)
+	if err != nil {
+		return report, err
+	}
+	report.Plan = plan
+	now := time.Now()
+	report.ResetsAt = nextUsageDay(now)
+
+	if report.Users.Used, err = countUsers(tenantID); err != nil {
+		return report, err
+	}
+	report.Users.Limit = plan.MaxUsers
+
+	if err := db.DB.Model(&models.Channel{}).Where("tenant_id = ?", tenantID).Count(&report.Channels.Used).Error; err != nil {
+		return report, err
+	}
+	report.Channels.Limit = plan.MaxChannels
+
+	if err := db.DB.Raw(`SELECT COALESCE(MAX(members), 0) FROM
+		(SELECT COUNT(*) AS members FROM channel_members WHERE tenant_id = ? GROUP BY channel_id) AS counts`, tenantID).
+		Scan(&report.LargestChannel.Used).Error; err != nil {
+		return report, err
+	}
+	report.LargestChannel.Limit = plan.MaxMembersPerChannel
+
+	var today models.TenantDailyUsage
+	if err := db.DB.Where("tenant_id = ? AND day = ?", tenantID, usageDay(now)).Limit(1).Find(&today).Error; err != nil {
+		return report, err
+	}
+	report.MessagesToday = UsageItem{Used: today.Messages, Limit: plan.MaxMessagesPerDay}
+
+	if report.Storage.Used, err = storageUsed(db.DB, tenantID); err != nil {
+		return report, err
+	}
+	report.Storage.Limit = plan.MaxStorageBytes
+	return report, nil
+}
+
+// checkLimit returns a QuotaError when used+adding exceeds limit (0 = unlimited)
+func checkLimit(quota string, limit, used, adding int64) error {
+	if limit > 0 && used+adding > limit {
+		return &QuotaError{Quota: quota, Limit: limit, Used: used}
+	}
+	return nil
+}
+
+func countUsers(tenantID string) (int64, error) {
+	var count int64
+	err := db.DB.Model(&models.User{}).Where("tenant_id = ? AND disabled = ?", tenantID, false).Count(&count).Error
+	return count, err
+}
+
+func storageUsed(tx *gorm.DB, tenantID string) (int64, error) {
+	var used int64
+	err := tx.Model(&models.TenantDailyUsage{}).Select("COALESCE(SUM(storage_bytes), 0)").
+		Where("tenant_id = ?", tenantID).Scan(&used).Error
+	return used, err
+}
+
+// usageDay is the UTC day usage is counted on
+func usageDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func nextUsageDay(t time.Time) time.Time {
+	return usageDay(t).AddDate(0, 0, 1)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	stream_chat "github.com/GetStream/stream-chat-go/v5"
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLimit(t *testing.T) {
+	assert.NoError(t, checkLimit(QuotaUsers, 0, 1000, 1), "0 is unlimited")
+	assert.NoError(t, checkLimit(QuotaUsers, 10, 9, 1))
+
+	err := checkLimit(QuotaUsers, 10, 10, 1)
+	var quotaErr *QuotaError
+	assert.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, QuotaUsers, quotaErr.Quota)
+	assert.Equal(t, int64(10), quotaErr.Used)
+	assert.Zero(t, quotaErr.RetryAfter)
+
+	assert.Error(t, checkLimit(QuotaMembersPerChannel, 10, 5, 6), "adding several members at once")
+}
+
+func TestUserQuotaIsCheckedUnderTheTenantLock(t *testing.T) {
+	ctx := context.Background()
+	mock := testutil.SetupMockDB(t)
+	repos := repository.NewGORM(db.DB)
+	createUser := func(email string) error {
+		return repos.Tenants.Lock(ctx, testutil.TenantOne, func(tx repository.Repositories) error {
+			if err := CheckUserQuota(ctx, tx, testutil.TenantOne); err != nil {
+				return err
+			}
+			return tx.Users.Create(ctx, testutil.TenantOne, &models.User{Email: email, Password: "x"})
+		})
+	}
+	// the count runs after the lock is taken, in the transaction that inserts
+	expectCount := func(used int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT "id" FROM "tenants" WHERE id = \$1 .* FOR NO KEY UPDATE`).
+			WithArgs(testutil.TenantOne, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testutil.TenantOne))
+		mock.ExpectQuery(`SELECT "id","plan_id" FROM "tenants"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "plan_id"}).AddRow(testutil.TenantOne, "tiny"))
+		mock.ExpectQuery(`SELECT \* FROM "plans"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "max_users"}).AddRow("tiny", 3))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE tenant_id = \$1 AND disabled = \$2`).
+			WithArgs(testutil.TenantOne, false).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(used))
+	}
+
+	expectCount(2)
+	mock.ExpectExec(`INSERT INTO "users"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, createUser("third@example.com"))
+
+	expectCount(3)
+	mock.ExpectRollback()
+	var quotaErr *QuotaError
+	if assert.ErrorAs(t, createUser("fourth@example.com"), &quotaErr) {
+		assert.Equal(t, QuotaUsers, quotaErr.Quota)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageSize(t *testing.T) {
+	attachments := []*stream_chat.Attachment{
+		{Type: "file", ExtraData: map[string]interface{}{"file_size": float64(2048)}},
+		{Type: "image"},
+		nil,
+	}
+	assert.Equal(t, int64(5+2048), MessageSize("hello", attachments))
+}
+
+func TestCheckAttachmentSizes(t *testing.T) {
+	assert.NoError(t, CheckAttachmentSizes([]*stream_chat.Attachment{
+		{Type: "file", AssetURL: "https://cdn.test/a.pdf", ExtraData: map[string]interface{}{"file_size": float64(2048)}},
+		// links carry no file
+		{Type: "image", OGScrapeURL: "https://example.com"},
+		nil,
+	}))
+	for _, attachment := range []*stream_chat.Attachment{
+		{Type: "file", AssetURL: "https://cdn.test/a.pdf"},
+		{Type: "image", ImageURL: "https://cdn.test/a.png", ExtraData: map[string]interface{}{"file_size": float64(0)}},
+		{Type: "video", AssetURL: "https://cdn.test/a.mp4", ExtraData: map[string]interface{}{"file_size": "2048"}},
+	} {
+		assert.ErrorIs(t, CheckAttachmentSizes([]*stream_chat.Attachment{attachment}), ErrAttachmentSizeRequired, attachment.Type)
+	}
+}
+
+func TestUsageDayIsUTC(t *testing.T) {
+	nairobi := time.FixedZone("EAT", 3*60*60)
+	now := time.Date(2024, 5, 31, 1, 30, 0, 0, nairobi)
+	assert.Equal(t, time.Date(2024, 5, 30, 0, 0, 0, 0, time.UTC), usageDay(now))
+	assert.Equal(t, time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC), nextUsageDay(now))
+}
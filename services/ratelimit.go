@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept; by then it is full
+// again for any sensible limit, so dropping it changes nothing
+const idleBucketTTL = time.Hour
+
+var ErrInvalidRateLimit = errors.New("invalid rate limit")
+
+// RateLimit is a token bucket: Burst requests at once, refilled at Requests
+// per Period
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// Enabled reports whether the limit restricts anything
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// perSecond is the refill rate
+func (l RateLimit) perSecond() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available (0 when allowed)
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// RateLimitStore keeps token buckets
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// ParseRateLimit parses "requests/period" with an optional ",burst", e.g.
+// "10/1m" or "60/1m,20". "off" disables the limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" {
+		return RateLimit{}, nil
+	}
+	spec, burst, hasBurst := strings.Cut(s, ",")
+	requests, period, ok := strings.Cut(spec, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("%w: %q", ErrInvalidRateLimit, s)
+	}
+	var limit RateLimit
+	var err error
+	if limit.Requests, err = strconv.Atoi(strings.TrimSpace(requests)); err != nil || limit.Requests < 1 {
+		return RateLimit{}, fmt.Errorf("%w: %q", ErrInvalidRateLimit, s)
+	}
+	if limit.Period, err = time.ParseDuration(strings.TrimSpace(period)); err != nil || limit.Period <= 0 {
+		return RateLimit{}, fmt.Errorf("%w: %q", ErrInvalidRateLimit, s)
+	}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || limit.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("%w: %q", ErrInvalidRateLimit, s)
+		}
+	}
+	return limit, nil
+}
+
+// RateLimitOverride returns the limit of a route group: override parsed when
+// set, def otherwise
+func RateLimitOverride(name, override string, def RateLimit) (RateLimit, error) {
+	if override == "" {
+		return def, nil
+	}
+	limit, err := ParseRateLimit(override)
+	if err != nil {
+		return limit, fmt.Errorf("RATE_LIMIT_%s: %w", strings.ToUpper(name), err)
+	}
+	return limit, nil
+}
+
+// takeToken refills a bucket holding tokens at last and takes one token
+func takeToken(tokens float64, last, now time.Time, limit RateLimit) (float64, RateLimitResult) {
+	burst := limit.burst()
+	rate := limit.perSecond()
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(burst, tokens+elapsed*rate)
+	}
+	result := RateLimitResult{Limit: int(burst)}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - tokens) / rate)
+	}
+	result.Remaining = int(tokens)
+	result.ResetAfter = secondsDuration((burst - tokens) / rate)
+	return tokens, result
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryRateLimitStore keeps buckets in process memory. Each instance limits
+// on its own, so behind a load balancer the effective limit is multiplied by
+// the number of instances.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]memoryBucket{}, now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.prune(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = memoryBucket{tokens: limit.burst(), last: now}
+	}
+	tokens, result := takeToken(bucket.tokens, bucket.last, now, limit)
+	s.buckets[key] = memoryBucket{tokens: tokens, last: now}
+	return result, nil
+}
+
+// prune drops idle buckets at most once a minute
+func (s *MemoryRateLimitStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.last) > idleBucketTTL {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// PostgresRateLimitStore keeps buckets in the rate_limit_buckets table so
+// that all instances share the same limits
+type PostgresRateLimitStore struct{}
+
+func (PostgresRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	var result RateLimitResult
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.RateLimitBucket{Key: key, Tokens: limit.burst(), UpdatedAt: now}).Error; err != nil {
+			return err
+		}
+		var bucket models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&bucket).Error; err != nil {
+			return err
+		}
+		var tokens float64
+		tokens, result = takeToken(bucket.Tokens, bucket.UpdatedAt, now, limit)
+		return tx.Model(&bucket).Updates(map[string]interface{}{"tokens": tokens, "updated_at": now}).Error
+	})
+	return result, err
+}
+
+// PruneRateLimitBuckets deletes idle buckets from the shared store
+func PruneRateLimitBuckets() error {
+	return db.DB.Where("updated_at < ?", time.Now().Add(-idleBucketTTL)).Delete(&models.RateLimitBucket{}).Error
+}
+
+var rateLimitStore RateLimitStore
+var rateLimitStoreOnce sync.Once
+
+// GetRateLimitStore returns the configured store, limiting in memory until
+// SetRateLimitStore is called
+func GetRateLimitStore() RateLimitStore {
+	rateLimitStoreOnce.Do(func() {
+		if rateLimitStore == nil {
+			rateLimitStore = NewMemoryRateLimitStore()
+		}
+	})
+	return rateLimitStore
+}
+
+// NewRateLimitStore returns the store of a RATE_LIMIT_BACKEND: postgres
+// shares limits between instances, memory limits each one on its own
+func NewRateLimitStore(backend string) RateLimitStore {
+	if backend == "postgres" {
+		go pruneRateLimitBucketsPeriodically()
+		return PostgresRateLimitStore{}
+	}
+	return NewMemoryRateLimitStore()
+}
+
+// SetRateLimitStore sets the store, built from the loaded config when
+// serving, or a fake one in tests
+func SetRateLimitStore(s RateLimitStore) {
+	rateLimitStore = s
+}
+
+func pruneRateLimitBucketsPeriodically() {
+	for range time.Tick(10 * time.Minute) {
+		if err := PruneRateLimitBuckets(); err != nil {
+			slog.Error("failed to prune rate limit buckets", "error", err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("60/1m,20")
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimit{Requests: 60, Period: time.Minute, Burst: 20}, limit)
+
+	limit, err = ParseRateLimit("10/1s")
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, limit.burst(), "burst defaults to the request count")
+
+	limit, err = ParseRateLimit("off")
+	assert.NoError(t, err)
+	assert.False(t, limit.Enabled())
+
+	for _, bad := range []string{"", "10", "0/1m", "10/0s", "10/abc", "10/1m,0"} {
+		_, err := ParseRateLimit(bad)
+		assert.ErrorIs(t, err, ErrInvalidRateLimit, bad)
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	limit := RateLimit{Requests: 60, Period: time.Minute, Burst: 2}
+	now := time.Now()
+
+	tokens, result := takeToken(2, now, now, limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	tokens, result = takeToken(tokens, now, now, limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 2*time.Second, result.ResetAfter)
+
+	tokens, result = takeToken(tokens, now, now, limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	_, result = takeToken(tokens, now, now.Add(time.Second), limit)
+	assert.True(t, result.Allowed, "refilled one token per second")
+
+	tokens, _ = takeToken(0, now, now.Add(time.Hour), limit)
+	assert.Equal(t, 1.0, tokens, "refill is capped at the burst")
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 1, Period: time.Minute}
+
+	result, _ := store.Take(context.Background(), "a", limit)
+	assert.True(t, result.Allowed)
+	result, _ = store.Take(context.Background(), "a", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Minute, result.RetryAfter)
+	result, _ = store.Take(context.Background(), "b", limit)
+	assert.True(t, result.Allowed, "keys have their own buckets")
+
+	now = now.Add(2 * idleBucketTTL)
+	store.Take(context.Background(), "b", limit)
+	_, kept := store.buckets["a"]
+	assert.False(t, kept, "idle buckets are pruned")
+}
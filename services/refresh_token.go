@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// IssueRefreshToken stores a new refresh token for the user and returns the
+// raw token. An empty familyID starts a new family (i.e. a new login).
+func IssueRefreshToken(user models.User, familyID string) (string, error) {
+	raw, _, err := issueRefreshToken(user, familyID)
+	return raw, err
+}
+
+func issueRefreshToken(user models.User, familyID string) (string, models.RefreshToken, error) {
+	var token models.RefreshToken
+	raw, err := utils.NewOpaqueToken()
+	if err != nil {
+		return "", token, err
+	}
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+
+	token = models.RefreshToken{
+		UserID:    user.ID,
+		TenantID:  user.TenantID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+	}
+	if err := db.DB.Create(&token).Error; err != nil {
+		return "", token, err
+	}
+	return raw, token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family and returns the owning user. Presenting a token that was already
+// rotated or revoked revokes every token in its family. Users of a suspended
+// tenant get ErrTenantSuspended.
+func RotateRefreshToken(raw string) (models.User, string, error) {
+	var user models.User
+
+	var current models.RefreshToken
+	if err := db.DB.Where("token_hash = ?", utils.HashToken(raw)).First(&current).Error; err != nil {
+		return user, "", ErrInvalidRefreshToken
+	}
+
+	if current.RevokedAt != nil {
+		slog.Warn("refresh token reuse detected, revoking token family", "user_id", current.UserID, "family_id", current.FamilyID)
+		if err := RevokeRefreshTokenFamily(current.FamilyID); err != nil {
+			return user, "", err
+		}
+		return user, "", ErrRefreshTokenReused
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return user, "", ErrInvalidRefreshToken
+	}
+
+	if err := db.DB.Where("id = ?", current.UserID).First(&user).Error; err != nil || user.Disabled {
+		return user, "", ErrInvalidRefreshToken
+	}
+	if err := CheckTenantActive(user.TenantID); err != nil {
+		return user, "", err
+	}
+
+	next, nextToken, err := issueRefreshToken(user, current.FamilyID)
+	if err != nil {
+		return user, "", err
+	}
+
+	// Only one concurrent rotation may win; the loser is treated as reuse.
+	result := db.DB.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", current.ID).
+		Updates(map[string]interface{}{
+			"revoked_at":  time.Now(),
+			"replaced_by": nextToken.ID,
+		})
+	if result.Error != nil {
+		return user, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := RevokeRefreshTokenFamily(current.FamilyID); err != nil {
+			return user, "", err
+		}
+		return user, "", ErrRefreshTokenReused
+	}
+
+	return user, next, nil
+}
+
+// RevokeRefreshTokenFamily revokes every live token descending from the same login
+func RevokeRefreshTokenFamily(familyID string) error {
+	return db.DB.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
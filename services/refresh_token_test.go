@@ -0,0 +1,93 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// refreshTokenRows is the stored refresh token "old" of UserOne
+func refreshTokenRows(revokedAt interface{}) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "user_id", "tenant_id", "family_id", "token_hash", "expires_at", "revoked_at"}).
+		AddRow("token-1", testutil.UserOne, testutil.TenantOne, "family-1", utils.HashToken("old"), time.Now().Add(time.Hour), revokedAt)
+}
+
+func expectFamilyRevoked(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE family_id = \$2 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), "family-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	mock := testutil.SetupMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).WithArgs(utils.HashToken("old"), 1).WillReturnRows(refreshTokenRows(nil))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(testutil.MockUserRows())
+	mock.ExpectQuery(`SELECT "id","suspended" FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "suspended"}).AddRow(testutil.TenantOne, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "refresh_tokens"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// the presented token is revoked and points at its replacement
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "replaced_by"=\$1,"revoked_at"=\$2 WHERE id = \$3 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "token-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, next, err := RotateRefreshToken("old")
+	assert.NoError(t, err)
+	assert.Equal(t, testutil.UserOne, user.ID)
+	assert.NotEmpty(t, next)
+	assert.NotEqual(t, "old", next)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRotateRefreshTokenReuseRevokesFamily(t *testing.T) {
+	mock := testutil.SetupMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).WillReturnRows(refreshTokenRows(time.Now().Add(-time.Minute)))
+	expectFamilyRevoked(mock)
+
+	_, _, err := RotateRefreshToken("old")
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// losing a concurrent rotation of the same token counts as reuse too
+	mock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).WillReturnRows(refreshTokenRows(nil))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(testutil.MockUserRows())
+	mock.ExpectQuery(`SELECT "id","suspended" FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "suspended"}).AddRow(testutil.TenantOne, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "refresh_tokens"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "replaced_by"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	expectFamilyRevoked(mock)
+
+	_, _, err = RotateRefreshToken("old")
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRotateRefreshTokenRefusesDisabledUsersAndSuspendedTenants(t *testing.T) {
+	mock := testutil.SetupMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).WillReturnRows(refreshTokenRows(nil))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "email", "tenant_id", "disabled"}).
+		AddRow(testutil.UserOne, "user1@example.com", testutil.TenantOne, true))
+
+	_, _, err := RotateRefreshToken("old")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).WillReturnRows(refreshTokenRows(nil))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(testutil.MockUserRows())
+	mock.ExpectQuery(`SELECT "id","suspended" FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "suspended"}).AddRow(testutil.TenantOne, true))
+
+	_, _, err = RotateRefreshToken("old")
+	assert.ErrorIs(t, err, ErrTenantSuspended)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
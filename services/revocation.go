@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"gorm.io/gorm"
+)
+
+var ErrAccountDisabled = errors.New("account is disabled")
+
+// The revocation store is two-fold: individual access tokens are revoked by
+// jti in the revoked_tokens table, and all tokens of a user are revoked at
+// once by bumping users.token_version.
+
+// RevokeToken revokes a single access token until it would have expired
+func RevokeToken(jti, userID string, expiresAt time.Time) error {
+	// opportunistically drop revocations for tokens that have expired anyway
+	db.DB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{})
+
+	return db.DB.Create(&models.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}).Error
+}
+
+// RevokeAllUserTokens invalidates every access and refresh token of a user,
+// e.g. after deletion, demotion or a password change.
+func RevokeAllUserTokens(userID string) error {
+	if err := db.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+		return err
+	}
+	return db.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeRefreshToken revokes the family of the given raw refresh token, if it exists
+func RevokeRefreshToken(raw string) error {
+	var token models.RefreshToken
+	if err := db.DB.Where("token_hash = ?", utils.HashToken(raw)).First(&token).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	return RevokeRefreshTokenFamily(token.FamilyID)
+}
+
+// IsTokenRevoked reports whether an otherwise valid access token has been
+// revoked, either individually or because the user's token version moved on
+// (which includes the user having been deleted) or the user is disabled.
+func IsTokenRevoked(claims *utils.Claims) bool {
+	if claims.ID == "" {
+		return true
+	}
+
+	var count int64
+	if err := db.DB.Model(&models.RevokedToken{}).Where("jti = ?", claims.ID).Count(&count).Error; err != nil || count > 0 {
+		return true
+	}
+
+	var user models.User
+	if err := db.DB.Select("id", "token_version", "disabled").Where("id = ?", claims.UserID).First(&user).Error; err != nil {
+		return true
+	}
+	return user.Disabled || user.TokenVersion != claims.TokenVersion
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/metrics"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRoleName = errors.New("role name must contain letters or digits and must not be a built-in role")
+	ErrRoleExists      = errors.New("a role with this name already exists")
+	ErrRoleInUse       = errors.New("role is still assigned to users")
+	ErrRoleNotFound    = errors.New("role not found")
+	// ErrPermissionNotDelegable is returned for permissions custom roles cannot hold
+	ErrPermissionNotDelegable = errors.New("permission cannot be granted to custom roles")
+)
+
+var nonKeyChars = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// streamGrants maps our permissions onto Stream permissions of the
+// "messaging" channel type, granted to a custom role's Stream role
+var streamGrants = map[models.Permission][]string{
+	models.PermMessageSend: {
+		"read-channel", "create-message", "update-message-owner", "delete-message-owner",
+		"create-reaction", "delete-reaction-owner", "upload-attachment",
+	},
+	models.PermMessageDeleteAny:     {"delete-message"},
+	models.PermChannelJoin:          {"add-own-channel-membership", "remove-own-channel-membership"},
+	models.PermChannelMembersManage: {"update-channel-members"},
+	models.PermChannelCreate:        {"create-channel"},
+}
+
+// RoleKey derives the role key stored on users from a display name,
+// e.g. "Support Agent" becomes "SUPPORT_AGENT"
+func RoleKey(name string) models.Role {
+	return models.Role(strings.Trim(nonKeyChars.ReplaceAllString(strings.ToUpper(name), "_"), "_"))
+}
+
+// IsValidRole reports whether role is a built-in role or one of the tenant's custom roles
+func IsValidRole(tenantID string, role models.Role) bool {
+	if role.IsBuiltin() {
+		return true
+	}
+	var count int64
+	if err := db.DB.Model(&models.CustomRole{}).Where("tenant_id = ? AND key = ?", tenantID, role).Count(&count).Error; err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// ListCustomRoles returns the tenant's custom roles with their permissions
+func ListCustomRoles(tenantID string) ([]models.CustomRole, error) {
+	var roles []models.CustomRole
+	if err := db.DB.Where("tenant_id = ?", tenantID).Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	policy, err := TenantPolicy(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		roles[i].Permissions = grantedPermissions(policy, string(roles[i].Key))
+	}
+	return roles, nil
+}
+
+// GetCustomRole loads one of the tenant's custom roles
+func GetCustomRole(tenantID, id string) (models.CustomRole, error) {
+	var role models.CustomRole
+	if err := db.DB.Where(QueryByIDAndTenantIdLiteral, id, tenantID).First(&role).Error; err != nil {
+		if db.IsRecordNotFoundError(err) {
+			return role, ErrRoleNotFound
+		}
+		return role, err
+	}
+	policy, err := TenantPolicy(tenantID)
+	if err != nil {
+		return role, err
+	}
+	role.Permissions = grantedPermissions(policy, string(role.Key))
+	return role, nil
+}
+
+// CreateCustomRole creates a tenant role composed of permissions and the
+// matching custom role on Stream
+func CreateCustomRole(ctx context.Context, tenantID, name, description string, permissions []models.Permission) (models.CustomRole, error) {
+	name = strings.TrimSpace(name)
+	key := RoleKey(name)
+	role := models.CustomRole{TenantID: tenantID, Key: key, Name: name, Description: description}
+	if key == "" || key.IsBuiltin() {
+		return role, ErrInvalidRoleName
+	}
+	if err := validatePermissions(permissions); err != nil {
+		return role, err
+	}
+	if IsValidRole(tenantID, key) {
+		return role, ErrRoleExists
+	}
+	role.StreamRole = streamRoleName(tenantID, key)
+
+	if err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+		return replaceRolePermissions(tx, tenantID, key, permissions)
+	}); err != nil {
+		return role, err
+	}
+	invalidatePolicy(tenantID)
+	role.Permissions = permissions
+
+	if err := syncStreamRole(ctx, role); err != nil {
+		return role, err
+	}
+	return role, nil
+}
+
+// UpdateCustomRole renames a role and replaces its permissions. The key
+// users reference is kept.
+func UpdateCustomRole(ctx context.Context, role models.CustomRole, name, description string, permissions []models.Permission) (models.CustomRole, error) {
+	if name = strings.TrimSpace(name); name != "" {
+		role.Name = name
+	}
+	role.Description = description
+	if err := validatePermissions(permissions); err != nil {
+		return role, err
+	}
+
+	if err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&role).Error; err != nil {
+			return err
+		}
+		return replaceRolePermissions(tx, role.TenantID, role.Key, permissions)
+	}); err != nil {
+		return role, err
+	}
+	invalidatePolicy(role.TenantID)
+	role.Permissions = permissions
+
+	if err := syncStreamRole(ctx, role); err != nil {
+		return role, err
+	}
+	return role, nil
+}
+
+// DeleteCustomRole removes a role that is no longer assigned to anyone
+func DeleteCustomRole(ctx context.Context, role models.CustomRole) error {
+	var assigned int64
+	if err := db.DB.WithContext(ctx).Model(&models.User{}).Where("tenant_id = ? AND role = ?", role.TenantID, role.Key).Count(&assigned).Error; err != nil {
+		return err
+	}
+	if assigned > 0 {
+		return ErrRoleInUse
+	}
+
+	if err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ? AND role = ?", role.TenantID, role.Key).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	}); err != nil {
+		return err
+	}
+	invalidatePolicy(role.TenantID)
+
+	start := time.Now()
+	_, err := GetStreamClient().Permissions().DeleteRole(ctx, role.StreamRole)
+	metrics.ObserveStreamCall(metrics.StreamDeleteRole, start, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete stream role", "stream_role", role.StreamRole, "error", err)
+	}
+	return nil
+}
+
+func validatePermissions(permissions []models.Permission) error {
+	for _, p := range permissions {
+		if !IsKnownPermission(p) {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+		// custom roles cannot hand out the right to edit roles and permissions
+		if p == models.PermTenantPermissionsEdit {
+			return fmt.Errorf("%w: %s", ErrPermissionNotDelegable, p)
+		}
+	}
+	return nil
+}
+
+func replaceRolePermissions(tx *gorm.DB, tenantID string, key models.Role, permissions []models.Permission) error {
+	if err := tx.Where("tenant_id = ? AND role = ?", tenantID, key).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	for _, p := range permissions {
+		if err := tx.Create(&models.RolePermission{TenantID: tenantID, Role: string(key), Permission: p, Allowed: true}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func grantedPermissions(policy Policy, role string) []models.Permission {
+	permissions := []models.Permission{}
+	for _, p := range PermissionCatalog {
+		if policy[role][p.Name] {
+			permissions = append(permissions, p.Name)
+		}
+	}
+	return permissions
+}
+
+// streamRoleName makes the role name unique across tenants, as Stream
+// roles are app wide
+func streamRoleName(tenantID string, key models.Role) string {
+	short := strings.ReplaceAll(tenantID, "-", "")
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	return "t" + short + "_" + strings.ToLower(string(key))
+}
+
+// syncStreamRole creates the role on Stream if needed and sets its grants on
+// the messaging channel type from the role's permissions
+func syncStreamRole(ctx context.Context, role models.CustomRole) (err error) {
+	client := GetStreamClient()
+	start := time.Now()
+	defer func() { metrics.ObserveStreamCall(metrics.StreamSyncRole, start, err) }()
+
+	roles, err := client.Permissions().ListRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list stream roles: %w", err)
+	}
+	exists := false
+	for _, r := range roles.Roles {
+		if r.Name == role.StreamRole {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		if _, err := client.Permissions().CreateRole(ctx, role.StreamRole); err != nil {
+			return fmt.Errorf("failed to create stream role: %w", err)
+		}
+	}
+
+	grants := []string{}
+	for _, p := range role.Permissions {
+		grants = append(grants, streamGrants[p]...)
+	}
+	_, err = client.UpdateChannelType(ctx, "messaging", map[string]interface{}{
+		"grants": map[string][]string{role.StreamRole: grants},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update stream grants: %w", err)
+	}
+	return nil
+}
+
+// resyncCustomRole updates Stream after a custom role's permissions were
+// changed through the permissions API; built-in roles are left alone
+func resyncCustomRole(ctx context.Context, tenantID string, key models.Role) error {
+	if key.IsBuiltin() {
+		return nil
+	}
+	var role models.CustomRole
+	if err := db.DB.WithContext(ctx).Where("tenant_id = ? AND key = ?", tenantID, key).First(&role).Error; err != nil {
+		return nil
+	}
+	policy, err := TenantPolicy(tenantID)
+	if err != nil {
+		return err
+	}
+	role.Permissions = grantedPermissions(policy, string(role.Key))
+	return syncStreamRole(ctx, role)
+}
+
+// streamRoleFor returns the Stream role of a user
+func streamRoleFor(user models.User) string {
+	if user.Role.IsBuiltin() || user.Role == "" {
+		return mapRoleToStream(user.Role)
+	}
+	var role models.CustomRole
+	if err := db.DB.Where("tenant_id = ? AND key = ?", user.TenantID, user.Role).First(&role).Error; err != nil {
+		return mapRoleToStream(user.Role)
+	}
+	return role.StreamRole
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleKey(t *testing.T) {
+	assert.Equal(t, models.Role("SUPPORT_AGENT"), RoleKey("Support Agent"))
+	assert.Equal(t, models.Role("CONTRACTOR_EU"), RoleKey("  contractor (EU) "))
+	assert.Equal(t, models.Role(""), RoleKey("!!!"))
+}
+
+func TestStreamRoleNameIsTenantScoped(t *testing.T) {
+	name := streamRoleName("2f45e8b2-5269-4ccf-8c47-48c9ada7e731", "SUPPORT_AGENT")
+	assert.Equal(t, "t2f45e8b2_support_agent", name)
+	assert.NotEqual(t, name, streamRoleName("9b1c0d3e-0000-4000-8000-000000000000", "SUPPORT_AGENT"))
+}
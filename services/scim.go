@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/repository"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+)
+
+var ErrInvalidSCIMToken = errors.New("invalid SCIM token")
+
+// CreateSCIMToken issues a provisioning token for a tenant and returns the raw token
+func CreateSCIMToken(ctx context.Context, repos repository.Repositories, tenantID, name, createdBy string) (models.SCIMToken, string, error) {
+	raw, err := utils.NewOpaqueToken()
+	if err != nil {
+		return models.SCIMToken{}, "", err
+	}
+	token := models.SCIMToken{
+		Name:      name,
+		TokenHash: utils.HashToken(raw),
+		CreatedBy: createdBy,
+	}
+	if err := repos.SCIMTokens.Create(ctx, tenantID, &token); err != nil {
+		return token, "", err
+	}
+	return token, raw, nil
+}
+
+// AuthenticateSCIMToken resolves a bearer token to its tenant
+func AuthenticateSCIMToken(ctx context.Context, repos repository.Repositories, raw string) (models.SCIMToken, error) {
+	token, err := repos.SCIMTokens.GetByHash(ctx, utils.HashToken(raw))
+	if err != nil {
+		return token, ErrInvalidSCIMToken
+	}
+	if err := CheckTenantActive(token.TenantID); err != nil {
+		return token, ErrInvalidSCIMToken
+	}
+	if err := repos.SCIMTokens.Touch(ctx, token.TenantID, token.ID); err != nil {
+		slog.WarnContext(ctx, "failed to record SCIM token use", "scim_token_id", token.ID, "error", err)
+	}
+	return token, nil
+}
+
+// SCIMAttribute maps a SCIM attribute path onto a column
+type SCIMAttribute struct {
+	Column string
+	// Bool attributes only support eq/ne/pr; Invert flips the stored value
+	// (e.g. SCIM "active" is stored as "disabled").
+	Bool   bool
+	Invert bool
+}
+
+// SCIMUserAttributes are the filterable attributes of SCIM users
+var SCIMUserAttributes = map[string]SCIMAttribute{
+	"id":             {Column: "id::text"},
+	"username":       {Column: "email"},
+	"externalid":     {Column: "external_id"},
+	"displayname":    {Column: "name"},
+	"name.formatted": {Column: "name"},
+	"emails":         {Column: "email"},
+	"emails.value":   {Column: "email"},
+	"active":         {Column: "disabled", Bool: true, Invert: true},
+}
+
+// SCIMGroupAttributes are the filterable attributes of SCIM groups
+var SCIMGroupAttributes = map[string]SCIMAttribute{
+	"id":          {Column: "id::text"},
+	"externalid":  {Column: "external_id"},
+	"displayname": {Column: "name"},
+}
+
+// ParseSCIMFilter translates a SCIM filter (RFC 7644 section 3.4.2.2) into a
+// SQL condition. Supported are the attribute operators eq, ne, co, sw, ew,
+// gt, ge, lt, le and pr combined with "and"/"or"; grouping with parentheses
+// and complex attribute filters are not.
+func ParseSCIMFilter(filter string, attributes map[string]SCIMAttribute) (string, []interface{}, error) {
+	tokens, err := tokenizeSCIMFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var clauses []string
+	var args []interface{}
+	for i := 0; i < len(tokens); {
+		if len(clauses) > 0 {
+			connector := strings.ToLower(tokens[i])
+			if connector != "and" && connector != "or" {
+				return "", nil, fmt.Errorf("expected 'and' or 'or', got %q", tokens[i])
+			}
+			clauses = append(clauses, strings.ToUpper(connector))
+			i++
+		}
+		if i+1 >= len(tokens) {
+			return "", nil, errors.New("incomplete filter expression")
+		}
+
+		path := strings.ToLower(tokens[i])
+		// emails[type eq "work"].value style paths are treated as the plain attribute
+		if bracket := strings.Index(path, "["); bracket >= 0 {
+			if end := strings.Index(path, "]"); end > bracket {
+				path = path[:bracket] + path[end+1:]
+			}
+		}
+		attr, ok := attributes[path]
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported filter attribute %q", tokens[i])
+		}
+		op := strings.ToLower(tokens[i+1])
+
+		if op == "pr" {
+			if attr.Bool {
+				clauses = append(clauses, attr.Column+" IS NOT NULL")
+			} else {
+				clauses = append(clauses, fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", attr.Column, attr.Column))
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(tokens) {
+			return "", nil, errors.New("incomplete filter expression")
+		}
+		value := tokens[i+2]
+		i += 3
+
+		if attr.Bool {
+			b, err := parseSCIMBool(value)
+			if err != nil {
+				return "", nil, err
+			}
+			if attr.Invert {
+				b = !b
+			}
+			switch op {
+			case "eq":
+				clauses = append(clauses, attr.Column+" = ?")
+			case "ne":
+				clauses = append(clauses, attr.Column+" <> ?")
+			default:
+				return "", nil, fmt.Errorf("operator %q not supported for boolean attributes", op)
+			}
+			args = append(args, b)
+			continue
+		}
+
+		str, err := unquoteSCIMString(value)
+		if err != nil {
+			return "", nil, err
+		}
+		column := "LOWER(" + attr.Column + ")"
+		str = strings.ToLower(str)
+		switch op {
+		case "eq":
+			clauses = append(clauses, column+" = ?")
+			args = append(args, str)
+		case "ne":
+			clauses = append(clauses, column+" <> ?")
+			args = append(args, str)
+		case "co":
+			clauses = append(clauses, column+" LIKE ?")
+			args = append(args, "%"+escapeLike(str)+"%")
+		case "sw":
+			clauses = append(clauses, column+" LIKE ?")
+			args = append(args, escapeLike(str)+"%")
+		case "ew":
+			clauses = append(clauses, column+" LIKE ?")
+			args = append(args, "%"+escapeLike(str))
+		case "gt", "ge", "lt", "le":
+			sqlOp := map[string]string{"gt": ">", "ge": ">=", "lt": "<", "le": "<="}[op]
+			clauses = append(clauses, column+" "+sqlOp+" ?")
+			args = append(args, str)
+		default:
+			return "", nil, fmt.Errorf("unsupported filter operator %q", op)
+		}
+	}
+
+	if len(clauses) == 0 {
+		return "", nil, errors.New("empty filter")
+	}
+	// SQL gives AND precedence over OR, as does SCIM
+	return strings.Join(clauses, " "), args, nil
+}
+
+func tokenizeSCIMFilter(filter string) ([]string, error) {
+	var tokens []string
+	runes := []rune(strings.TrimSpace(filter))
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+		case runes[i] == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, errors.New("unterminated string in filter")
+			}
+			tokens = append(tokens, string(runes[i:j+1]))
+			i = j + 1
+		case runes[i] == '(' || runes[i] == ')':
+			return nil, errors.New("grouping is not supported in filters")
+		default:
+			j := i
+			depth := 0
+			for ; j < len(runes) && (depth > 0 || !unicode.IsSpace(runes[j])); j++ {
+				if runes[j] == '[' {
+					depth++
+				} else if runes[j] == ']' {
+					depth--
+				}
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func unquoteSCIMString(value string) (string, error) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", fmt.Errorf("expected quoted string, got %s", value)
+	}
+	return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`), nil
+}
+
+func parseSCIMBool(value string) (bool, error) {
+	switch strings.ToLower(strings.Trim(value, `"`)) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected boolean, got %s", value)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
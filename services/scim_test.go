@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	clause, args, err := ParseSCIMFilter(`userName eq "Jane@Example.com"`, SCIMUserAttributes)
+	require.NoError(t, err)
+	assert.Equal(t, "LOWER(email) = ?", clause)
+	assert.Equal(t, []interface{}{"jane@example.com"}, args)
+
+	clause, args, err = ParseSCIMFilter(`active eq false and displayName sw "J"`, SCIMUserAttributes)
+	require.NoError(t, err)
+	assert.Equal(t, "disabled = ? AND LOWER(name) LIKE ?", clause)
+	assert.Equal(t, []interface{}{true, "j%"}, args)
+
+	clause, _, err = ParseSCIMFilter(`emails[type eq "work"].value co "acme" or externalId pr`, SCIMUserAttributes)
+	require.NoError(t, err)
+	assert.Equal(t, "LOWER(email) LIKE ? OR (external_id IS NOT NULL AND external_id <> '')", clause)
+
+	_, args, err = ParseSCIMFilter(`displayName co "50%_off"`, SCIMGroupAttributes)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{`%50\%\_off%`}, args)
+}
+
+func TestParseSCIMFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`password eq "x"`,
+		`userName eq`,
+		`userName eq "unterminated`,
+		`userName eq "a" xor userName eq "b"`,
+		`(userName eq "a")`,
+		`active gt true`,
+	} {
+		_, _, err := ParseSCIMFilter(filter, SCIMUserAttributes)
+		assert.Error(t, err, filter)
+	}
+}
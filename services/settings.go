@@ -0,0 +1,264 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	stream_chat "github.com/GetStream/stream-chat-go/v5"
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxMessageLength is the longest message Stream accepts
+	MaxMessageLength = 20000
+	MaxRetentionDays = 3650
+	// settingsCacheTTL bounds how long a settings change takes to reach other instances
+	settingsCacheTTL = 30 * time.Second
+)
+
+var (
+	ErrInvalidSettings     = errors.New("invalid settings")
+	ErrSettingsConflict    = errors.New("settings were changed concurrently; reload and retry")
+	ErrRegistrationClosed  = errors.New("organization does not accept new members this way")
+	ErrGuestAccessDisabled = errors.New("guest access is disabled")
+)
+
+// AttachmentTypes are the Stream attachment types tenants can allow
+var AttachmentTypes = []string{"image", "video", "audio", "file"}
+
+// DefaultTenantSettings are used until a tenant changes its settings
+func DefaultTenantSettings(tenantID string) models.TenantSettings {
+	return models.TenantSettings{
+		TenantID:               tenantID,
+		DefaultRole:            models.RoleMember,
+		RegistrationPolicy:     models.RegistrationDomainAndInvite,
+		MessageMaxLength:       5000,
+		RetentionDays:          0,
+		AllowedAttachmentTypes: append([]string{}, AttachmentTypes...),
+		GuestAccess:            true,
+	}
+}
+
+// TenantSettingsPatch changes the non-nil fields of a tenant's settings
+type TenantSettingsPatch struct {
+	DefaultRole            *models.Role               `json:"default_role"`
+	RegistrationPolicy     *models.RegistrationPolicy `json:"registration_policy"`
+	MessageMaxLength       *int                       `json:"message_max_length"`
+	RetentionDays          *int                       `json:"retention_days"`
+	AllowedAttachmentTypes *[]string                  `json:"allowed_attachment_types"`
+	GuestAccess            *bool                      `json:"guest_access"`
+}
+
+// Apply copies the patch's fields onto settings
+func (p TenantSettingsPatch) Apply(settings *models.TenantSettings) {
+	if p.DefaultRole != nil {
+		settings.DefaultRole = *p.DefaultRole
+	}
+	if p.RegistrationPolicy != nil {
+		settings.RegistrationPolicy = *p.RegistrationPolicy
+	}
+	if p.MessageMaxLength != nil {
+		settings.MessageMaxLength = *p.MessageMaxLength
+	}
+	if p.RetentionDays != nil {
+		settings.RetentionDays = *p.RetentionDays
+	}
+	if p.AllowedAttachmentTypes != nil {
+		settings.AllowedAttachmentTypes = *p.AllowedAttachmentTypes
+	}
+	if p.GuestAccess != nil {
+		settings.GuestAccess = *p.GuestAccess
+	}
+}
+
+type cachedSettings struct {
+	settings models.TenantSettings
+	loadedAt time.Time
+}
+
+var (
+	settingsCacheMu sync.RWMutex
+	settingsCache   = map[string]cachedSettings{}
+)
+
+// GetTenantSettings returns a tenant's settings, served from an in-process cache
+func GetTenantSettings(tenantID string) (models.TenantSettings, error) {
+	settingsCacheMu.RLock()
+	cached, ok := settingsCache[tenantID]
+	settingsCacheMu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < settingsCacheTTL {
+		return cached.settings, nil
+	}
+
+	settings, err := loadTenantSettings(db.DB, tenantID)
+	if err != nil {
+		return settings, err
+	}
+
+	settingsCacheMu.Lock()
+	settingsCache[tenantID] = cachedSettings{settings: settings, loadedAt: time.Now()}
+	settingsCacheMu.Unlock()
+	return settings, nil
+}
+
+// UpdateTenantSettings applies patch to the tenant's settings and returns
+// them along with the settings they replaced. When expectedVersion is set and
+// the stored version differs, ErrSettingsConflict is returned and nothing is
+// changed.
+func UpdateTenantSettings(tenantID string, patch TenantSettingsPatch, expectedVersion *int, updatedBy string) (settings, previous models.TenantSettings, err error) {
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		settings, err = loadTenantSettings(tx.Clauses(clause.Locking{Strength: "UPDATE"}), tenantID)
+		if err != nil {
+			return err
+		}
+		previous = settings
+		previous.AllowedAttachmentTypes = append([]string{}, settings.AllowedAttachmentTypes...)
+		if expectedVersion != nil && *expectedVersion != settings.Version {
+			return ErrSettingsConflict
+		}
+
+		patch.Apply(&settings)
+		if err := ValidateTenantSettings(settings); err != nil {
+			return err
+		}
+		if !IsValidRole(tenantID, settings.DefaultRole) {
+			return fmt.Errorf("%w: unknown role %s", ErrInvalidSettings, settings.DefaultRole)
+		}
+		settings.Version++
+		settings.UpdatedBy = updatedBy
+
+		if settings.Version == 1 {
+			// a concurrent first write makes this insert fail on the primary key
+			if err := tx.Create(&settings).Error; err != nil {
+				return ErrSettingsConflict
+			}
+			return nil
+		}
+		return tx.Save(&settings).Error
+	})
+	invalidateSettings(tenantID)
+	return settings, previous, err
+}
+
+// ValidateTenantSettings checks the settings that do not depend on the database
+func ValidateTenantSettings(s models.TenantSettings) error {
+	switch s.RegistrationPolicy {
+	case models.RegistrationDomainAndInvite, models.RegistrationInviteOnly, models.RegistrationClosed:
+	default:
+		return fmt.Errorf("%w: unknown registration policy %q", ErrInvalidSettings, s.RegistrationPolicy)
+	}
+	if s.MessageMaxLength < 1 || s.MessageMaxLength > MaxMessageLength {
+		return fmt.Errorf("%w: message_max_length must be between 1 and %d", ErrInvalidSettings, MaxMessageLength)
+	}
+	if s.RetentionDays < 0 || s.RetentionDays > MaxRetentionDays {
+		return fmt.Errorf("%w: retention_days must be between 0 and %d", ErrInvalidSettings, MaxRetentionDays)
+	}
+	for _, t := range s.AllowedAttachmentTypes {
+		if !isAttachmentType(t) {
+			return fmt.Errorf("%w: unknown attachment type %q", ErrInvalidSettings, t)
+		}
+	}
+	if s.DefaultRole == models.RoleGuest && !s.GuestAccess {
+		return fmt.Errorf("%w: the default role cannot be GUEST while guest access is disabled", ErrInvalidSettings)
+	}
+	return nil
+}
+
+// AttachmentAllowed reports whether the settings allow attachments of type t
+func AttachmentAllowed(s models.TenantSettings, t string) bool {
+	for _, allowed := range s.AllowedAttachmentTypes {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+// WithinRetention drops messages older than retentionDays; 0 keeps all
+func WithinRetention(messages []*stream_chat.Message, retentionDays int, now time.Time) []*stream_chat.Message {
+	if retentionDays <= 0 {
+		return messages
+	}
+	cutoff := now.AddDate(0, 0, -retentionDays)
+	kept := make([]*stream_chat.Message, 0, len(messages))
+	for _, m := range messages {
+		if m.CreatedAt == nil || m.CreatedAt.After(cutoff) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// CheckGuestAccess returns ErrGuestAccessDisabled for GUEST users of a
+// tenant that turned guest access off
+func CheckGuestAccess(tenantID string, role models.Role) error {
+	if role != models.RoleGuest {
+		return nil
+	}
+	settings, err := GetTenantSettings(tenantID)
+	if err != nil {
+		return err
+	}
+	if !settings.GuestAccess {
+		return ErrGuestAccessDisabled
+	}
+	return nil
+}
+
+// CheckRegistrationPolicy returns ErrRegistrationClosed when the tenant does
+// not let people join through domain (verified domain) or invitation
+func CheckRegistrationPolicy(tenantID string, viaDomain bool) error {
+	settings, err := GetTenantSettings(tenantID)
+	if err != nil {
+		return err
+	}
+	switch settings.RegistrationPolicy {
+	case models.RegistrationClosed:
+		return ErrRegistrationClosed
+	case models.RegistrationInviteOnly:
+		if viaDomain {
+			return ErrRegistrationClosed
+		}
+	}
+	return nil
+}
+
+// DefaultRoleFor returns the tenant's default role, falling back to MEMBER
+// when the configured role no longer exists
+func DefaultRoleFor(tenantID string) models.Role {
+	settings, err := GetTenantSettings(tenantID)
+	if err != nil || !IsValidRole(tenantID, settings.DefaultRole) {
+		return models.RoleMember
+	}
+	return settings.DefaultRole
+}
+
+func loadTenantSettings(tx *gorm.DB, tenantID string) (models.TenantSettings, error) {
+	var settings models.TenantSettings
+	err := tx.Where("tenant_id = ?", tenantID).First(&settings).Error
+	if db.IsRecordNotFoundError(err) {
+		return DefaultTenantSettings(tenantID), nil
+	}
+	return settings, err
+}
+
+func invalidateSettings(tenantID string) {
+	settingsCacheMu.Lock()
+	delete(settingsCache, tenantID)
+	settingsCacheMu.Unlock()
+}
+
+func isAttachmentType(t string) bool {
+	for _, known := range AttachmentTypes {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	stream_chat "github.com/GetStream/stream-chat-go/v5"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultTenantSettingsAreValid(t *testing.T) {
+	assert.NoError(t, ValidateTenantSettings(DefaultTenantSettings("t1")))
+}
+
+func TestValidateTenantSettings(t *testing.T) {
+	invalid := map[string]TenantSettingsPatch{}
+	policy := models.RegistrationPolicy("anyone")
+	zero, tooLong, negative := 0, MaxMessageLength+1, -1
+	types := []string{"image", "exe"}
+	guest, off := models.RoleGuest, false
+	invalid["policy"] = TenantSettingsPatch{RegistrationPolicy: &policy}
+	invalid["zero length"] = TenantSettingsPatch{MessageMaxLength: &zero}
+	invalid["too long"] = TenantSettingsPatch{MessageMaxLength: &tooLong}
+	invalid["retention"] = TenantSettingsPatch{RetentionDays: &negative}
+	invalid["attachment"] = TenantSettingsPatch{AllowedAttachmentTypes: &types}
+	invalid["guest default"] = TenantSettingsPatch{DefaultRole: &guest, GuestAccess: &off}
+
+	for name, patch := range invalid {
+		settings := DefaultTenantSettings("t1")
+		patch.Apply(&settings)
+		assert.ErrorIs(t, ValidateTenantSettings(settings), ErrInvalidSettings, name)
+	}
+}
+
+func TestTenantSettingsPatchLeavesOmittedFields(t *testing.T) {
+	settings := DefaultTenantSettings("t1")
+	days := 30
+	TenantSettingsPatch{RetentionDays: &days}.Apply(&settings)
+	assert.Equal(t, 30, settings.RetentionDays)
+	assert.Equal(t, 5000, settings.MessageMaxLength)
+	assert.True(t, settings.GuestAccess)
+}
+
+func TestWithinRetention(t *testing.T) {
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	old, recent := now.AddDate(0, 0, -31), now.AddDate(0, 0, -29)
+	messages := []*stream_chat.Message{{ID: "old", CreatedAt: &old}, {ID: "recent", CreatedAt: &recent}}
+
+	assert.Len(t, WithinRetention(messages, 0, now), 2)
+	kept := WithinRetention(messages, 30, now)
+	assert.Len(t, kept, 1)
+	assert.Equal(t, "recent", kept[0].ID)
+}
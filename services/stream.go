@@ -5,35 +5,71 @@ import (
 	"errors"
 
 	stream "github.com/GetStream/stream-chat-go/v5"
+	"github.com/Nyagar-Abraham/chat-app/metrics"
 	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/tracing"
+	"github.com/Nyagar-Abraham/chat-app/utils"
 	"github.com/google/uuid"
 
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"sync"
 	"time"
 )
 
 var streamClient *stream.Client
-var once sync.Once
 
-// return a singleton stream client
+// GetStreamClient returns the client set at startup
 func GetStreamClient() *stream.Client {
-	once.Do(func() {
-		apiKey := os.Getenv("STREAM_API_KEY")
-		apiSecret := os.Getenv("STREAM_API_SECRET")
-		if apiKey == "" || apiSecret == "" {
-			log.Fatal("STREAM_API_KEY and STREAM_API_SECRET must be set")
-		}
-		var err error
-		streamClient, err = stream.NewClient(apiKey, apiSecret)
-		if err != nil {
-			log.Fatalf("Failed to create stream client: %v", err)
-		}
-	})
+	if streamClient == nil {
+		slog.Error("stream client used before SetStreamClient")
+		os.Exit(1)
+	}
 	return streamClient
 }
 
+// SetStreamClient sets the client, built from the loaded config when serving
+func SetStreamClient(c *stream.Client) {
+	streamClient = c
+}
+
+// NewStreamClient returns a client of the Stream app whose calls carry the
+// request ID and are traced
+func NewStreamClient(apiKey, apiSecret string) (*stream.Client, error) {
+	if apiKey == "" || apiSecret == "" {
+		return nil, errors.New("STREAM_API_KEY and STREAM_API_SECRET must be set")
+	}
+	client, err := stream.NewClient(apiKey, apiSecret)
+	if err != nil {
+		return nil, err
+	}
+	client.HTTP.Transport = tracing.Transport(streamTransport{base: client.HTTP.Transport}, "stream")
+	return client, nil
+}
+
+// streamTransport passes the request ID on to Stream, so that calls can be
+// matched with the request that made them, and logs every call at debug level
+type streamTransport struct {
+	base http.RoundTripper
+}
+
+func (t streamTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	if id := utils.RequestIDFromContext(ctx); id != "" {
+		r = r.Clone(ctx)
+		r.Header.Set(utils.RequestIDHeader, id)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	attrs := []any{"method", r.Method, "path", r.URL.Path, "latency_ms", float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		slog.WarnContext(ctx, "stream call failed", append(attrs, "error", err)...)
+		return resp, err
+	}
+	slog.DebugContext(ctx, "stream call", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
+}
+
 // generate a stream chat token for a user
 func CreateStreamToken(userId string) (string, error) {
 	if userId == "" {
@@ -44,6 +80,7 @@ func CreateStreamToken(userId string) (string, error) {
 	return token, err
 }
 
+// mapRoleToStream maps the built-in roles; custom roles have their own Stream role
 func mapRoleToStream(role models.Role) string {
 	switch role {
 	case models.RoleAdmin:
@@ -59,26 +96,34 @@ func mapRoleToStream(role models.Role) string {
 	}
 }
 
-func CreateStreamUser(user models.User) error {
+func CreateStreamUser(ctx context.Context, user models.User) error {
 	client := GetStreamClient()
-	_, err := client.UpsertUsers(context.Background(), &stream.User{
+	start := time.Now()
+	_, err := client.UpsertUsers(ctx, &stream.User{
 		ID:   user.ID,
 		Name: user.Name,
-		Role: mapRoleToStream(user.Role),
+		Role: streamRoleFor(user),
 		ExtraData: map[string]interface{}{
 			"tenant_id": user.TenantID,
 			"email":     user.Email,
 			"app_role":  string(user.Role),
 		},
 	})
+	metrics.ObserveStreamCall(metrics.StreamCreateUser, start, err)
 	if err != nil {
-		log.Printf("CreateStreamUser error for user %s: %v", user.ID, err)
+		slog.ErrorContext(ctx, "failed to upsert stream user", "stream_user_id", user.ID, "error", err)
 	}
 	return err
 }
 
 // create a channel
-func CreateStreamChannel(channel models.Channel, creatorID string) (string, error) {
+func CreateStreamChannel(ctx context.Context, channel models.Channel, creatorID string) (string, error) {
+	return CreateStreamChannelWithMembers(ctx, channel, creatorID, []string{creatorID})
+}
+
+// CreateStreamChannelWithMembers creates a channel whose initial members are
+// not necessarily the creator (e.g. SCIM provisioned groups)
+func CreateStreamChannelWithMembers(ctx context.Context, channel models.Channel, creatorID string, memberIDs []string) (string, error) {
 	client := GetStreamClient()
 	//	Ensure channelId is less than 64 characters for stream
 	shortTenantID := channel.TenantID
@@ -88,13 +133,14 @@ func CreateStreamChannel(channel models.Channel, creatorID string) (string, erro
 	}
 
 	channelID := shortTenantID + "-" + uuid.New().String()
+	start := time.Now()
 	ch, err := client.CreateChannel(
-		context.Background(),
+		ctx,
 		"messaging",
 		channelID,
 		creatorID,
 		&stream.ChannelRequest{
-			Members: []string{creatorID},
+			Members: memberIDs,
 			ExtraData: map[string]interface{}{
 				"tenant_id":   channel.TenantID,
 				"name":        channel.Name,
@@ -102,8 +148,9 @@ func CreateStreamChannel(channel models.Channel, creatorID string) (string, erro
 			},
 		},
 	)
+	metrics.ObserveStreamCall(metrics.StreamCreateChannel, start, err)
 	if err != nil {
-		log.Printf("Stream CreateChannel error: %v", err)
+		slog.ErrorContext(ctx, "failed to create stream channel", "error", err)
 		return "", err
 	}
 
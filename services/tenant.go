@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"gorm.io/gorm"
+)
+
+var ErrTenantSuspended = errors.New("organization is suspended")
+
+// CheckTenantActive returns ErrTenantSuspended when the tenant has been
+// suspended by a platform admin
+func CheckTenantActive(tenantID string) error {
+	var tenant models.Tenant
+	if err := db.DB.Select("id", "suspended").Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+		return err
+	}
+	if tenant.Suspended {
+		return ErrTenantSuspended
+	}
+	return nil
+}
+
+// SetTenantSuspended suspends or reactivates a tenant. Suspension revokes the
+// tokens of all of the tenant's users, so they are signed out immediately.
+func SetTenantSuspended(tenantID string, suspended bool) (models.Tenant, error) {
+	var tenant models.Tenant
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+			return err
+		}
+		if tenant.Suspended == suspended {
+			return nil
+		}
+		tenant.Suspended = suspended
+		tenant.SuspendedAt = nil
+		if suspended {
+			now := time.Now()
+			tenant.SuspendedAt = &now
+		}
+		if err := tx.Save(&tenant).Error; err != nil {
+			return err
+		}
+		if !suspended {
+			return nil
+		}
+		if err := tx.Model(&models.User{}).
+			Where("tenant_id = ?", tenantID).
+			UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).
+			Where("tenant_id = ? AND revoked_at IS NULL", tenantID).
+			Update("revoked_at", time.Now()).Error
+	})
+	return tenant, err
+}
+
+// BootstrapPlatformAdmins grants platform admin to the users listed in
+// PLATFORM_ADMIN_EMAILS (comma separated) whose address is verified: anyone
+// can register a listed address nobody holds yet. Grants are never revoked
+// here.
+func BootstrapPlatformAdmins() error {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("PLATFORM_ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 {
+		return nil
+	}
+
+	var users []models.User
+	if err := db.DB.Where("LOWER(email) IN ?", emails).Find(&users).Error; err != nil {
+		return err
+	}
+	found := map[string]bool{}
+	for _, user := range users {
+		found[strings.ToLower(user.Email)] = true
+		if user.PlatformAdmin {
+			continue
+		}
+		if !user.EmailVerified {
+			slog.Warn("not granting platform admin to an unverified email", "user_id", user.ID, "email", user.Email)
+			continue
+		}
+		result := db.DB.Model(&user).Where("email_verified = ?", true).Update("platform_admin", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		// existing tokens lack the platform admin claim
+		if err := RevokeAllUserTokens(user.ID); err != nil {
+			return err
+		}
+		slog.Info("granted platform admin", "user_id", user.ID, "email", user.Email)
+	}
+	for _, email := range emails {
+		if !found[email] {
+			slog.Warn("not granting platform admin to an email without an account", "email", email)
+		}
+	}
+	return nil
+}
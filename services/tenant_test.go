@@ -0,0 +1,33 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBootstrapPlatformAdminsNeedsAVerifiedEmail(t *testing.T) {
+	mock := testutil.SetupMockDB(t)
+	t.Setenv("PLATFORM_ADMIN_EMAILS", "ops@example.com, Squatted@example.com, nobody@example.com")
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE LOWER\(email\) IN \(\$1,\$2,\$3\)`).
+		WithArgs("ops@example.com", "squatted@example.com", "nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "email_verified", "platform_admin"}).
+			AddRow("user-ops", "ops@example.com", true, false).
+			AddRow("user-squatter", "squatted@example.com", false, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET "platform_admin"=\$1 WHERE email_verified = \$2 AND "id" = \$3`).
+		WithArgs(true, true, "user-ops").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET "token_version"`).WithArgs("user-ops").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.NoError(t, BootstrapPlatformAdmins())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
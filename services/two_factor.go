@@ -0,0 +1,233 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/Nyagar-Abraham/chat-app/models"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeBytes gives each recovery code 80 random bits
+	recoveryCodeBytes = 10
+)
+
+var (
+	ErrInvalidTOTPCode        = errors.New("invalid two-factor code")
+	ErrTOTPAlreadyEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled        = errors.New("two-factor enrollment has not been started")
+	ErrTwoFactorRequiredByOrg = errors.New("two-factor authentication is required by your organization")
+)
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Chat App"
+}
+
+// privilegedPermissions are the permissions that let a role act on other
+// users, their messages or the organization. A role holding any of them in
+// the tenant is privileged, whether built in or custom.
+var privilegedPermissions = []models.Permission{
+	models.PermChannelMembersManage, models.PermMessageDeleteAny,
+	models.PermUserCreate, models.PermUserInvite, models.PermUserUpdate, models.PermUserUpdateRole, models.PermUserDelete, models.PermUserUnlock,
+	models.PermTenantManage, models.PermTenantSCIMManage, models.PermTenantPermissionsEdit, models.PermAuditRead,
+}
+
+// TwoFactorRequired reports whether the user's tenant forces them to use 2FA
+func TwoFactorRequired(user models.User) (bool, error) {
+	var tenant models.Tenant
+	if err := db.DB.Where("id = ?", user.TenantID).First(&tenant).Error; err != nil {
+		return false, err
+	}
+	if !tenant.Require2FAForPrivileged {
+		return false, nil
+	}
+	for _, permission := range privilegedPermissions {
+		allowed, err := HasPermission(user.TenantID, string(user.Role), permission)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+	return false, nil
+}
+
+// StartTOTPEnrollment stores a new pending secret and returns it with its
+// provisioning URI. 2FA is not enforced until ConfirmTOTPEnrollment succeeds.
+func StartTOTPEnrollment(user models.User) (string, string, error) {
+	if user.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := db.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return "", "", err
+	}
+	return secret, TOTPProvisioningURI(totpIssuer(), user.Email, secret), nil
+}
+
+// ConfirmTOTPEnrollment enables 2FA once the user proves their authenticator
+// works and returns a fresh set of recovery codes.
+func ConfirmTOTPEnrollment(user models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	if err := db.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return RegenerateRecoveryCodes(user)
+}
+
+// DisableTOTP turns 2FA off after checking a current code
+func DisableTOTP(user models.User, code string) error {
+	required, err := TwoFactorRequired(user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorRequiredByOrg
+	}
+	if err := VerifySecondFactor(user, code, ""); err != nil {
+		return err
+	}
+	if err := db.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return err
+	}
+	return db.DB.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+}
+
+// VerifySecondFactor checks either a TOTP code or an unused recovery code
+func VerifySecondFactor(user models.User, code, recoveryCode string) error {
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	if recoveryCode != "" {
+		return useRecoveryCode(user, recoveryCode)
+	}
+
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	// advance the last step atomically so the same code cannot be used twice
+	result := db.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. The plain codes
+// are only ever returned here.
+func RegenerateRecoveryCodes(user models.User) ([]string, error) {
+	if err := db.DB.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, hash, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{
+			UserID:   user.ID,
+			CodeHash: hash,
+		})
+	}
+	if err := db.DB.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a code formatted as four groups of four characters
+// and its bcrypt hash. Like passwords, the codes are stored with a slow
+// salted hash, so a leaked table does not give them away.
+func newRecoveryCode() (string, string, error) {
+	b := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	groups := make([]string, 0, len(raw)/4)
+	for i := 0; i < len(raw); i += 4 {
+		groups = append(groups, raw[i:i+4])
+	}
+	hash, err := HashPassword(raw)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.Join(groups, "-"), string(hash), nil
+}
+
+// normalizeRecoveryCode lowercases a code and drops the dashes and spaces
+// users may type between its groups
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, code)
+}
+
+func useRecoveryCode(user models.User, code string) error {
+	var records []models.RecoveryCode
+	if err := db.DB.Where("user_id = ? AND used_at IS NULL", user.ID).Find(&records).Error; err != nil {
+		return err
+	}
+	code = normalizeRecoveryCode(code)
+	for _, record := range records {
+		if CheckPassword(code, record.CodeHash) != nil {
+			continue
+		}
+		// only one concurrent use of the code may succeed
+		result := db.DB.Model(&models.RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", record.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+	return ErrInvalidTOTPCode
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Nyagar-Abraham/chat-app/models"
+	"github.com/Nyagar-Abraham/chat-app/testutil"
+	"github.com/Nyagar-Abraham/chat-app/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryCodesAreLongAndSaltedHashes(t *testing.T) {
+	code, hash, err := newRecoveryCode()
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{4}(-[a-z2-7]{4}){3}$`), code)
+	assert.NotEqual(t, utils.HashToken(code), hash)
+	assert.NoError(t, CheckPassword(normalizeRecoveryCode(code), hash))
+
+	_, other, err := newRecoveryCode()
+	require.NoError(t, err)
+	assert.Error(t, CheckPassword(normalizeRecoveryCode(code), other))
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	code, hash, err := newRecoveryCode()
+	require.NoError(t, err)
+	_, otherHash, err := newRecoveryCode()
+	require.NoError(t, err)
+	mock := testutil.SetupMockDB(t)
+	user := models.User{ID: testutil.UserOne, TOTPEnabled: true}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "code_hash"}).
+			AddRow("code-1", testutil.UserOne, otherHash).
+			AddRow("code-2", testutil.UserOne, hash)
+	}
+
+	// typed in upper case without dashes
+	mock.ExpectQuery(`SELECT \* FROM "recovery_codes"`).WillReturnRows(rows())
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "recovery_codes" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), "code-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, VerifySecondFactor(user, "", strings.ToUpper(strings.ReplaceAll(code, "-", ""))+" "))
+
+	mock.ExpectQuery(`SELECT \* FROM "recovery_codes"`).WillReturnRows(rows())
+	assert.ErrorIs(t, VerifySecondFactor(user, "", "aaaa-aaaa-aaaa-aaaa"), ErrInvalidTOTPCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTwoFactorRequiredFollowsPermissions(t *testing.T) {
+	mock := testutil.SetupMockDB(t)
+	tenantID := uuid.NewString()
+	policy := sqlmock.NewRows([]string{"tenant_id", "role", "permission", "allowed"}).
+		AddRow("", "ADMIN", string(models.PermTenantManage), true).
+		AddRow("", "MEMBER", string(models.PermMessageSend), true).
+		AddRow(tenantID, "OWNER", string(models.PermMessageSend), true).
+		AddRow(tenantID, "OWNER", string(models.PermUserUpdateRole), true).
+		AddRow(tenantID, "CHATTER", string(models.PermChannelCreate), true)
+	expectTenant := func(required bool) {
+		mock.ExpectQuery(`SELECT \* FROM "tenants" WHERE id = \$1`).
+			WithArgs(tenantID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "require_2fa_for_privileged"}).AddRow(tenantID, required))
+	}
+
+	expectTenant(true)
+	mock.ExpectQuery(`SELECT \* FROM "role_permissions"`).WillReturnRows(policy)
+	required, err := TwoFactorRequired(models.User{TenantID: tenantID, Role: models.RoleAdmin})
+	assert.NoError(t, err)
+	assert.True(t, required)
+	// a custom role is privileged by what it may do, not by its name
+	for role, want := range map[models.Role]bool{"OWNER": true, models.RoleMember: false, "CHATTER": false} {
+		expectTenant(true)
+		required, err := TwoFactorRequired(models.User{TenantID: tenantID, Role: role})
+		assert.NoError(t, err)
+		assert.Equal(t, want, required, role)
+	}
+
+	// privileged roles are free when the tenant does not ask for it
+	expectTenant(false)
+	required, err = TwoFactorRequired(models.User{TenantID: tenantID, Role: "OWNER"})
+	assert.NoError(t, err)
+	assert.False(t, required)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -36,6 +36,8 @@ module "secrets" {
   environment        = var.environment
   db_password        = var.db_password
   jwt_secret         = var.jwt_secret
+  audit_signing_key  = var.audit_signing_key
+  metrics_token      = var.metrics_token
   stream_api_key     = var.stream_api_key
   stream_api_secret  = var.stream_api_secret
 }
@@ -111,9 +113,14 @@ module "ecs" {
 
   database_url_secret_arn       = module.secrets.database_url_secret_arn
   jwt_secret_arn                = module.secrets.jwt_secret_arn
+  audit_signing_key_secret_arn  = module.secrets.audit_signing_key_secret_arn
+  metrics_token_secret_arn      = module.secrets.metrics_token_secret_arn
   stream_api_key_secret_arn     = module.secrets.stream_api_key_secret_arn
   stream_api_secret_secret_arn  = module.secrets.stream_api_secret_secret_arn
 
+  smtp_host = var.smtp_host
+  mail_from = var.mail_from
+
   db_endpoint = module.rds.db_endpoint
 }
 
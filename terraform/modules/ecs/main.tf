@@ -91,6 +91,8 @@ resource "aws_iam_role_policy" "secrets_access" {
       Resource = [
         var.database_url_secret_arn,
         var.jwt_secret_arn,
+        var.audit_signing_key_secret_arn,
+        var.metrics_token_secret_arn,
         var.stream_api_key_secret_arn,
         var.stream_api_secret_secret_arn
       ]
@@ -118,7 +120,10 @@ resource "aws_ecs_task_definition" "main" {
     environment = [
       { name = "PORT", value = "8085" },
       { name = "GIN_MODE", value = "release" },
-      { name = "MIGRATE_DB", value = "false" }
+      { name = "MIGRATE_DB", value = "false" },
+      { name = "MAILER", value = "smtp" },
+      { name = "SMTP_HOST", value = var.smtp_host },
+      { name = "MAIL_FROM", value = var.mail_from }
     ]
 
     secrets = [
@@ -130,6 +135,14 @@ resource "aws_ecs_task_definition" "main" {
         name      = "JWT_SECRET"
         valueFrom = var.jwt_secret_arn
       },
+      {
+        name      = "AUDIT_SIGNING_KEY"
+        valueFrom = var.audit_signing_key_secret_arn
+      },
+      {
+        name      = "METRICS_TOKEN"
+        valueFrom = var.metrics_token_secret_arn
+      },
       {
         name      = "STREAM_API_KEY"
         valueFrom = var.stream_api_key_secret_arn
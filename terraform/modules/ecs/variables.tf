@@ -30,6 +30,14 @@ variable "jwt_secret_arn" {
   type = string
 }
 
+variable "audit_signing_key_secret_arn" {
+  type = string
+}
+
+variable "metrics_token_secret_arn" {
+  type = string
+}
+
 variable "stream_api_key_secret_arn" {
   type = string
 }
@@ -38,6 +46,14 @@ variable "stream_api_secret_secret_arn" {
   type = string
 }
 
+variable "smtp_host" {
+  type = string
+}
+
+variable "mail_from" {
+  type = string
+}
+
 variable "db_endpoint" {
   type = string
 }
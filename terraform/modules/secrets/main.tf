@@ -18,6 +18,26 @@ resource "aws_secretsmanager_secret_version" "jwt_secret" {
   secret_string = var.jwt_secret
 }
 
+resource "aws_secretsmanager_secret" "audit_signing_key" {
+  name = "${var.app_name}/${var.environment}/audit-signing-key"
+  recovery_window_in_days = 0
+}
+
+resource "aws_secretsmanager_secret_version" "audit_signing_key" {
+  secret_id     = aws_secretsmanager_secret.audit_signing_key.id
+  secret_string = var.audit_signing_key
+}
+
+resource "aws_secretsmanager_secret" "metrics_token" {
+  name = "${var.app_name}/${var.environment}/metrics-token"
+  recovery_window_in_days = 0
+}
+
+resource "aws_secretsmanager_secret_version" "metrics_token" {
+  secret_id     = aws_secretsmanager_secret.metrics_token.id
+  secret_string = var.metrics_token
+}
+
 resource "aws_secretsmanager_secret" "stream_api_key" {
   name = "${var.app_name}/${var.environment}/stream-api-key"
   recovery_window_in_days = 0
@@ -6,6 +6,14 @@ output "jwt_secret_arn" {
   value = aws_secretsmanager_secret.jwt_secret.arn
 }
 
+output "audit_signing_key_secret_arn" {
+  value = aws_secretsmanager_secret.audit_signing_key.arn
+}
+
+output "metrics_token_secret_arn" {
+  value = aws_secretsmanager_secret.metrics_token.arn
+}
+
 output "stream_api_key_secret_arn" {
   value = aws_secretsmanager_secret.stream_api_key.arn
 }
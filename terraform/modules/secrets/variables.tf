@@ -16,6 +16,16 @@ variable "jwt_secret" {
   sensitive = true
 }
 
+variable "audit_signing_key" {
+  type      = string
+  sensitive = true
+}
+
+variable "metrics_token" {
+  type      = string
+  sensitive = true
+}
+
 variable "stream_api_key" {
   type      = string
   sensitive = true
@@ -28,6 +28,18 @@ variable "jwt_secret" {
   sensitive   = true
 }
 
+variable "audit_signing_key" {
+  description = "Ed25519 seed (base64, 32 bytes) signing audit checkpoints, e.g. openssl rand -base64 32"
+  type        = string
+  sensitive   = true
+}
+
+variable "metrics_token" {
+  description = "Bearer token Prometheus sends to scrape /metrics"
+  type        = string
+  sensitive   = true
+}
+
 variable "stream_api_key" {
   description = "Stream Chat API key"
   type        = string
@@ -40,6 +52,16 @@ variable "stream_api_secret" {
   sensitive   = true
 }
 
+variable "smtp_host" {
+  description = "SMTP relay for transactional mail (required in release mode)"
+  type        = string
+}
+
+variable "mail_from" {
+  description = "Sender address of transactional mail"
+  type        = string
+}
+
 variable "docker_image" {
   description = "Docker image URI"
   type        = string
@@ -0,0 +1,70 @@
+package testutil
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Nyagar-Abraham/chat-app/db"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// SetupPostgres points db.DB at a new schema of the database at
+// TEST_DATABASE_URL with every migration applied, and drops the schema when
+// the test ends. Without TEST_DATABASE_URL the test is skipped.
+func SetupPostgres(t *testing.T) {
+	SetupEmptyPostgres(t)
+	if _, err := db.MigrateUp(context.Background()); err != nil {
+		t.Fatalf("Failed to migrate test schema: %v", err)
+	}
+}
+
+// SetupEmptyPostgres is SetupPostgres without the migrations
+func SetupEmptyPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	schema := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("Failed to create test schema: %v", err)
+	}
+
+	conn, err := gorm.Open(postgres.Open(withSearchPath(dsn, schema)), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatalf("Failed to connect to test schema: %v", err)
+	}
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() {
+		db.DB = previous
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// withSearchPath makes every connection of dsn, a URL or key=value string,
+// use schema
+func withSearchPath(dsn, schema string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return dsn + " search_path=" + schema
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -1,37 +1,111 @@
 package utils
 
 import (
-	"os"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/Nyagar-Abraham/chat-app/models"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-var JwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// AccessTokenTTL is the lifetime of the JWTs issued by GenerateToken.
+// Clients keep their session alive with a refresh token instead.
+const AccessTokenTTL = 15 * time.Minute
 
+// RefreshTokenTTL is the lifetime of an opaque refresh token
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// Token uses other than regular access tokens. Such tokens are rejected
+// everywhere except on the endpoints that explicitly accept them.
+const (
+	// TokenUseMFAChallenge is issued after a correct password when the second factor is still due
+	TokenUseMFAChallenge = "mfa_challenge"
+	// TokenUseMFAEnrollment only allows enrolling in 2FA when the tenant requires it
+	TokenUseMFAEnrollment = "mfa_enrollment"
+)
+
+// MFATokenTTL is the lifetime of challenge and enrollment tokens
+const MFATokenTTL = 5 * time.Minute
+
+// Claims are the JWT claims of an access token. RegisteredClaims.ID carries
+// the jti used for revocation, and TokenVersion must match the user's current
+// token version for the token to be accepted.
 type Claims struct {
-	UserID   string `json:"user_id"`
-	TenantID string `json:"tenant_id"`
-	Role     string `json:"role"`
+	UserID       string `json:"user_id"`
+	TenantID     string `json:"tenant_id"`
+	Role         string `json:"role"`
+	TokenVersion int    `json:"ver"`
+	TokenUse     string `json:"token_use,omitempty"`
+	// PlatformAdmin is only set for platform operators, see middleware.RequirePlatformAdmin
+	PlatformAdmin bool `json:"platform_admin,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateToken(user models.User) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+	return generateToken(user, "", AccessTokenTTL)
+}
+
+// GenerateMFAToken issues a short-lived token restricted to the given use
+func GenerateMFAToken(user models.User, use string) (string, error) {
+	return generateToken(user, use, MFATokenTTL)
+}
+
+func generateToken(user models.User, use string, ttl time.Duration) (string, error) {
+	now := time.Now()
 	claims := &Claims{
-		UserID:   user.ID,
-		TenantID: user.TenantID,
-		Role:     string(user.Role),
+		UserID:        user.ID,
+		TenantID:      user.TenantID,
+		Role:          string(user.Role),
+		TokenVersion:  user.TokenVersion,
+		TokenUse:      use,
+		PlatformAdmin: user.PlatformAdmin,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtSecret)
+	if keySet == nil {
+		return "", ErrNoSigningKey
+	}
+	return keySet.sign(claims, now)
+}
+
+// ParseToken validates a token's algorithm, key id, signature and expiry and
+// returns its claims
+func ParseToken(tokenString string) (*Claims, error) {
+	if keySet == nil {
+		return nil, ErrNoSigningKey
+	}
+	claims := &Claims{}
+	token, err := keySet.parse(tokenString, claims)
 	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// NewOpaqueToken returns a random, URL-safe token suitable for refresh,
+// reset or invitation links. Only its HashToken digest should be stored.
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
-	return tokenString, nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest of an opaque token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,440 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported values of JWT_SIGNING_ALG
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	// defaultKeyRotationInterval is how often a generated key is replaced
+	defaultKeyRotationInterval = 24 * time.Hour
+	// defaultKeyPublishLead is how long a new key is published in the JWKS
+	// before it signs anything, so verifiers caching the JWKS pick it up first.
+	// It must exceed JWKSCacheMaxAge.
+	defaultKeyPublishLead = 10 * time.Minute
+	// keyMaintenanceInterval is how often keys are rotated, reloaded and pruned
+	keyMaintenanceInterval = time.Minute
+	// JWKSCacheMaxAge is the Cache-Control max-age of the JWKS endpoint
+	JWKSCacheMaxAge = 5 * time.Minute
+)
+
+var (
+	ErrEmptyJWTSecret    = errors.New("JWT_SECRET must be set when signing with HS256")
+	ErrNoSigningKey      = errors.New("no active JWT signing key")
+	ErrUnknownSigningKey = errors.New("unknown JWT signing key")
+)
+
+// SigningKey is one key of the KeySet. A key signs tokens from ActiveAt until
+// a newer key becomes active, and keeps verifying them for the lifetime of
+// the last token it signed.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	ActiveAt  time.Time
+	// VerifyOnly keys (e.g. JWT_PREVIOUS_SECRETS) never sign
+	VerifyOnly bool
+	// key is a []byte secret, *rsa.PrivateKey or ed25519.PrivateKey
+	key interface{}
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *SigningKey) verificationKey() interface{} {
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	}
+	return k.key
+}
+
+// KeySet holds the keys used to sign and verify our JWTs. All keys share the
+// configured algorithm; tokens signed with any other algorithm are rejected.
+//
+// Keys come from one of three sources:
+//   - HS256: JWT_SECRET signs, JWT_PREVIOUS_SECRETS (comma separated) still verify
+//   - RS256/EdDSA with JWT_KEYS_DIR: one PEM private key per file, named <kid>.pem.
+//     A file starts signing JWT_KEY_PUBLISH_LEAD after its modification time and
+//     verifies until it is removed. Use this when running more than one instance.
+//   - RS256/EdDSA without JWT_KEYS_DIR: keys are generated in memory and rotated
+//     every JWT_KEY_ROTATION_INTERVAL. Tokens do not survive a restart.
+type KeySet struct {
+	mu               sync.RWMutex
+	algorithm        string
+	keys             []*SigningKey
+	retiredAt        map[string]time.Time
+	dir              string
+	rotationInterval time.Duration
+	publishLead      time.Duration
+}
+
+var keySet *KeySet
+
+// InitKeys loads the signing keys from the environment and starts their
+// rotation. It must be called after the environment has been loaded.
+func InitKeys() error {
+	ks, err := NewKeySetFromEnv()
+	if err != nil {
+		return err
+	}
+	keySet = ks
+	go func() {
+		for now := range time.Tick(keyMaintenanceInterval) {
+			if err := ks.maintain(now); err != nil {
+				slog.Error("JWT key maintenance failed", "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// SetKeySet replaces the signing keys without starting their rotation, for tests
+func SetKeySet(ks *KeySet) {
+	keySet = ks
+}
+
+// NewKeySetFromEnv builds a KeySet from JWT_SIGNING_ALG and related variables
+func NewKeySetFromEnv() (*KeySet, error) {
+	algorithm := os.Getenv("JWT_SIGNING_ALG")
+	if algorithm == "" {
+		algorithm = AlgHS256
+	}
+	rotation, err := durationEnv("JWT_KEY_ROTATION_INTERVAL", defaultKeyRotationInterval)
+	if err != nil {
+		return nil, err
+	}
+	lead, err := durationEnv("JWT_KEY_PUBLISH_LEAD", defaultKeyPublishLead)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{
+		algorithm:        algorithm,
+		retiredAt:        map[string]time.Time{},
+		dir:              os.Getenv("JWT_KEYS_DIR"),
+		rotationInterval: rotation,
+		publishLead:      lead,
+	}
+
+	switch algorithm {
+	case AlgHS256:
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, ErrEmptyJWTSecret
+		}
+		var previous []string
+		for _, s := range strings.Split(os.Getenv("JWT_PREVIOUS_SECRETS"), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				previous = append(previous, s)
+			}
+		}
+		ks.keys = hmacKeys(secret, previous)
+		return ks, nil
+	case AlgRS256, AlgEdDSA:
+		if ks.dir != "" {
+			return ks, ks.reload(time.Now())
+		}
+		key, err := generateSigningKey(algorithm, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		ks.keys = []*SigningKey{key}
+		return ks, nil
+	}
+	return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", algorithm)
+}
+
+func hmacKeys(secret string, previous []string) []*SigningKey {
+	keys := []*SigningKey{{ID: hmacKeyID(secret), Algorithm: AlgHS256, key: []byte(secret)}}
+	for _, s := range previous {
+		keys = append(keys, &SigningKey{ID: hmacKeyID(s), Algorithm: AlgHS256, key: []byte(s), VerifyOnly: true})
+	}
+	return keys
+}
+
+// hmacKeyID derives a stable kid from the secret so all instances agree
+func hmacKeyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "hs-" + hex.EncodeToString(sum[:8])
+}
+
+func generateSigningKey(algorithm string, activeAt time.Time) (*SigningKey, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	key := &SigningKey{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		Algorithm: algorithm,
+		ActiveAt:  activeAt,
+	}
+	var err error
+	switch algorithm {
+	case AlgRS256:
+		key.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, key.key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("cannot generate %s keys", algorithm)
+	}
+	return key, err
+}
+
+// current returns the key that signs new tokens at time now
+func (ks *KeySet) current(now time.Time) (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var current *SigningKey
+	for _, key := range ks.keys {
+		if key.VerifyOnly || key.ActiveAt.After(now) {
+			continue
+		}
+		if current == nil || key.ActiveAt.After(current.ActiveAt) {
+			current = key
+		}
+	}
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+	return current, nil
+}
+
+func (ks *KeySet) lookup(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (ks *KeySet) sign(claims jwt.Claims, now time.Time) (string, error) {
+	key, err := ks.current(now)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.key)
+}
+
+func (ks *KeySet) parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{ks.algorithm}))
+	return parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.lookup(kid)
+		if !ok || key.Algorithm != token.Method.Alg() {
+			return nil, ErrUnknownSigningKey
+		}
+		return key.verificationKey(), nil
+	})
+}
+
+// maintain rotates generated keys, reloads JWT_KEYS_DIR and drops keys that
+// can no longer have signed an unexpired token
+func (ks *KeySet) maintain(now time.Time) error {
+	if ks.algorithm == AlgHS256 {
+		return nil
+	}
+	if ks.dir != "" {
+		return ks.reload(now)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	newest := ks.keys[len(ks.keys)-1]
+	if now.Sub(newest.ActiveAt) >= ks.rotationInterval {
+		key, err := generateSigningKey(ks.algorithm, now.Add(ks.publishLead))
+		if err != nil {
+			return err
+		}
+		ks.keys = append(ks.keys, key)
+	}
+	ks.prune(now)
+	return nil
+}
+
+// prune removes keys superseded for longer than the lifetime of a token.
+// The caller must hold ks.mu.
+func (ks *KeySet) prune(now time.Time) {
+	kept := ks.keys[:0]
+	for i, key := range ks.keys {
+		if i+1 < len(ks.keys) && !ks.keys[i+1].ActiveAt.After(now) {
+			// the next key has taken over signing
+			retired, ok := ks.retiredAt[key.ID]
+			if !ok {
+				retired = ks.keys[i+1].ActiveAt
+				ks.retiredAt[key.ID] = retired
+			}
+			if now.Sub(retired) > AccessTokenTTL {
+				delete(ks.retiredAt, key.ID)
+				continue
+			}
+		}
+		kept = append(kept, key)
+	}
+	ks.keys = kept
+}
+
+// reload replaces the keys with the contents of JWT_KEYS_DIR
+func (ks *KeySet) reload(now time.Time) error {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return err
+	}
+	var keys []*SigningKey
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		key, err := loadPEMKey(filepath.Join(ks.dir, entry.Name()), ks.algorithm)
+		if err != nil {
+			return err
+		}
+		key.ID = strings.TrimSuffix(entry.Name(), ".pem")
+		key.ActiveAt = info.ModTime().Add(ks.publishLead)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no .pem keys found in %s", ks.dir)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ActiveAt.Before(keys[j].ActiveAt) })
+	// a single key, or keys that were all just deployed, sign right away
+	if keys[0].ActiveAt.After(now) {
+		keys[0].ActiveAt = now
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+	return nil
+}
+
+func loadPEMKey(path, algorithm string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == AlgRS256 {
+			return &SigningKey{Algorithm: algorithm, key: key}, nil
+		}
+	case ed25519.PrivateKey:
+		if algorithm == AlgEdDSA {
+			return &SigningKey{Algorithm: algorithm, key: key}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: key type does not match %s", path, algorithm)
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is the body of /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns the public keys that verify our tokens, including keys
+// that are published but not yet signing. HMAC secrets are never published.
+func PublicJWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	if keySet == nil {
+		return set
+	}
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+	for _, key := range keySet.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch pub := key.verificationKey().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
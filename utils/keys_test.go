@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySetRotation(t *testing.T) {
+	start := time.Now()
+	first, err := generateSigningKey(AlgEdDSA, start)
+	require.NoError(t, err)
+	ks := &KeySet{
+		algorithm:        AlgEdDSA,
+		keys:             []*SigningKey{first},
+		retiredAt:        map[string]time.Time{},
+		rotationInterval: time.Hour,
+		publishLead:      10 * time.Minute,
+	}
+
+	old, err := ks.sign(&Claims{UserID: "u1"}, start)
+	require.NoError(t, err)
+
+	// a new key is published but keeps signing with the old one during the lead
+	rotateAt := start.Add(time.Hour)
+	require.NoError(t, ks.maintain(rotateAt))
+	require.Len(t, ks.keys, 2)
+	current, err := ks.current(rotateAt.Add(5 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, current.ID)
+
+	current, err = ks.current(rotateAt.Add(11 * time.Minute))
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, current.ID)
+
+	// the old key still verifies until its last token has expired
+	require.NoError(t, ks.maintain(rotateAt.Add(11*time.Minute)))
+	_, err = ks.parse(old, &Claims{})
+	require.NoError(t, err)
+
+	require.NoError(t, ks.maintain(rotateAt.Add(10*time.Minute+AccessTokenTTL+time.Minute)))
+	require.Len(t, ks.keys, 1)
+	_, err = ks.parse(old, &Claims{})
+	assert.Error(t, err)
+}
+
+func TestKeySetRejectsOtherAlgorithms(t *testing.T) {
+	key, err := generateSigningKey(AlgRS256, time.Now())
+	require.NoError(t, err)
+	ks := &KeySet{algorithm: AlgRS256, keys: []*SigningKey{key}, retiredAt: map[string]time.Time{}}
+
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}}
+
+	// HS256 signed with the public key bytes is the classic confusion attack
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = key.ID
+	signed, err := hs.SignedString([]byte(key.ID))
+	require.NoError(t, err)
+	_, err = ks.parse(signed, &Claims{})
+	assert.Error(t, err)
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = key.ID
+	signed, err = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = ks.parse(signed, &Claims{})
+	assert.Error(t, err)
+
+	// tokens without a known kid are rejected
+	rs := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	signed, err = rs.SignedString(key.key)
+	require.NoError(t, err)
+	_, err = ks.parse(signed, &Claims{})
+	assert.Error(t, err)
+}
+
+func TestNewKeySetFromEnvRequiresSecret(t *testing.T) {
+	t.Setenv("JWT_SIGNING_ALG", "")
+	t.Setenv("JWT_SECRET", "")
+	_, err := NewKeySetFromEnv()
+	assert.ErrorIs(t, err, ErrEmptyJWTSecret)
+
+	t.Setenv("JWT_SECRET", "current")
+	t.Setenv("JWT_PREVIOUS_SECRETS", "old")
+	ks, err := NewKeySetFromEnv()
+	require.NoError(t, err)
+
+	previous := &KeySet{algorithm: AlgHS256, keys: hmacKeys("old", nil)}
+	token, err := previous.sign(&Claims{UserID: "u1"}, time.Now())
+	require.NoError(t, err)
+	_, err = ks.parse(token, &Claims{})
+	assert.NoError(t, err)
+	current, err := ks.current(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, hmacKeyID("current"), current.ID)
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in requests, responses and calls to
+// other services
+const RequestIDHeader = "X-Request-ID"
+
+type logContextKey struct{}
+
+// logContext is what a request adds to every log record made with its context
+type logContext struct {
+	requestID string
+	attrs     []slog.Attr
+}
+
+// WithRequestID returns ctx tagged with a request ID that is logged with
+// every record made with the context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	lc := logContextFrom(ctx)
+	lc.requestID = id
+	return context.WithValue(ctx, logContextKey{}, &lc)
+}
+
+// RequestIDFromContext returns the request ID of ctx, if any
+func RequestIDFromContext(ctx context.Context) string {
+	return logContextFrom(ctx).requestID
+}
+
+// WithLogAttrs returns ctx with attrs added to every record logged with it,
+// e.g. the user once the request is authenticated
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	lc := logContextFrom(ctx)
+	lc.attrs = append(append([]slog.Attr{}, lc.attrs...), attrs...)
+	return context.WithValue(ctx, logContextKey{}, &lc)
+}
+
+func logContextFrom(ctx context.Context) logContext {
+	if lc, ok := ctx.Value(logContextKey{}).(*logContext); ok {
+		return *lc
+	}
+	return logContext{}
+}
+
+// contextHandler adds the request ID, trace and attributes of the context to
+// records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		lc := logContextFrom(ctx)
+		if lc.requestID != "" {
+			r.AddAttrs(slog.String("request_id", lc.requestID))
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+		r.AddAttrs(lc.attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// NewLogger returns a logger writing to w in format ("json" or "text") from
+// level ("debug", "info", "warn" or "error") up
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// InitLogger makes the logger writing to stderr at level in format the
+// default, which the log package writes through as well
+func InitLogger(level, format string) error {
+	logger, err := NewLogger(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLoggerAddsContextAttributes(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, "info", "json")
+	assert.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithLogAttrs(ctx, slog.String("user_id", "u1"), slog.String("tenant_id", "t1"))
+	logger.InfoContext(ctx, "hello", "status", 200)
+	logger.DebugContext(ctx, "below the level")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "hello", record["msg"])
+	assert.Equal(t, "req-1", record["request_id"])
+	assert.Equal(t, "u1", record["user_id"])
+	assert.Equal(t, "t1", record["tenant_id"])
+	assert.Equal(t, "req-1", RequestIDFromContext(ctx))
+}
+
+func TestLoggerAddsTraceIDs(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, "info", "json")
+	assert.NoError(t, err)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+		SpanID:  trace.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+	})
+	logger.InfoContext(trace.ContextWithSpanContext(context.Background(), sc), "traced")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", record["trace_id"])
+	assert.Equal(t, "b7ad6b7169203331", record["span_id"])
+}
+
+func TestNewLoggerRejectsUnknownSettings(t *testing.T) {
+	_, err := NewLogger(&bytes.Buffer{}, "loud", "json")
+	assert.Error(t, err)
+	_, err = NewLogger(&bytes.Buffer{}, "info", "xml")
+	assert.Error(t, err)
+}